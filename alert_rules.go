@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger"
+	"github.com/denistv/wdlogger/wrappers/stdwrap"
+)
+
+// runAlertRules выводит правила оповещений Prometheus для метрик экспортера и возвращает код завершения
+func runAlertRules(_ context.Context, args []string) int {
+	fs := flag.NewFlagSet("alert-rules", flag.ContinueOnError)
+
+	cfg := services.NewDefaultPrometheusRulesConfig()
+
+	fs.StringVar(&cfg.Job, "job", cfg.Job, "name of the Prometheus job that scrapes the exporter")
+	fs.DurationVar(&cfg.ExporterDownFor, "exporter-down-for", cfg.ExporterDownFor, "how long the exporter may be down")
+	fs.DurationVar(&cfg.PullFailingFor, "pull-failing-for", cfg.PullFailingFor, "how long MyHeat API pulls may fail")
+	fs.Float64Var(&cfg.RoomBelowTarget, "room-below-target", cfg.RoomBelowTarget, "how many degrees a room may be below its target")
+	fs.DurationVar(&cfg.RoomBelowTargetFor, "room-below-target-for", cfg.RoomBelowTargetFor, "how long a room may be below its target")
+	fs.DurationVar(&cfg.LowBalanceFor, "low-balance-for", cfg.LowBalanceFor, "how long the low balance severity may last")
+	fs.DurationVar(&cfg.DataNotActualFor, "data-not-actual-for", cfg.DataNotActualFor, "how long device data may be not actual")
+	output := fs.String("output", "", "output file, stdout if empty")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	if err := cfg.Validate(); err != nil {
+		logger.Error("validating alert rules config", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	data, err := services.GeneratePrometheusRules(cfg)
+	if err != nil {
+		logger.Error("generating alert rules", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+
+	if err != nil {
+		logger.Error("writing alert rules", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
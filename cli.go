@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/wdlogger"
+	"github.com/denistv/wdlogger/wrappers/stdwrap"
+)
+
+// Подкоманды для отладки. Используют ту же конфигурацию из переменных окружения, что и сервер
+
+// runDevices выводит список устройств аккаунта
+//
+//	devices list
+func runDevices(ctx context.Context, args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Fprintln(os.Stderr, "usage: myheat-exporter devices list")
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+	client := myheat.NewClient(loadMyHeatClientConfig(logger), logger)
+
+	res, err := client.GetDevices(ctx)
+	if err != nil {
+		logger.Error("getting devices", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tCITY\tSEVERITY\tDESCRIPTION")
+
+	for _, d := range res.Data["devices"] {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\n", d.ID, d.Name, d.City, d.Severity, d.SeverityDesc)
+	}
+
+	if err = w.Flush(); err != nil {
+		logger.Error("writing devices", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
+
+// runDevice выводит данные устройства в JSON
+//
+//	device info [--raw] <id>
+func runDevice(ctx context.Context, args []string) int {
+	if len(args) == 0 || args[0] != "info" {
+		fmt.Fprintln(os.Stderr, "usage: myheat-exporter device info [--raw] <id>")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("device info", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "print the API response as is, without parsing")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	if fs.NArg() != 1 {
+		logger.Error("device id is required")
+		return 1
+	}
+
+	id, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		logger.Error("parsing device id", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	client := myheat.NewClient(loadMyHeatClientConfig(logger), logger)
+
+	var data []byte
+
+	if *raw {
+		data, err = client.GetDeviceInfoRaw(ctx, id)
+	} else {
+		var res myheat.GetDeviceInfoResponse
+		if res, err = client.GetDeviceInfo(ctx, id); err == nil {
+			data, err = json.Marshal(res)
+		}
+	}
+
+	if err != nil {
+		logger.Error("getting device info", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	out := &bytes.Buffer{}
+	if err = json.Indent(out, data, "", "  "); err != nil {
+		// Некорректный JSON от API выводится как есть: ради этого и нужен --raw
+		out.Reset()
+		out.Write(data)
+	}
+
+	out.WriteString("\n")
+
+	if _, err = out.WriteTo(os.Stdout); err != nil {
+		logger.Error("writing device info", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
+
+// runCheckCredentials проверяет MYHEAT_LOGIN и MYHEAT_KEY запросом списка устройств
+func runCheckCredentials(ctx context.Context, _ []string) int {
+	logger := stdwrap.NewSTDWrapper()
+	client := myheat.NewClient(loadMyHeatClientConfig(logger), logger)
+
+	res, err := client.GetDevices(ctx)
+	if err != nil {
+		logger.Error("credentials check failed", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	fmt.Printf("credentials are valid, devices: %d\n", len(res.Data["devices"]))
+
+	return 0
+}
+
+// runTariff выводит тариф, который выбирается в заданный момент
+//
+//	tariff now [--at <время>]
+func runTariff(_ context.Context, args []string) int {
+	if len(args) == 0 || args[0] != "now" {
+		fmt.Fprintln(os.Stderr, "usage: myheat-exporter tariff now [--at <time>]")
+		return 1
+	}
+
+	fs := flag.NewFlagSet("tariff now", flag.ContinueOnError)
+	atRaw := fs.String("at", "", "time in RFC3339 or HH:MM (today), current time if empty")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	loadTimezone(logger)
+
+	at := time.Now()
+
+	if *atRaw != "" {
+		var err error
+		if at, err = parseTariffTime(*atRaw, at); err != nil {
+			logger.Error("parsing time", wdlogger.NewErrorField("error", err))
+			return 1
+		}
+	}
+
+	tariff := loadTariffSelector(logger).SelectAt(at)
+
+	fmt.Printf("%s\t%s\n", at.Format(time.RFC3339), tariff)
+
+	return 0
+}
+
+// parseTariffTime разбирает время в RFC 3339 или время суток HH:MM для дня now. Время возвращается в часовом
+// поясе now, так как тариф выбирается по часу в этом поясе
+func parseTariffTime(raw string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(now.Location()), nil
+	}
+
+	hm, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, expected RFC3339 or HH:MM", strconv.Quote(raw))
+	}
+
+	y, m, d := now.Date()
+
+	return time.Date(y, m, d, hm.Hour(), hm.Minute(), 0, 0, now.Location()), nil
+}
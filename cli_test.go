@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+)
+
+func TestParseTariffTime(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		raw     string
+		want    time.Time
+		wantErr bool
+	}{
+		{raw: "23:30", want: time.Date(2024, time.January, 1, 23, 30, 0, 0, time.UTC)},
+		{raw: "2024-02-01T05:00:00Z", want: time.Date(2024, time.February, 1, 5, 0, 0, 0, time.UTC)},
+		{raw: "25:00", wantErr: true},
+		{raw: "завтра", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTariffTime(tt.raw, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTariffTime(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTariffTime(%q) = %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestParseTariffTime_Location(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2024, time.January, 1, 12, 0, 0, 0, moscow)
+
+	// Ночной тариф с 23 до 7 по московскому времени
+	ts := services.NewTariffSelector(time.Now, []services.Tariff{services.NewNightTariff(23, 7)})
+
+	// Один и тот же момент с разным смещением
+	for _, raw := range []string{"2024-01-01T21:30:00Z", "2024-01-02T00:30:00+03:00"} {
+		at, err := parseTariffTime(raw, now)
+		if err != nil {
+			t.Fatalf("parseTariffTime(%q) error = %v", raw, err)
+		}
+
+		if at.Location() != moscow {
+			t.Errorf("parseTariffTime(%q) location = %v, want %v", raw, at.Location(), moscow)
+		}
+
+		if got := ts.SelectAt(at); got != services.TariffTwo {
+			t.Errorf("SelectAt(%q) = %v, want %v", raw, got, services.TariffTwo)
+		}
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	got, err := parseIDs(" 10, 20,,")
+	if err != nil || len(got) != 2 || got[0] != 10 || got[1] != 20 {
+		t.Errorf("parseIDs() = %v, %v", got, err)
+	}
+
+	if _, err = parseIDs("10,abc"); err == nil {
+		t.Errorf("parseIDs() expected error for invalid id")
+	}
+}
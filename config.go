@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/recording"
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/myheat-prometheus-exporter/internal/simulation"
+	"github.com/denistv/wdlogger"
+)
+
+// Общая загрузка конфигурации из переменных окружения. Используется сервером и подкомандами
+
+func loadTimezone(logger wdlogger.Logger) {
+	tz := os.Getenv("TZ")
+
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+
+		time.Local = loc
+	}
+}
+
+func loadMyHeatClientConfig(logger wdlogger.Logger) myheat.Config {
+	clientCfg := myheat.NewDefaultConfig()
+	clientCfg.Login = os.Getenv("MYHEAT_LOGIN")
+	clientCfg.Key = os.Getenv("MYHEAT_KEY")
+
+	if endpointURL := os.Getenv("MYHEAT_ENDPOINT_URL"); endpointURL != "" {
+		clientCfg.EndpointURL = endpointURL
+	}
+
+	if recordDir := os.Getenv("MYHEAT_RECORD_DIR"); recordDir != "" {
+		rec, err := recording.NewRecorder(recordDir, nil, logger)
+		if err != nil {
+			logger.Fatal("creating API recorder", wdlogger.NewErrorField("error", err))
+		}
+
+		clientCfg.Transport = rec
+
+		logger.Info("recording MyHeat API responses", wdlogger.NewStringField("dir", recordDir))
+	}
+
+	if err := clientCfg.Validate(); err != nil {
+		logger.Fatal("validating MyHeat client config", wdlogger.NewErrorField("error", err))
+	}
+
+	return clientCfg
+}
+
+func loadStaleDataPolicy(logger wdlogger.Logger) services.StaleDataPolicy {
+	raw := os.Getenv("MYHEAT_STALE_DATA_POLICY")
+	if raw == "" {
+		return services.StaleDataMark
+	}
+
+	policy, err := services.ParseStaleDataPolicy(raw)
+	if err != nil {
+		logger.Fatal("parsing MYHEAT_STALE_DATA_POLICY", wdlogger.NewErrorField("error", err))
+	}
+
+	return policy
+}
+
+// loadFilterConfig загружает фильтр устройств и помещений. Без MYHEAT_FILTER_CONFIG_FILE экспортируется все
+func loadFilterConfig(logger wdlogger.Logger) services.FilterConfig {
+	path := os.Getenv("MYHEAT_FILTER_CONFIG_FILE")
+	if path == "" {
+		return services.FilterConfig{}
+	}
+
+	cfg, err := services.LoadFilterConfig(path)
+	if err != nil {
+		logger.Fatal("loading filter config", wdlogger.NewErrorField("error", err))
+	}
+
+	return cfg
+}
+
+// loadLabelsConfig загружает алиасы и дополнительные лейблы устройств и помещений из MYHEAT_LABELS_CONFIG_FILE
+func loadLabelsConfig(logger wdlogger.Logger) services.LabelsConfig {
+	path := os.Getenv("MYHEAT_LABELS_CONFIG_FILE")
+	if path == "" {
+		return services.LabelsConfig{}
+	}
+
+	cfg, err := services.LoadLabelsConfig(path)
+	if err != nil {
+		logger.Fatal("loading labels config", wdlogger.NewErrorField("error", err))
+	}
+
+	return cfg
+}
+
+func loadDutyCycleConfig(logger wdlogger.Logger) services.DutyCycleConfig {
+	cfg := services.NewDefaultDutyCycleConfig()
+
+	if raw := os.Getenv("MYHEAT_DUTY_CYCLE_WINDOWS"); raw != "" {
+		cfg.Windows = nil
+
+		for _, part := range strings.Split(raw, ",") {
+			w, err := time.ParseDuration(strings.TrimSpace(part))
+			if err != nil {
+				logger.Fatal("parsing MYHEAT_DUTY_CYCLE_WINDOWS", wdlogger.NewErrorField("error", err))
+			}
+
+			cfg.Windows = append(cfg.Windows, w)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("validating duty cycle config", wdlogger.NewErrorField("error", err))
+	}
+
+	return cfg
+}
+
+func loadDegreeDaysConfig(logger wdlogger.Logger) services.DegreeDaysConfig {
+	cfg := services.NewDefaultDegreeDaysConfig()
+
+	for env, dst := range map[string]*float64{
+		"MYHEAT_DEGREE_DAYS_BASE_TEMP": &cfg.BaseTemp,
+		"MYHEAT_HEATER_POWER_KW":       &cfg.HeaterPowerKW,
+	} {
+		if raw := os.Getenv(env); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				logger.Fatal("parsing "+env, wdlogger.NewErrorField("error", err))
+			}
+
+			*dst = v
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("validating degree days config", wdlogger.NewErrorField("error", err))
+	}
+
+	return cfg
+}
+
+func loadSimulationConfig(logger wdlogger.Logger) simulation.Config {
+	cfg := simulation.NewDefaultConfig()
+
+	for env, dst := range map[string]*int{
+		"MYHEAT_SIMULATION_DEVICES": &cfg.Devices,
+		"MYHEAT_SIMULATION_ROOMS":   &cfg.Rooms,
+	} {
+		if raw := os.Getenv(env); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil {
+				logger.Fatal("parsing "+env, wdlogger.NewErrorField("error", err))
+			}
+
+			*dst = v
+		}
+	}
+
+	for env, dst := range map[string]*float64{
+		"MYHEAT_SIMULATION_SPEED":             &cfg.Speed,
+		"MYHEAT_SIMULATION_OUTDOOR_MEAN":      &cfg.OutdoorMean,
+		"MYHEAT_SIMULATION_OUTDOOR_AMPLITUDE": &cfg.OutdoorAmplitude,
+	} {
+		if raw := os.Getenv(env); raw != "" {
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				logger.Fatal("parsing "+env, wdlogger.NewErrorField("error", err))
+			}
+
+			*dst = v
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("validating simulation config", wdlogger.NewErrorField("error", err))
+	}
+
+	return cfg
+}
+
+func loadTariffSelector(logger wdlogger.Logger) *services.TariffSelector {
+	tariffs := []services.Tariff{}
+
+	envTariff2FromRaw := os.Getenv("MYHEAT_TARIFF2_FROM")
+	envTariff2ToRaw := os.Getenv("MYHEAT_TARIFF2_TO")
+
+	if envTariff2FromRaw != "" && envTariff2ToRaw != "" {
+		tariff2From, err := strconv.ParseInt(envTariff2FromRaw, 10, 32)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+
+		tariff2To, err := strconv.ParseInt(envTariff2ToRaw, 10, 32)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+
+		nt := services.NewNightTariff(int(tariff2From), int(tariff2To))
+		tariffs = append(tariffs, nt)
+
+		logger.Info(
+			"night tariff applied",
+			wdlogger.NewInt64Field("from", tariff2From),
+			wdlogger.NewInt64Field("to", tariff2To),
+		)
+	}
+
+	return services.NewTariffSelector(time.Now, tariffs)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger"
+	"github.com/denistv/wdlogger/wrappers/stdwrap"
+)
+
+// runDashboard выводит JSON дэшборда Grafana для метрик экспортера и возвращает код завершения.
+// Набор тарифов берется из тех же переменных окружения, что и при работе экспортера
+func runDashboard(_ context.Context, args []string) int {
+	fs := flag.NewFlagSet("dashboard", flag.ContinueOnError)
+
+	cfg := services.NewDefaultDashboardConfig()
+
+	fs.StringVar(&cfg.Title, "title", cfg.Title, "dashboard title")
+	fs.StringVar(&cfg.UID, "uid", cfg.UID, "dashboard uid")
+	fs.StringVar(&cfg.Job, "job", cfg.Job, "name of the Prometheus job that scrapes the exporter")
+	fs.Float64Var(&cfg.HeaterPowerKW, "heater-kw", cfg.HeaterPowerKW, "heater power in kW")
+	pricesRaw := fs.String("tariff-prices", "", "price of kWh per tariff, e.g. 1=4.61,2=2.49")
+	devicesRaw := fs.String("device", "", "comma separated device ids, taken from Prometheus if empty")
+	output := fs.String("output", "", "output file, stdout if empty")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	cfg.Tariffs = loadTariffSelector(logger).TariffTypes()
+
+	prices, err := services.ParseLabels(*pricesRaw)
+	if err != nil {
+		logger.Error("parsing tariff prices", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	for tariffRaw, priceRaw := range prices {
+		tariff, err := strconv.ParseInt(tariffRaw, 10, 64)
+		if err != nil {
+			logger.Error("parsing tariff prices", wdlogger.NewErrorField("error", fmt.Errorf("invalid tariff %s", strconv.Quote(tariffRaw))))
+			return 1
+		}
+
+		price, err := strconv.ParseFloat(priceRaw, 64)
+		if err != nil {
+			logger.Error("parsing tariff prices", wdlogger.NewErrorField("error", fmt.Errorf("invalid price %s", strconv.Quote(priceRaw))))
+			return 1
+		}
+
+		cfg.TariffPrices[services.TariffType(tariff)] = price
+	}
+
+	if cfg.DeviceIDs, err = parseIDs(*devicesRaw); err != nil {
+		logger.Error("parsing devices", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	if err = cfg.Validate(); err != nil {
+		logger.Error("validating dashboard config", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	data, err := services.GenerateDashboard(cfg, services.MetricNames())
+	if err != nil {
+		logger.Error("generating dashboard", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	data = append(data, '\n')
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(*output, data, 0o644)
+	}
+
+	if err != nil {
+		logger.Error("writing dashboard", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
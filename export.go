@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/export"
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger"
+	"github.com/denistv/wdlogger/wrappers/stdwrap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	exportSourceHistory = "history"
+	exportSourcePull    = "pull"
+
+	exportReportTemperatures = "temperatures"
+	exportReportDemand       = "demand"
+	exportReportTariffs      = "tariffs"
+)
+
+// pullCollector сохраняет показания одного опроса для подкоманды export
+type pullCollector struct {
+	readings []history.Reading
+}
+
+func (c *pullCollector) HandlePull(_ context.Context, res services.PullResult) {
+	c.readings = append(c.readings, services.PullResultToReadings(res)...)
+}
+
+// runExport выгружает показания из локальной истории или разового опроса MyHeat API и возвращает код завершения
+func runExport(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+
+	defaultSource := exportSourcePull
+	if os.Getenv("MYHEAT_HISTORY_DB") != "" {
+		defaultSource = exportSourceHistory
+	}
+
+	now := time.Now()
+
+	formatRaw := fs.String("format", string(export.FormatCSV), "output format: csv, json or parquet")
+	report := fs.String("report", exportReportTemperatures, "report: temperatures, demand or tariffs")
+	source := fs.String("source", defaultSource, "data source: history (MYHEAT_HISTORY_DB) or pull (one-off MyHeat API pull)")
+	fromRaw := fs.String("from", now.Add(-24*time.Hour).Format(time.RFC3339), "start of the interval, RFC3339 or date (YYYY-MM-DD), history source only")
+	toRaw := fs.String("to", now.Format(time.RFC3339), "end of the interval, RFC3339 or date (YYYY-MM-DD), history source only")
+	maxGap := fs.Duration("max-gap", export.DefaultMaxGap, "heating interval ends at the last reading if the next one is further away")
+	devicesRaw := fs.String("device", "", "comma separated device ids, all devices if empty")
+	output := fs.String("output", "", "output file, stdout if empty")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	loadTimezone(logger)
+
+	// Разовый опрос возвращает только текущие показания, интервал к нему не применим
+	if *source == exportSourcePull {
+		intervalSet := false
+
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "from" || f.Name == "to" {
+				intervalSet = true
+			}
+		})
+
+		if intervalSet {
+			logger.Error("--from and --to are not supported with --source pull")
+			return 1
+		}
+	}
+
+	if *maxGap <= 0 {
+		logger.Error("--max-gap must be positive duration")
+		return 1
+	}
+
+	format, err := export.ParseFormat(*formatRaw)
+	if err != nil {
+		logger.Error("parsing format", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	from, err := parseExportTime(*fromRaw)
+	if err != nil {
+		logger.Error("parsing from", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	to, err := parseExportTime(*toRaw)
+	if err != nil {
+		logger.Error("parsing to", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	deviceIDs, err := parseIDs(*devicesRaw)
+	if err != nil {
+		logger.Error("parsing devices", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	var readings []history.Reading
+
+	switch *source {
+	case exportSourceHistory:
+		readings, err = historyReadings(ctx, os.Getenv("MYHEAT_HISTORY_DB"), history.ReadingsQuery{
+			ObjectType: history.ObjectEnv,
+			DeviceIDs:  deviceIDs,
+			From:       from,
+			To:         to,
+		})
+	case exportSourcePull:
+		readings, err = pullReadings(ctx, logger, deviceIDs)
+	default:
+		err = fmt.Errorf("unsupported source %q", *source)
+	}
+
+	if err != nil {
+		logger.Error("loading readings", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	var (
+		w    io.Writer = os.Stdout
+		file *os.File
+	)
+
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			logger.Error("creating output file", wdlogger.NewErrorField("error", err))
+			return 1
+		}
+
+		w = file
+	}
+
+	switch *report {
+	case exportReportTemperatures:
+		err = export.Write(w, format, export.TemperatureRows(readings))
+	case exportReportDemand:
+		err = export.Write(w, format, export.DemandIntervalRows(export.DemandIntervals(readings, *maxGap)))
+	case exportReportTariffs:
+		err = export.Write(w, format, export.TariffDurationRows(export.DemandIntervals(readings, *maxGap), loadTariffSelector(logger)))
+	default:
+		err = fmt.Errorf("unsupported report %q", *report)
+	}
+
+	if file != nil {
+		// Ошибка закрытия может означать, что данные не записались на диск
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		logger.Error("writing report", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
+
+func historyReadings(ctx context.Context, path string, q history.ReadingsQuery) ([]history.Reading, error) {
+	if path == "" {
+		return nil, errors.New("MYHEAT_HISTORY_DB is not set")
+	}
+
+	store, err := history.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening history database: %w", err)
+	}
+	defer store.Close()
+
+	return store.Readings(ctx, q)
+}
+
+// pullReadings выполняет один опрос MyHeat API. Интервалов нагрева в таком отчете не будет, только текущие показания
+func pullReadings(ctx context.Context, logger wdlogger.Logger, deviceIDs []int64) ([]history.Reading, error) {
+	clientCfg := loadMyHeatClientConfig(logger)
+
+	metricsService := services.NewMetrics(logger, loadTariffSelector(logger), services.LabelsConfig{}, prometheus.NewRegistry())
+	expCfg := services.NewExporterConfig(0)
+	expCfg.Filter = loadFilterConfig(logger)
+
+	exp := services.NewExporter(expCfg, myheat.NewSource(myheat.NewClient(clientCfg, logger)), logger, metricsService)
+
+	collector := &pullCollector{}
+	exp.Subscribe(collector)
+
+	if err := exp.Pull(ctx); err != nil {
+		return nil, err
+	}
+
+	if len(deviceIDs) == 0 {
+		return collector.readings, nil
+	}
+
+	wanted := make(map[int64]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		wanted[id] = true
+	}
+
+	out := make([]history.Reading, 0, len(collector.readings))
+
+	for _, r := range collector.readings {
+		if wanted[r.DeviceID] {
+			out = append(out, r)
+		}
+	}
+
+	return out, nil
+}
+
+func parseExportTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation(time.DateOnly, raw, time.Local)
+}
+
+// parseIDs разбирает список идентификаторов через запятую
+func parseIDs(raw string) ([]int64, error) {
+	ids := make([]int64, 0)
+
+	for _, s := range strings.Split(raw, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %s", strconv.Quote(s))
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
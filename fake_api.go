@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/myheattest"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/recording"
+	"github.com/denistv/wdlogger"
+	"github.com/denistv/wdlogger/wrappers/stdwrap"
+)
+
+// runFakeAPI запускает поддельный MyHeat API с демонстрационным домом. Экспортер подключается к нему через
+// MYHEAT_ENDPOINT_URL
+//
+//	fake-api [--listen :8090] [--login demo] [--key demo]
+func runFakeAPI(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("fake-api", flag.ContinueOnError)
+	listen := fs.String("listen", ":8090", "address to listen on")
+	login := fs.String("login", "demo", "accepted MYHEAT_LOGIN")
+	key := fs.String("key", "demo", "accepted MYHEAT_KEY")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	api := myheattest.NewHandler(*login, *key)
+	addDemoDevice(api)
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				updateDemoDevice(api, now)
+			}
+		}
+	}()
+
+	return serveFakeAPI(ctx, logger, *listen, api)
+}
+
+const demoDeviceID = 1
+
+func addDemoDevice(api *myheattest.Server) {
+	api.AddDevice(
+		myheat.Device{ID: demoDeviceID, Name: "Демо", City: "Москва", Severity: myheat.DevSeverityNormal},
+		myheat.DeviceInfo{
+			City:        "Москва",
+			DataActual:  true,
+			Severity:    myheat.DevSeverityNormal,
+			WeatherTemp: myheat.NewFlexFloat(-7),
+			Envs: []myheat.Env{
+				{ID: 1, Name: "Гостиная", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(21.5), Target: myheat.NewFlexFloat(22)},
+				{ID: 2, Name: "Спальня", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(20), Target: myheat.NewFlexFloat(20)},
+				{ID: 3, Name: "Кухня", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(22.5), Target: myheat.NewFlexFloat(21)},
+			},
+			Heaters: []myheat.Heater{{ID: 1, Name: "Котел"}},
+		},
+	)
+}
+
+// updateDemoDevice изменяет температуры по кругу, чтобы на графиках было видно включение и выключение нагрева
+func updateDemoDevice(api *myheattest.Server, now time.Time) {
+	api.Update(func(d *myheattest.Data) {
+		info := d.Infos[demoDeviceID]
+		phase := float64(now.Unix()%3600) / 3600 * 2 * math.Pi
+
+		info.WeatherTemp = myheat.NewFlexFloat(-7 + 3*math.Sin(phase))
+
+		heating := false
+
+		for i := range info.Envs {
+			env := &info.Envs[i]
+			env.Value = myheat.NewFlexFloat(math.Round((env.Target.Value+math.Sin(phase+float64(i)))*100) / 100)
+			env.Demand = env.Value.Value < env.Target.Value
+
+			heating = heating || env.Demand
+		}
+
+		info.Heaters[0].BurnerHeating = heating
+		d.Infos[demoDeviceID] = info
+	})
+}
+
+// runReplay отдает ответы MyHeat API, записанные через MYHEAT_RECORD_DIR. Экспортер подключается к нему через
+// MYHEAT_ENDPOINT_URL
+//
+//	replay --dir <каталог> [--listen :8090]
+func runReplay(ctx context.Context, args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	dir := fs.String("dir", "", "directory with recorded responses")
+	listen := fs.String("listen", ":8090", "address to listen on")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 1
+	}
+
+	if *dir == "" {
+		fmt.Fprintln(os.Stderr, "usage: myheat-exporter replay --dir <dir> [--listen :8090]")
+		return 1
+	}
+
+	logger := stdwrap.NewSTDWrapper()
+
+	replayer, err := recording.LoadReplayer(*dir)
+	if err != nil {
+		logger.Error("loading recordings", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return serveFakeAPI(ctx, logger, *listen, replayer)
+}
+
+// serveFakeAPI обслуживает поддельный API до отмены ctx
+func serveFakeAPI(ctx context.Context, logger wdlogger.Logger, addr string, handler http.Handler) int {
+	srv := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	logger.Info("fake MyHeat API started", wdlogger.NewStringField("addr", addr))
+
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("fake MyHeat API failed", wdlogger.NewErrorField("error", err))
+		return 1
+	}
+
+	return 0
+}
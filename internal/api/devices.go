@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger"
+)
+
+func NewDevicesHandler(states *services.StateStore, staleAfter time.Duration, l wdlogger.Logger) *DevicesHandler {
+	return &DevicesHandler{
+		states:      states,
+		staleAfter:  staleAfter,
+		logger:      l,
+		timeNowFunc: time.Now,
+	}
+}
+
+// DevicesHandler отдает данные последнего опроса MyHeat API.
+//
+//	GET /api/devices
+//	GET /api/devices/{id}
+//
+// Устройство считается устаревшим (stale), если его данные не удалось получить в последнем опросе
+// или они старше staleAfter
+type DevicesHandler struct {
+	states      *services.StateStore
+	staleAfter  time.Duration
+	logger      wdlogger.Logger
+	timeNowFunc func() time.Time
+}
+
+// Register добавляет маршруты обработчика в mux
+func (h *DevicesHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/devices", h.handleDevices)
+	mux.HandleFunc("/api/devices/", h.handleDevice)
+}
+
+type devicesResponse struct {
+	// LastPull время последнего успешного опроса, null если опросов еще не было
+	LastPull *time.Time       `json:"lastPull"`
+	Devices  []deviceResponse `json:"devices"`
+}
+
+type deviceResponse struct {
+	ID           int64            `json:"id"`
+	Name         string           `json:"name"`
+	City         string           `json:"city"`
+	Severity     int64            `json:"severity"`
+	SeverityDesc string           `json:"severityDesc"`
+	WeatherTemp  *float64         `json:"weatherTemp"`
+	DataActual   bool             `json:"dataActual"`
+	Alarms       []interface{}    `json:"alarms"`
+	UpdatedAt    time.Time        `json:"updatedAt"`
+	AgeSeconds   float64          `json:"ageSeconds"`
+	Stale        bool             `json:"stale"`
+	Envs         []envResponse    `json:"envs"`
+	Heaters      []heaterResponse `json:"heaters"`
+}
+
+type envResponse struct {
+	ID           int64    `json:"id"`
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Value        *float64 `json:"value"`
+	Target       *float64 `json:"target"`
+	Demand       bool     `json:"demand"`
+	Severity     int64    `json:"severity"`
+	SeverityDesc string   `json:"severityDesc"`
+}
+
+// heaterResponse числовые показания котла равны null, если MyHeat их не передал
+type heaterResponse struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	Disabled      bool     `json:"disabled"`
+	BurnerHeating bool     `json:"burnerHeating"`
+	BurnerWater   bool     `json:"burnerWater"`
+	FlowTemp      *float64 `json:"flowTemp"`
+	ReturnTemp    *float64 `json:"returnTemp"`
+	Pressure      *float64 `json:"pressure"`
+	Modulation    *float64 `json:"modulation"`
+	TargetTemp    *float64 `json:"targetTemp"`
+}
+
+func (h *DevicesHandler) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, h.logger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	lastPull := h.states.LastPull()
+	now := h.timeNowFunc()
+
+	res := devicesResponse{Devices: make([]deviceResponse, 0)}
+
+	if !lastPull.IsZero() {
+		res.LastPull = &lastPull
+	}
+
+	for _, d := range h.states.Devices() {
+		res.Devices = append(res.Devices, h.newDeviceResponse(d, lastPull, now))
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, res)
+}
+
+func (h *DevicesHandler) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, h.logger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	idRaw := strings.TrimPrefix(r.URL.Path, "/api/devices/")
+
+	id, err := strconv.ParseInt(idRaw, 10, 64)
+	if err != nil {
+		writeError(w, h.logger, http.StatusBadRequest, fmt.Errorf("invalid device id %s", strconv.Quote(idRaw)))
+		return
+	}
+
+	d, ok := h.states.Device(id)
+	if !ok {
+		writeError(w, h.logger, http.StatusNotFound, fmt.Errorf("device %d not found", id))
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, h.newDeviceResponse(d, h.states.LastPull(), h.timeNowFunc()))
+}
+
+func (h *DevicesHandler) newDeviceResponse(d services.DeviceState, lastPull, now time.Time) deviceResponse {
+	info := d.Info
+	age := now.Sub(d.UpdatedAt)
+
+	res := deviceResponse{
+		ID:           d.Device.ID,
+		Name:         d.Device.Name,
+		City:         d.Device.City,
+		Severity:     info.Severity,
+		SeverityDesc: info.SeverityDesc,
+		WeatherTemp:  info.WeatherTemp,
+		DataActual:   info.DataActual,
+		Alarms:       info.Alarms,
+		UpdatedAt:    d.UpdatedAt,
+		AgeSeconds:   age.Seconds(),
+		Stale:        d.UpdatedAt.Before(lastPull) || (h.staleAfter > 0 && age > h.staleAfter),
+		Envs:         make([]envResponse, 0, len(info.Envs)),
+		Heaters:      make([]heaterResponse, 0, len(info.Heaters)),
+	}
+
+	if res.Alarms == nil {
+		res.Alarms = make([]interface{}, 0)
+	}
+
+	for _, env := range info.Envs {
+		res.Envs = append(res.Envs, envResponse{
+			ID:           env.ID,
+			Name:         env.Name,
+			Type:         env.Type,
+			Value:        env.Value,
+			Target:       env.Target,
+			Demand:       env.Demand,
+			Severity:     env.Severity,
+			SeverityDesc: env.SeverityDesc,
+		})
+	}
+
+	for _, heater := range info.Heaters {
+		res.Heaters = append(res.Heaters, heaterResponse{
+			ID:            heater.ID,
+			Name:          heater.Name,
+			Disabled:      heater.Disabled,
+			BurnerHeating: heater.BurnerHeating,
+			BurnerWater:   heater.BurnerWater,
+			FlowTemp:      heater.FlowTemp,
+			ReturnTemp:    heater.ReturnTemp,
+			Pressure:      heater.Pressure,
+			Modulation:    heater.Modulation,
+			TargetTemp:    heater.TargetTemp,
+		})
+	}
+
+	return res
+}
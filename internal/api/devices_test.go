@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func testSnapshot(id int64, name string) services.DeviceSnapshot {
+	return services.DeviceSnapshot{
+		Device: domain.Device{ID: id, Name: name, City: "Москва", Severity: 1},
+		Info: domain.DeviceInfo{
+			DataActual:  true,
+			Severity:    1,
+			WeatherTemp: floatPtr(-5.5),
+			Envs: []domain.Env{
+				{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature, Value: floatPtr(21.25), Target: floatPtr(22), Demand: true},
+			},
+			Heaters: []domain.Heater{
+				{ID: 5, Name: "Котел", BurnerHeating: true, FlowTemp: floatPtr(45.5), Pressure: floatPtr(1.5)},
+			},
+		},
+	}
+}
+
+func TestDevicesHandler(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	states := services.NewStateStore()
+	states.HandlePull(context.Background(), services.PullResult{
+		Time:    start,
+		Devices: []services.DeviceSnapshot{testSnapshot(10, "Дом"), testSnapshot(20, "Дача")},
+	})
+	// Во втором опросе данные устройства 20 получить не удалось
+	states.HandlePull(context.Background(), services.PullResult{
+		Time:    start.Add(time.Minute),
+		Devices: []services.DeviceSnapshot{testSnapshot(10, "Дом")},
+	})
+
+	h := NewDevicesHandler(states, 5*time.Minute, nopwrap.NewNopWrapper())
+	h.timeNowFunc = func() time.Time { return start.Add(90 * time.Second) }
+
+	mux := http.NewServeMux()
+	h.Register(mux)
+
+	t.Run("список устройств", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		res := devicesResponse{}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+
+		if len(res.Devices) != 2 {
+			t.Fatalf("devices = %d, want 2", len(res.Devices))
+		}
+
+		if res.Devices[0].ID != 10 || res.Devices[0].Stale {
+			t.Errorf("device 10 must be first and not stale: %+v", res.Devices[0])
+		}
+
+		if res.Devices[1].ID != 20 || !res.Devices[1].Stale || res.Devices[1].AgeSeconds != 90 {
+			t.Errorf("device 20 must be stale with age 90s: %+v", res.Devices[1])
+		}
+	})
+
+	t.Run("одно устройство", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/devices/10", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		res := deviceResponse{}
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding response: %v", err)
+		}
+
+		heater := res.Heaters[0]
+		if heater.FlowTemp == nil || *heater.FlowTemp != 45.5 || heater.ReturnTemp != nil {
+			t.Errorf("heater values are not normalized: %+v", heater)
+		}
+
+		if res.Envs[0].Value == nil || *res.Envs[0].Value != 21.25 || !res.Envs[0].Demand {
+			t.Errorf("unexpected env: %+v", res.Envs[0])
+		}
+	})
+
+	for url, status := range map[string]int{
+		"/api/devices/30":  http.StatusNotFound,
+		"/api/devices/abc": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+		if rec.Code != status {
+			t.Errorf("%s: status = %d, want %d", url, rec.Code, status)
+		}
+	}
+}
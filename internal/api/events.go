@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger"
+)
+
+const defaultEventsHeartbeatInterval = time.Second * 15
+
+func NewEventsHandler(stream *services.EventStream, l wdlogger.Logger) *EventsHandler {
+	return &EventsHandler{
+		stream:            stream,
+		logger:            l,
+		heartbeatInterval: defaultEventsHeartbeatInterval,
+	}
+}
+
+// EventsHandler отдает изменения состояния в формате Server-Sent Events.
+//
+//	GET /api/events?device=<id>
+//
+// Имя события SSE совпадает с типом события, данные - событие в JSON. Чтобы прокси не закрывали соединение,
+// периодически отправляется комментарий
+type EventsHandler struct {
+	stream            *services.EventStream
+	logger            wdlogger.Logger
+	heartbeatInterval time.Duration
+}
+
+// Register добавляет маршруты обработчика в mux
+func (h *EventsHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/events", h.handleEvents)
+}
+
+func (h *EventsHandler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, h.logger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	var deviceID int64
+
+	if v := r.URL.Query().Get("device"); v != "" {
+		var err error
+		if deviceID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeError(w, h.logger, http.StatusBadRequest, fmt.Errorf("invalid device id %s", strconv.Quote(v)))
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, h.logger, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	events, unsubscribe := h.stream.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if deviceID != 0 && e.DeviceID != deviceID {
+				continue
+			}
+
+			data, err := json.Marshal(e)
+			if err != nil {
+				h.logger.Error("encoding event", wdlogger.NewErrorField("error", err))
+				continue
+			}
+
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data); err != nil {
+				return
+			}
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestEventsHandler(t *testing.T) {
+	stream := services.NewEventStream(nopwrap.NewNopWrapper())
+
+	mux := http.NewServeMux()
+	NewEventsHandler(stream, nopwrap.NewNopWrapper()).Register(mux)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events?device=10", nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q", ct)
+	}
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	first := testSnapshot(10, "Дом")
+	second := testSnapshot(10, "Дом")
+	second.Info.Envs[0].Demand = false
+
+	// Ответ уже получен, значит подписка оформлена
+	stream.HandlePull(ctx, services.PullResult{Time: start, Devices: []services.DeviceSnapshot{first}})
+	stream.HandlePull(ctx, services.PullResult{Time: start.Add(time.Minute), Devices: []services.DeviceSnapshot{second}})
+
+	reader := bufio.NewReader(resp.Body)
+
+	event, _ := reader.ReadString('\n')
+	data, _ := reader.ReadString('\n')
+
+	if event != "event: env_demand_changed\n" {
+		t.Errorf("event line = %q", event)
+	}
+
+	if !strings.HasPrefix(data, "data: ") || !strings.Contains(data, `"envName":"Гостиная"`) || !strings.Contains(data, `"new":false`) {
+		t.Errorf("data line = %q", data)
+	}
+}
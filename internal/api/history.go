@@ -0,0 +1,165 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/wdlogger"
+)
+
+const defaultHistoryRange = time.Hour * 24
+
+func NewHistoryHandler(store *history.Store, l wdlogger.Logger) *HistoryHandler {
+	return &HistoryHandler{
+		store:       store,
+		logger:      l,
+		timeNowFunc: time.Now,
+	}
+}
+
+// HistoryHandler отдает показания из локальной истории.
+//
+//	GET /api/history?env=<id|имя>&from=<время>&to=<время>&step=<интервал>&metric=<метрика,...>
+//	GET /api/history/objects
+//
+// Вместо env можно указать device или heater. Время задается в RFC 3339 или unix-секундах
+type HistoryHandler struct {
+	store       *history.Store
+	logger      wdlogger.Logger
+	timeNowFunc func() time.Time
+}
+
+// Register добавляет маршруты обработчика в mux
+func (h *HistoryHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/history", h.handleHistory)
+	mux.HandleFunc("/api/history/objects", h.handleObjects)
+}
+
+type historyResponse struct {
+	Object history.Object             `json:"object"`
+	From   time.Time                  `json:"from"`
+	To     time.Time                  `json:"to"`
+	Step   string                     `json:"step,omitempty"`
+	Series map[string][]history.Point `json:"series"`
+}
+
+func (h *HistoryHandler) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, h.logger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	params := r.URL.Query()
+
+	objectType, idOrName := "", ""
+	for _, t := range []string{history.ObjectEnv, history.ObjectDevice, history.ObjectHeater} {
+		if v := params.Get(t); v != "" {
+			objectType, idOrName = t, v
+			break
+		}
+	}
+
+	if objectType == "" {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("one of env, device or heater parameters is required"))
+		return
+	}
+
+	q := history.Query{ObjectType: objectType}
+
+	var err error
+
+	q.To = h.timeNowFunc()
+	if v := params.Get("to"); v != "" {
+		if q.To, err = parseTime(v); err != nil {
+			writeError(w, h.logger, http.StatusBadRequest, fmt.Errorf("parsing to: %w", err))
+			return
+		}
+	}
+
+	q.From = q.To.Add(-defaultHistoryRange)
+	if v := params.Get("from"); v != "" {
+		if q.From, err = parseTime(v); err != nil {
+			writeError(w, h.logger, http.StatusBadRequest, fmt.Errorf("parsing from: %w", err))
+			return
+		}
+	}
+
+	if q.From.After(q.To) {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("from must not be after to"))
+		return
+	}
+
+	if v := params.Get("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil || q.Step <= 0 {
+			writeError(w, h.logger, http.StatusBadRequest, fmt.Errorf("step must be positive duration: %s", v))
+			return
+		}
+	}
+
+	if v := params.Get("metric"); v != "" {
+		q.Metrics = strings.Split(v, ",")
+	}
+
+	obj, err := h.store.FindObject(r.Context(), objectType, idOrName)
+	if errors.Is(err, history.ErrObjectNotFound) {
+		writeError(w, h.logger, http.StatusNotFound, fmt.Errorf("%s %q not found", objectType, idOrName))
+		return
+	}
+	if err != nil {
+		h.logger.Error("finding history object", wdlogger.NewErrorField("error", err))
+		writeError(w, h.logger, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	q.ObjectID = obj.ID
+
+	series, err := h.store.Query(r.Context(), q)
+	if err != nil {
+		h.logger.Error("querying history", wdlogger.NewErrorField("error", err))
+		writeError(w, h.logger, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	res := historyResponse{
+		Object: obj,
+		From:   q.From.UTC(),
+		To:     q.To.UTC(),
+		Series: series,
+	}
+
+	if q.Step > 0 {
+		res.Step = q.Step.String()
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, res)
+}
+
+func (h *HistoryHandler) handleObjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, h.logger, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	objects, err := h.store.Objects(r.Context())
+	if err != nil {
+		h.logger.Error("listing history objects", wdlogger.NewErrorField("error", err))
+		writeError(w, h.logger, http.StatusInternalServerError, errors.New("internal error"))
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, objects)
+}
+
+// parseTime разбирает время в формате RFC 3339 или unix-секундах
+func parseTime(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func newTestHistoryStore(t *testing.T) *history.Store {
+	t.Helper()
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	readings := make([]history.Reading, 0)
+	for i := 0; i < 4; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+
+		readings = append(readings,
+			history.Reading{Time: ts, DeviceID: 10, ObjectType: history.ObjectEnv, ObjectID: 1, ObjectName: "Гостиная", Metric: "value", Value: 20 + float64(i)},
+			history.Reading{Time: ts, DeviceID: 10, ObjectType: history.ObjectEnv, ObjectID: 1, ObjectName: "Гостиная", Metric: "target", Value: 22},
+		)
+	}
+
+	if err = store.Insert(context.Background(), readings); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	return store
+}
+
+func TestHistoryHandler(t *testing.T) {
+	store := newTestHistoryStore(t)
+
+	mux := http.NewServeMux()
+	NewHistoryHandler(store, nopwrap.NewNopWrapper()).Register(mux)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantPoints map[string][]float64
+	}{
+		{
+			name:       "сырые значения по имени",
+			url:        "/api/history?env=Гостиная&from=2024-01-01T00:00:00Z&to=2024-01-01T01:00:00Z&metric=value",
+			wantStatus: http.StatusOK,
+			wantPoints: map[string][]float64{"value": {20, 21, 22, 23}},
+		},
+		{
+			name:       "усреднение с шагом",
+			url:        "/api/history?env=1&from=2024-01-01T00:00:00Z&to=2024-01-01T01:00:00Z&step=2m",
+			wantStatus: http.StatusOK,
+			wantPoints: map[string][]float64{"value": {20.5, 22.5}, "target": {22, 22}},
+		},
+		{
+			name:       "неизвестное помещение",
+			url:        "/api/history?env=Кухня",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "без объекта",
+			url:        "/api/history",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "некорректный шаг",
+			url:        "/api/history?env=1&step=abc",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			if tt.wantPoints == nil {
+				return
+			}
+
+			res := historyResponse{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatalf("unmarshaling response: %v", err)
+			}
+
+			if res.Object.ID != 1 || res.Object.DeviceID != 10 {
+				t.Errorf("object = %+v", res.Object)
+			}
+
+			if len(res.Series) != len(tt.wantPoints) {
+				t.Errorf("series = %v, want %v", res.Series, tt.wantPoints)
+			}
+
+			for metric, want := range tt.wantPoints {
+				got := res.Series[metric]
+				if len(got) != len(want) {
+					t.Errorf("%s points = %v, want %v", metric, got, want)
+					continue
+				}
+
+				for i := range want {
+					if got[i].Value != want[i] {
+						t.Errorf("%s point %d = %v, want %v", metric, i, got[i].Value, want[i])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/denistv/wdlogger"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, l wdlogger.Logger, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		l.Error("writing response", wdlogger.NewErrorField("error", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, l wdlogger.Logger, status int, err error) {
+	writeJSON(w, l, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,101 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+type Config struct {
+	// WriteURL полный адрес эндпоинта записи, например:
+	// http://influxdb:8086/write?db=myheat или http://influxdb:8086/api/v2/write?org=home&bucket=myheat
+	WriteURL string
+	// Token токен InfluxDB 2.x, передается в заголовке "Authorization: Token <token>"
+	Token    string
+	Username string
+	Password string
+}
+
+func (c Config) Validate() error {
+	if c.WriteURL == "" {
+		return errors.New("write url cannot be empty")
+	}
+
+	return nil
+}
+
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Client отправляет данные в формате line protocol в HTTP-эндпоинт /write InfluxDB или совместимой базы (VictoriaMetrics)
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+func (c *Client) Write(ctx context.Context, data []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.WriteURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	httpReq.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	switch {
+	case c.cfg.Token != "":
+		httpReq.Header.Set("Authorization", "Token "+c.cfg.Token)
+	case c.cfg.Username != "":
+		httpReq.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", res.StatusCode, bytes.TrimSpace(body))
+	}
+
+	return nil
+}
+
+func NewFileWriter(path string) *FileWriter {
+	return &FileWriter{
+		path: path,
+	}
+}
+
+// FileWriter дописывает данные в файл. Используется для отладки без InfluxDB
+type FileWriter struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (f *FileWriter) Write(_ context.Context, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,102 @@
+package influx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Point точка данных в формате InfluxDB line protocol
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	// Fields значения поддерживаемых типов: float64, int64, bool, string
+	Fields map[string]interface{}
+	Time   time.Time
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringFieldEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// AppendLine добавляет точку в buf в виде строки line protocol с переводом строки в конце.
+// Теги и поля сортируются по ключу, чтобы вывод был детерминированным.
+// Если у поля неподдерживаемый тип, возвращается ошибка и buf без изменений
+func AppendLine(buf []byte, p Point) ([]byte, error) {
+	start := len(buf)
+
+	buf = append(buf, measurementEscaper.Replace(p.Measurement)...)
+
+	for _, k := range sortedKeys(p.Tags) {
+		v := p.Tags[k]
+		// Пустые значения тегов не допускаются форматом
+		if v == "" {
+			continue
+		}
+
+		buf = append(buf, ',')
+		buf = append(buf, tagEscaper.Replace(k)...)
+		buf = append(buf, '=')
+		buf = append(buf, tagEscaper.Replace(v)...)
+	}
+
+	buf = append(buf, ' ')
+
+	for i, k := range sortedKeys(p.Fields) {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		buf = append(buf, tagEscaper.Replace(k)...)
+		buf = append(buf, '=')
+
+		var err error
+
+		buf, err = appendFieldValue(buf, p.Fields[k])
+		if err != nil {
+			return buf[:start], fmt.Errorf("field %s: %w", k, err)
+		}
+	}
+
+	if !p.Time.IsZero() {
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, p.Time.UnixNano(), 10)
+	}
+
+	return append(buf, '\n'), nil
+}
+
+func appendFieldValue(buf []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		return strconv.AppendFloat(buf, v, 'f', -1, 64), nil
+	case int64:
+		return append(strconv.AppendInt(buf, v, 10), 'i'), nil
+	case int:
+		return append(strconv.AppendInt(buf, int64(v), 10), 'i'), nil
+	case bool:
+		return strconv.AppendBool(buf, v), nil
+	case string:
+		buf = append(buf, '"')
+		buf = append(buf, stringFieldEscaper.Replace(v)...)
+		return append(buf, '"'), nil
+	default:
+		return buf, fmt.Errorf("unsupported field type %T", v)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
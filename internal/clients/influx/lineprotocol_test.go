@@ -0,0 +1,72 @@
+package influx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAppendLine(t *testing.T) {
+	ts := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		point   Point
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "все типы полей",
+			point: Point{
+				Measurement: "myheat_env",
+				Tags:        map[string]string{"id": "1", "device_id": "10"},
+				Fields: map[string]interface{}{
+					"temp_current": 21.25,
+					"severity":     int64(32),
+					"heat_demand":  true,
+					"desc":         "ok",
+				},
+				Time: ts,
+			},
+			want: "myheat_env,device_id=10,id=1 desc=\"ok\",heat_demand=true,severity=32i,temp_current=21.25 1704067200000000000\n",
+		},
+		{
+			name: "экранирование",
+			point: Point{
+				Measurement: "my heat,env",
+				Tags:        map[string]string{"name": "Детская комната=1,2"},
+				Fields:      map[string]interface{}{"desc": `say "hi" \o/`},
+			},
+			want: "my\\ heat\\,env,name=Детская\\ комната\\=1\\,2 desc=\"say \\\"hi\\\" \\\\o/\"\n",
+		},
+		{
+			name: "пустые теги пропускаются",
+			point: Point{
+				Measurement: "myheat_dev",
+				Tags:        map[string]string{"id": "10", "city": ""},
+				Fields:      map[string]interface{}{"weather_temp": -1.5},
+			},
+			want: "myheat_dev,id=10 weather_temp=-1.5\n",
+		},
+		{
+			name: "неподдерживаемый тип поля",
+			point: Point{
+				Measurement: "myheat_dev",
+				Fields:      map[string]interface{}{"weather_temp": float32(-1.5)},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AppendLine([]byte("prev\n"), tt.point)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AppendLine() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if want := "prev\n" + tt.want; string(got) != want {
+				t.Errorf("AppendLine() = %q, want %q", got, want)
+			}
+		})
+	}
+}
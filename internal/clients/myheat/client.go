@@ -19,13 +19,14 @@ type action string
 const (
 	actionGetDevices    action = "getDevices"
 	actionGetDeviceInfo action = "getDeviceInfo"
+	actionSetEnvGoal    action = "setEnvGoal"
 )
 
 const (
 	// К сожалению, поставщик API в своей документации не сообщает все возможные значения в Response,
 	// поэтому здесь перечислены только те, которые мне известны.
-	devSeverityNormal     = 1
-	devSeverityLowBalance = 32
+	DevSeverityNormal     = 1
+	DevSeverityLowBalance = 32
 )
 
 const successResponse = 0
@@ -42,6 +43,8 @@ type Config struct {
 	EndpointURL string
 	Login       string
 	Key         string
+	// Transport выполняет HTTP запросы, например, с записью ответов. По умолчанию http.DefaultTransport
+	Transport http.RoundTripper
 }
 
 func (c *Config) Validate() error {
@@ -57,12 +60,16 @@ func (c *Config) Validate() error {
 }
 
 func NewClient(cfg Config, l wdlogger.Logger) *Client {
+	httpClient := http.DefaultClient
+	if cfg.Transport != nil {
+		httpClient = &http.Client{Transport: cfg.Transport}
+	}
+
 	return &Client{
 		cfg:        cfg,
 		logger:     l,
-		httpClient: http.DefaultClient,
+		httpClient: httpClient,
 	}
-
 }
 
 type Client struct {
@@ -107,32 +114,8 @@ func (c *Client) GetDevices(ctx context.Context) (GetDevicesResponse, error) {
 
 	req := NewGetDevicesRequest(c.cfg.Login, c.cfg.Key)
 
-	data, err := json.Marshal(req)
-	if err != nil {
-		return GetDevicesResponse{}, err
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.EndpointURL, bytes.NewBuffer(data))
-	if err != nil {
-		return GetDevicesResponse{}, err
-	}
-
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	resRaw, err := c.httpClient.Do(httpReq)
-	if err != nil {
-		return GetDevicesResponse{}, err
-	}
-	defer resRaw.Body.Close()
-
-	data, err = io.ReadAll(resRaw.Body)
-	if err != nil {
-		return GetDevicesResponse{}, err
-	}
-
 	res := GetDevicesResponse{}
-	err = json.Unmarshal(data, &res)
-	if err != nil {
+	if err := c.do(ctx, req, &res); err != nil {
 		return GetDevicesResponse{}, err
 	}
 
@@ -161,78 +144,154 @@ type GetDeviceInfoRequest struct {
 }
 
 type GetDeviceInfoResponse struct {
-	Data struct {
-		Alarms     []interface{} `json:"alarms"`
-		City       string        `json:"city"`
-		DataActual bool          `json:"dataActual"`
-		Engs       []interface{} `json:"engs"`
-		Envs       []struct {
-			Demand       bool    `json:"demand"`
-			ID           int64   `json:"id"`
-			Name         string  `json:"name"`
-			Severity     int64   `json:"severity"`
-			SeverityDesc string  `json:"severityDesc"`
-			Target       float64 `json:"target"`
-			Type         string  `json:"type"`
-			Value        float64 `json:"value"`
-		} `json:"envs"`
-		Heaters []struct {
-			BurnerHeating bool        `json:"burnerHeating"`
-			BurnerWater   bool        `json:"burnerWater"`
-			Disabled      bool        `json:"disabled"`
-			FlowTemp      interface{} `json:"flowTemp"`
-			ID            int64       `json:"id"`
-			Modulation    interface{} `json:"modulation"`
-			Name          string      `json:"name"`
-			Pressure      interface{} `json:"pressure"`
-			ReturnTemp    interface{} `json:"returnTemp"`
-			// TODO write test for unmarshal value: "38.56874939532035"
-			//TargetTemp    int64       `json:"targetTemp"`
-		} `json:"heaters"`
-		Severity     int64   `json:"severity"`
-		SeverityDesc string  `json:"severityDesc"`
-		WeatherTemp  float64 `json:"weatherTemp,string"` // Example: "1.4600000000000364"
-	} `json:"data"`
-	Err         int64 `json:"err"`
-	RefreshPage bool  `json:"refreshPage"`
+	Data        DeviceInfo `json:"data"`
+	Err         int64      `json:"err"`
+	RefreshPage bool       `json:"refreshPage"`
+}
+
+type DeviceInfo struct {
+	Alarms       []interface{} `json:"alarms"`
+	City         string        `json:"city"`
+	DataActual   bool          `json:"dataActual"`
+	Engs         []interface{} `json:"engs"`
+	Envs         []Env         `json:"envs"`
+	Heaters      []Heater      `json:"heaters"`
+	Severity     int64         `json:"severity"`
+	SeverityDesc string        `json:"severityDesc"`
+	WeatherTemp  FlexFloat     `json:"weatherTemp"` // Example: "1.4600000000000364"
+}
+
+type Env struct {
+	Demand       bool      `json:"demand"`
+	ID           int64     `json:"id"`
+	Name         string    `json:"name"`
+	Severity     int64     `json:"severity"`
+	SeverityDesc string    `json:"severityDesc"`
+	Target       FlexFloat `json:"target"`
+	Type         string    `json:"type"`
+	Value        FlexFloat `json:"value"`
+}
+
+type Heater struct {
+	BurnerHeating bool      `json:"burnerHeating"`
+	BurnerWater   bool      `json:"burnerWater"`
+	Disabled      bool      `json:"disabled"`
+	FlowTemp      FlexFloat `json:"flowTemp"`
+	ID            int64     `json:"id"`
+	Modulation    FlexFloat `json:"modulation"`
+	Name          string    `json:"name"`
+	Pressure      FlexFloat `json:"pressure"`
+	ReturnTemp    FlexFloat `json:"returnTemp"`
+	TargetTemp    FlexFloat `json:"targetTemp"` // Example: "38.56874939532035"
 }
 
 func (c *Client) GetDeviceInfo(ctx context.Context, id int64) (GetDeviceInfoResponse, error) {
 	req := NewGetDeviceInfoRequest(c.cfg.Login, c.cfg.Key, id)
 
-	data, err := json.Marshal(req)
-	if err != nil {
+	res := GetDeviceInfoResponse{}
+	if err := c.do(ctx, req, &res); err != nil {
 		return GetDeviceInfoResponse{}, err
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.EndpointURL, bytes.NewBuffer(data))
-	if err != nil {
-		return GetDeviceInfoResponse{}, err
+	if res.Err != successResponse {
+		c.logger.Error("server returned error", wdlogger.NewInt64Field("err", res.Err))
+		return GetDeviceInfoResponse{}, fmt.Errorf("server returned error")
 	}
 
-	httpReq.Header.Set("Content-Type", "application/json")
+	return res, nil
+}
 
-	resRaw, err := c.httpClient.Do(httpReq)
+// GetDeviceInfoRaw возвращает тело ответа getDeviceInfo как есть, даже если это некорректный JSON.
+// Используется для отладки
+func (c *Client) GetDeviceInfoRaw(ctx context.Context, id int64) ([]byte, error) {
+	req := NewGetDeviceInfoRequest(c.cfg.Login, c.cfg.Key, id)
+
+	return c.doRaw(ctx, req)
+}
+
+func NewSetEnvGoalRequest(login, key string, deviceID, envID int64, goal float64) SetEnvGoalRequest {
+	return SetEnvGoalRequest{
+		Action:     actionSetEnvGoal,
+		Login:      login,
+		Key:        key,
+		DeviceID:   deviceID,
+		ObjID:      envID,
+		Goal:       goal,
+		ChangeMode: changeModeManual,
+	}
+}
+
+// changeModeManual целевая температура задается вручную и действует до следующего изменения
+const changeModeManual = 0
+
+type SetEnvGoalRequest struct {
+	Action     action  `json:"action"`
+	DeviceID   int64   `json:"deviceId"`
+	ObjID      int64   `json:"objId"`
+	Goal       float64 `json:"goal"`
+	ChangeMode int64   `json:"changeMode"`
+	Login      string  `json:"login"`
+	Key        string  `json:"key"`
+}
+
+type SetEnvGoalResponse struct {
+	Err         int64 `json:"err"`
+	RefreshPage bool  `json:"refreshPage"`
+}
+
+// SetEnvGoal устанавливает целевую температуру помещения
+func (c *Client) SetEnvGoal(ctx context.Context, deviceID, envID int64, goal float64) error {
+	c.logger.Info(
+		"SetEnvGoal - send request",
+		wdlogger.NewInt64Field("device_id", deviceID),
+		wdlogger.NewInt64Field("env_id", envID),
+		wdlogger.NewFloat64Field("goal", goal),
+	)
+
+	req := NewSetEnvGoalRequest(c.cfg.Login, c.cfg.Key, deviceID, envID, goal)
+
+	res := SetEnvGoalResponse{}
+	if err := c.do(ctx, req, &res); err != nil {
+		return err
+	}
+
+	if res.Err != successResponse {
+		c.logger.Error("server returned error", wdlogger.NewInt64Field("err", res.Err))
+		return fmt.Errorf("server returned error")
+	}
+
+	return nil
+}
+
+// do отправляет запрос в API и декодирует ответ в res
+func (c *Client) do(ctx context.Context, req interface{}, res interface{}) error {
+	data, err := c.doRaw(ctx, req)
 	if err != nil {
-		return GetDeviceInfoResponse{}, err
+		return err
 	}
-	defer resRaw.Body.Close()
 
-	data, err = io.ReadAll(resRaw.Body)
+	return json.Unmarshal(data, res)
+}
+
+// doRaw отправляет запрос в API и возвращает тело ответа
+func (c *Client) doRaw(ctx context.Context, req interface{}) ([]byte, error) {
+	data, err := json.Marshal(req)
 	if err != nil {
-		return GetDeviceInfoResponse{}, err
+		return nil, err
 	}
 
-	res := GetDeviceInfoResponse{}
-	err = json.Unmarshal(data, &res)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.EndpointURL, bytes.NewBuffer(data))
 	if err != nil {
-		return GetDeviceInfoResponse{}, err
+		return nil, err
 	}
 
-	if res.Err != successResponse {
-		c.logger.Error("server returned error", wdlogger.NewInt64Field("err", res.Err))
-		return GetDeviceInfoResponse{}, fmt.Errorf("server returned error")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resRaw, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
 	}
+	defer resRaw.Body.Close()
 
-	return res, nil
+	return io.ReadAll(resRaw.Body)
 }
@@ -0,0 +1,172 @@
+package myheat_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/myheattest"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func newTestServer(t *testing.T) *myheattest.Server {
+	t.Helper()
+
+	srv := myheattest.NewServer("user", "secret")
+	t.Cleanup(srv.Close)
+
+	srv.AddDevice(
+		myheat.Device{ID: 10, Name: "Дом", City: "Москва", Severity: myheat.DevSeverityNormal},
+		myheat.DeviceInfo{
+			DataActual:  true,
+			WeatherTemp: myheat.NewFlexFloat(-5.5),
+			Envs: []myheat.Env{
+				{ID: 1, Name: "Гостиная", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(21.25), Target: myheat.NewFlexFloat(22), Demand: true},
+			},
+		},
+	)
+
+	return srv
+}
+
+func TestClient_GetDevices(t *testing.T) {
+	srv := newTestServer(t)
+	c := myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper())
+
+	res, err := c.GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+
+	devices := res.Data["devices"]
+	if len(devices) != 1 || devices[0].ID != 10 || devices[0].Name != "Дом" {
+		t.Errorf("devices = %+v", devices)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 || reqs[0].Action != myheattest.ActionGetDevices || reqs[0].Login != "user" || reqs[0].Key != "secret" {
+		t.Errorf("requests = %+v", reqs)
+	}
+}
+
+func TestClient_GetDeviceInfo(t *testing.T) {
+	srv := newTestServer(t)
+	c := myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper())
+
+	res, err := c.GetDeviceInfo(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+
+	if res.Data.WeatherTemp != myheat.NewFlexFloat(-5.5) {
+		t.Errorf("WeatherTemp = %v, want -5.5", res.Data.WeatherTemp)
+	}
+
+	if len(res.Data.Envs) != 1 || res.Data.Envs[0].Value != myheat.NewFlexFloat(21.25) {
+		t.Errorf("envs = %+v", res.Data.Envs)
+	}
+
+	if _, err = c.GetDeviceInfo(context.Background(), 99); err == nil {
+		t.Errorf("GetDeviceInfo() for unknown device must return error")
+	}
+}
+
+func TestClient_GetDeviceInfoRaw(t *testing.T) {
+	srv := newTestServer(t)
+
+	// Некорректный ответ возвращается как есть, чтобы его можно было посмотреть
+	body := `{"data": {"envs": [`
+	srv.Script(myheattest.Step{Action: myheattest.ActionGetDeviceInfo, Body: body})
+
+	c := myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper())
+
+	got, err := c.GetDeviceInfoRaw(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("GetDeviceInfoRaw() error = %v", err)
+	}
+
+	if string(got) != body {
+		t.Errorf("GetDeviceInfoRaw() = %q, want %q", got, body)
+	}
+}
+
+func TestClient_SetEnvGoal(t *testing.T) {
+	srv := newTestServer(t)
+	c := myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper())
+
+	if err := c.SetEnvGoal(context.Background(), 10, 1, 23.5); err != nil {
+		t.Fatalf("SetEnvGoal() error = %v", err)
+	}
+
+	res, err := c.GetDeviceInfo(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+
+	if got := res.Data.Envs[0].Target; got != myheat.NewFlexFloat(23.5) {
+		t.Errorf("Target = %v, want 23.5", got)
+	}
+
+	if err = c.SetEnvGoal(context.Background(), 10, 2, 20); err == nil {
+		t.Errorf("SetEnvGoal() for unknown env must return error")
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		step myheattest.Step
+	}{
+		{
+			name: "код ошибки",
+			step: myheattest.Step{Err: myheattest.ErrCodeAuth},
+		},
+		{
+			name: "некорректный JSON",
+			step: myheattest.Step{Body: `{"data": {"devices": [`},
+		},
+		{
+			name: "ошибка сервера",
+			step: myheattest.Step{Status: 502, Body: "Bad Gateway"},
+		},
+		{
+			name: "превышено время ожидания",
+			step: myheattest.Step{Latency: time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			srv.Script(tt.step)
+
+			c := myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			if _, err := c.GetDevices(ctx); err == nil {
+				t.Fatalf("GetDevices() must return error")
+			}
+
+			// Шаг сценария применяется один раз
+			if _, err := c.GetDevices(context.Background()); err != nil {
+				t.Errorf("GetDevices() after scripted step error = %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_WrongCredentials(t *testing.T) {
+	srv := newTestServer(t)
+
+	cfg := srv.Config()
+	cfg.Key = "wrong"
+
+	c := myheat.NewClient(cfg, nopwrap.NewNopWrapper())
+
+	if _, err := c.GetDevices(context.Background()); err == nil {
+		t.Errorf("GetDevices() with wrong key must return error")
+	}
+}
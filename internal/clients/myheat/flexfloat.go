@@ -0,0 +1,77 @@
+package myheat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// NewFlexFloat возвращает заданное значение
+func NewFlexFloat(v float64) FlexFloat {
+	return FlexFloat{Value: v, Valid: true}
+}
+
+// FlexFloat число, которое API передает то числом, то строкой с числом ("1.4600000000000364"),
+// а при отсутствии датчика пустой строкой или null. Valid равен false, если значения нет, в том числе если
+// поле отсутствует в ответе. Так отсутствующий датчик не превращается в показание 0
+type FlexFloat struct {
+	Value float64
+	Valid bool
+}
+
+func (f *FlexFloat) UnmarshalJSON(data []byte) error {
+	*f = FlexFloat{}
+
+	data = bytes.TrimSpace(data)
+
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+
+		if s == "" {
+			return nil
+		}
+
+		data = []byte(s)
+	}
+
+	v, err := strconv.ParseFloat(string(data), 64)
+	if err != nil {
+		return fmt.Errorf("decoding number %s: %w", data, err)
+	}
+
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return fmt.Errorf("decoding number %s: not a finite number", data)
+	}
+
+	*f = NewFlexFloat(v)
+
+	return nil
+}
+
+func (f FlexFloat) MarshalJSON() ([]byte, error) {
+	if !f.Valid {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(f.Value)
+}
+
+// Ptr возвращает указатель на значение или nil, если значения нет
+func (f FlexFloat) Ptr() *float64 {
+	if !f.Valid {
+		return nil
+	}
+
+	v := f.Value
+
+	return &v
+}
@@ -0,0 +1,66 @@
+package myheat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFlexFloat_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    FlexFloat
+		wantErr bool
+	}{
+		{name: "число", data: `38.5`, want: NewFlexFloat(38.5)},
+		{name: "ноль", data: `0`, want: NewFlexFloat(0)},
+		{name: "строка с числом", data: `"38.56874939532035"`, want: NewFlexFloat(38.56874939532035)},
+		{name: "отрицательное число строкой", data: `"-7.25"`, want: NewFlexFloat(-7.25)},
+		{name: "пустая строка", data: `""`},
+		{name: "null", data: `null`},
+		{name: "не число", data: `"n/a"`, wantErr: true},
+		{name: "NaN", data: `"NaN"`, wantErr: true},
+		{name: "объект", data: `{}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewFlexFloat(100)
+
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlexFloat_absentField(t *testing.T) {
+	heater := Heater{}
+	if err := json.Unmarshal([]byte(`{"id": 1, "flowTemp": 0}`), &heater); err != nil {
+		t.Fatal(err)
+	}
+
+	if heater.FlowTemp != NewFlexFloat(0) {
+		t.Errorf("FlowTemp = %+v, want valid 0", heater.FlowTemp)
+	}
+
+	if heater.ReturnTemp.Valid || heater.ReturnTemp.Ptr() != nil {
+		t.Errorf("absent ReturnTemp must not be valid")
+	}
+}
+
+func TestFlexFloat_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal([]FlexFloat{NewFlexFloat(1.5), {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `[1.5,null]` {
+		t.Errorf("Marshal() = %s, want [1.5,null]", data)
+	}
+}
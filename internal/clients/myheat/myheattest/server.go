@@ -0,0 +1,295 @@
+// Package myheattest поддельный MyHeat API для тестов и демонстраций.
+//
+// Server отвечает на getDevices, getDeviceInfo и setEnvGoal по данным, которые задает тест, а сценарий
+// (Script) позволяет для отдельных запросов изменить данные, вернуть код ошибки, задержать ответ
+// или отдать некорректный JSON.
+package myheattest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+)
+
+const (
+	ActionGetDevices    = "getDevices"
+	ActionGetDeviceInfo = "getDeviceInfo"
+	ActionSetEnvGoal    = "setEnvGoal"
+)
+
+// Коды ошибок в поле err. Поставщик API не документирует свои коды, поэтому клиент различает только
+// успешный ответ (0) и все остальные
+const (
+	ErrCodeAuth          int64 = 1
+	ErrCodeUnknownAction int64 = 2
+	ErrCodeNotFound      int64 = 3
+	ErrCodeBadRequest    int64 = 4
+)
+
+// Data данные, которые отдает поддельный API
+type Data struct {
+	Devices []myheat.Device
+	Infos   map[int64]myheat.DeviceInfo
+}
+
+// Env возвращает помещение устройства для изменения или nil, если его нет
+func (d *Data) Env(deviceID, envID int64) *myheat.Env {
+	info, ok := d.Infos[deviceID]
+	if !ok {
+		return nil
+	}
+
+	for i := range info.Envs {
+		if info.Envs[i].ID == envID {
+			return &info.Envs[i]
+		}
+	}
+
+	return nil
+}
+
+// Step шаг сценария. Применяется к первому подходящему запросу и удаляется из очереди
+type Step struct {
+	// Action действие, к которому применяется шаг. Пустое значение - любое действие
+	Action string
+	// DeviceID устройство, к которому применяется шаг. 0 - любое устройство
+	DeviceID int64
+
+	// Update изменяет данные перед ответом, например, температуру помещения
+	Update func(d *Data)
+	// Latency задержка перед ответом
+	Latency time.Duration
+	// Err код ошибки в поле err вместо обычного ответа
+	Err int64
+	// Status HTTP статус ответа. По умолчанию 200
+	Status int
+	// Body тело ответа как есть, например, некорректный JSON
+	Body string
+}
+
+func (s Step) matches(r Request) bool {
+	if s.Action != "" && s.Action != r.Action {
+		return false
+	}
+
+	return s.DeviceID == 0 || s.DeviceID == r.DeviceID
+}
+
+// Request запрос, полученный сервером
+type Request struct {
+	Action   string  `json:"action"`
+	DeviceID int64   `json:"deviceId"`
+	ObjID    int64   `json:"objId"`
+	Goal     float64 `json:"goal"`
+	Login    string  `json:"login"`
+	Key      string  `json:"key"`
+}
+
+// NewHandler создает поддельный API без запуска сервера, например, для http.ListenAndServe.
+// Запросы с другими login и key получают ErrCodeAuth
+func NewHandler(login, key string) *Server {
+	return &Server{
+		login: login,
+		key:   key,
+		data:  Data{Infos: make(map[int64]myheat.DeviceInfo)},
+	}
+}
+
+// NewServer запускает поддельный API на локальном порту
+func NewServer(login, key string) *Server {
+	s := NewHandler(login, key)
+
+	s.srv = httptest.NewServer(s)
+	s.URL = s.srv.URL
+
+	return s
+}
+
+type Server struct {
+	// URL адрес для myheat.Config.EndpointURL. Заполняется NewServer
+	URL string
+
+	srv   *httptest.Server
+	login string
+	key   string
+
+	mu       sync.Mutex
+	data     Data
+	steps    []Step
+	requests []Request
+}
+
+// Close останавливает сервер, запущенный NewServer
+func (s *Server) Close() {
+	if s.srv != nil {
+		s.srv.Close()
+	}
+}
+
+// Config возвращает конфигурацию клиента для этого сервера
+func (s *Server) Config() myheat.Config {
+	return myheat.Config{EndpointURL: s.URL, Login: s.login, Key: s.key}
+}
+
+// AddDevice добавляет устройство в список getDevices и его данные для getDeviceInfo
+func (s *Server) AddDevice(device myheat.Device, info myheat.DeviceInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Devices = append(s.data.Devices, device)
+	s.data.Infos[device.ID] = info
+}
+
+// Update изменяет данные сервера
+func (s *Server) Update(fn func(d *Data)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&s.data)
+}
+
+// Script добавляет шаги сценария в очередь
+func (s *Server) Script(steps ...Step) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.steps = append(s.steps, steps...)
+}
+
+// Requests возвращает полученные запросы в порядке поступления
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req := Request{}
+	if err = json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusOK, errorResponse(ErrCodeBadRequest))
+		return
+	}
+
+	step, ok := s.nextStep(req)
+	if ok && step.Latency > 0 {
+		select {
+		case <-time.After(step.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if ok && step.Status != 0 {
+		status = step.Status
+	}
+
+	if ok && step.Body != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, step.Body)
+
+		return
+	}
+
+	if ok && step.Err != 0 {
+		writeJSON(w, status, errorResponse(step.Err))
+		return
+	}
+
+	writeJSON(w, status, s.respond(req))
+}
+
+// nextStep регистрирует запрос и извлекает первый подходящий шаг сценария, применяя его изменения данных
+func (s *Server) nextStep(req Request) (Step, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+
+	for i, step := range s.steps {
+		if !step.matches(req) {
+			continue
+		}
+
+		s.steps = append(s.steps[:i], s.steps[i+1:]...)
+
+		if step.Update != nil {
+			step.Update(&s.data)
+		}
+
+		return step, true
+	}
+
+	return Step{}, false
+}
+
+func (s *Server) respond(req Request) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Login != s.login || req.Key != s.key {
+		return errorResponse(ErrCodeAuth)
+	}
+
+	switch req.Action {
+	case ActionGetDevices:
+		devices := append([]myheat.Device(nil), s.data.Devices...)
+		sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+		return myheat.GetDevicesResponse{Data: map[string][]myheat.Device{"devices": devices}}
+
+	case ActionGetDeviceInfo:
+		info, ok := s.data.Infos[req.DeviceID]
+		if !ok {
+			return errorResponse(ErrCodeNotFound)
+		}
+
+		return myheat.GetDeviceInfoResponse{Data: info}
+
+	case ActionSetEnvGoal:
+		env := s.data.Env(req.DeviceID, req.ObjID)
+		if env == nil {
+			return errorResponse(ErrCodeNotFound)
+		}
+
+		env.Target = myheat.NewFlexFloat(req.Goal)
+
+		return myheat.SetEnvGoalResponse{}
+
+	default:
+		return errorResponse(ErrCodeUnknownAction)
+	}
+}
+
+type apiError struct {
+	Err int64 `json:"err"`
+}
+
+func errorResponse(code int64) apiError {
+	return apiError{Err: code}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,336 @@
+// Package recording запись ответов MyHeat API в каталог и их воспроизведение.
+//
+// Recorder подключается к клиенту как http.RoundTripper и сохраняет каждый обмен с API в отдельный файл,
+// заменяя логин и ключ. Replayer отдает сохраненные ответы как MyHeat API, его адрес указывается
+// в myheat.Config.EndpointURL. Записанные файлы также используются как эталонные данные в тестах разбора ответов.
+package recording
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denistv/wdlogger"
+)
+
+// Redacted значение, которым заменяются учетные данные в записанных запросах
+const Redacted = "REDACTED"
+
+// redactedFields поля запроса с учетными данными
+var redactedFields = []string{"login", "key"}
+
+// Exchange один записанный обмен с API
+type Exchange struct {
+	Time    time.Time       `json:"time"`
+	Request json.RawMessage `json:"request"`
+	Status  int             `json:"status"`
+	// Response ответ API, если это корректный JSON
+	Response json.RawMessage `json:"response,omitempty"`
+	// Body ответ API как есть, если это не JSON
+	Body string `json:"body,omitempty"`
+}
+
+// Key ключ запроса, по которому Replayer подбирает ответ: действие, устройство и объект
+func (e Exchange) Key() (string, error) {
+	return requestKey(e.Request)
+}
+
+// ResponseBody тело ответа для воспроизведения
+func (e Exchange) ResponseBody() []byte {
+	if len(e.Response) != 0 {
+		return e.Response
+	}
+
+	return []byte(e.Body)
+}
+
+type requestFields struct {
+	Action   string `json:"action"`
+	DeviceID int64  `json:"deviceId"`
+	ObjID    int64  `json:"objId"`
+}
+
+func requestKey(body []byte) (string, error) {
+	req := requestFields{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", fmt.Errorf("decoding request: %w", err)
+	}
+
+	if req.Action == "" {
+		return "", errors.New("request action is empty")
+	}
+
+	key := req.Action
+
+	if req.DeviceID != 0 {
+		key += fmt.Sprintf("-%d", req.DeviceID)
+	}
+
+	if req.ObjID != 0 {
+		key += fmt.Sprintf("-%d", req.ObjID)
+	}
+
+	return key, nil
+}
+
+// Redact заменяет учетные данные в теле запроса
+func Redact(body []byte) ([]byte, error) {
+	req := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("decoding request: %w", err)
+	}
+
+	redacted, _ := json.Marshal(Redacted)
+
+	for _, f := range redactedFields {
+		if _, ok := req[f]; ok {
+			req[f] = redacted
+		}
+	}
+
+	return json.Marshal(req)
+}
+
+// ReadDir читает записанные обмены из каталога в порядке записи
+func ReadDir(dir string) ([]Exchange, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+
+	exchanges := make([]Exchange, 0, len(paths))
+
+	for _, path := range paths {
+		e, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		exchanges = append(exchanges, e)
+	}
+
+	return exchanges, nil
+}
+
+// ReadFile читает один записанный обмен
+func ReadFile(path string) (Exchange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Exchange{}, err
+	}
+
+	e := Exchange{}
+	if err = json.Unmarshal(data, &e); err != nil {
+		return Exchange{}, fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	return e, nil
+}
+
+// NewRecorder создает Recorder, который сохраняет обмены в dir. next выполняет запросы, по умолчанию
+// http.DefaultTransport. Ошибки сохранения записываются в logger и не прерывают запросы к API
+func NewRecorder(dir string, next http.RoundTripper, logger wdlogger.Logger) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating recordings dir: %w", err)
+	}
+
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &Recorder{
+		dir:         dir,
+		next:        next,
+		logger:      logger,
+		timeNowFunc: time.Now,
+	}, nil
+}
+
+type Recorder struct {
+	dir         string
+	next        http.RoundTripper
+	logger      wdlogger.Logger
+	timeNowFunc func() time.Time
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resBody, err := readBody(&res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Запись нужна только для отладки, поэтому ответ API возвращается, даже если его не удалось сохранить
+	if err = r.save(reqBody, res.StatusCode, resBody); err != nil {
+		r.logger.Error("recording MyHeat API exchange", wdlogger.NewErrorField("error", err))
+	}
+
+	return res, nil
+}
+
+func (r *Recorder) save(reqBody []byte, status int, resBody []byte) error {
+	redacted, err := Redact(reqBody)
+	if err != nil {
+		return err
+	}
+
+	key, err := requestKey(redacted)
+	if err != nil {
+		return err
+	}
+
+	e := Exchange{
+		Time:    r.timeNowFunc().UTC(),
+		Request: redacted,
+		Status:  status,
+	}
+
+	if json.Valid(resBody) {
+		e.Response = resBody
+	} else {
+		e.Body = string(resBody)
+	}
+
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+
+	// Номер сохраняет порядок обменов, время в имени не дает перезаписать файлы прошлых запусков
+	name := fmt.Sprintf("%s-%04d-%s.json", e.Time.Format("20060102T150405"), r.seq, key)
+
+	return os.WriteFile(filepath.Join(r.dir, name), append(data, '\n'), 0o644)
+}
+
+// readBody вычитывает тело и заменяет его копией, чтобы его можно было прочитать еще раз
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(*body)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = (*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+
+	return data, nil
+}
+
+// errCodeNotRecorded код ошибки для запросов, ответ на которые не записан
+const errCodeNotRecorded = -1
+
+// NewReplayer создает Replayer из записанных обменов
+func NewReplayer(exchanges []Exchange) (*Replayer, error) {
+	r := &Replayer{responses: make(map[string][]Exchange)}
+
+	for _, e := range exchanges {
+		key, err := e.Key()
+		if err != nil {
+			return nil, err
+		}
+
+		r.responses[key] = append(r.responses[key], e)
+	}
+
+	return r, nil
+}
+
+// LoadReplayer создает Replayer из каталога с записями
+func LoadReplayer(dir string) (*Replayer, error) {
+	exchanges, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(exchanges) == 0 {
+		return nil, fmt.Errorf("no recordings in %s", dir)
+	}
+
+	return NewReplayer(exchanges)
+}
+
+// Replayer отвечает на запросы записанными ответами. Ответы на одинаковые запросы отдаются по порядку записи,
+// после последнего повторяется последний, поэтому экспортер может опрашивать Replayer сколько угодно.
+// Учетные данные в запросах не проверяются
+type Replayer struct {
+	mu        sync.Mutex
+	responses map[string][]Exchange
+}
+
+func (r *Replayer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, err := requestKey(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	e, ok := r.next(key)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, `{"err":%d,"error":%q}`, errCodeNotRecorded, "no recording for "+key)
+
+		return
+	}
+
+	status := e.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(e.ResponseBody())
+}
+
+func (r *Replayer) next(key string) (Exchange, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queue := r.responses[key]
+	if len(queue) == 0 {
+		return Exchange{}, false
+	}
+
+	if len(queue) > 1 {
+		r.responses[key] = queue[1:]
+	}
+
+	return queue[0], true
+}
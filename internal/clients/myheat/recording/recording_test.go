@@ -0,0 +1,155 @@
+package recording
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/myheattest"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestRecorder_Replayer(t *testing.T) {
+	api := myheattest.NewServer("user", "secret")
+	defer api.Close()
+
+	api.AddDevice(
+		myheat.Device{ID: 10, Name: "Дом", City: "Москва"},
+		myheat.DeviceInfo{
+			WeatherTemp: myheat.NewFlexFloat(-5.5),
+			Envs:        []myheat.Env{{ID: 1, Name: "Гостиная", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(21)}},
+		},
+	)
+	api.Script(
+		myheattest.Step{
+			Action: myheattest.ActionGetDeviceInfo,
+			Update: func(d *myheattest.Data) { d.Env(10, 1).Value = myheat.NewFlexFloat(22) },
+		},
+		myheattest.Step{
+			Action: myheattest.ActionGetDeviceInfo,
+			Update: func(d *myheattest.Data) { d.Env(10, 1).Value = myheat.NewFlexFloat(23) },
+		},
+	)
+
+	dir := t.TempDir()
+
+	rec, err := NewRecorder(dir, nil, nopwrap.NewNopWrapper())
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	cfg := api.Config()
+	cfg.Transport = rec
+	client := myheat.NewClient(cfg, nopwrap.NewNopWrapper())
+
+	ctx := context.Background()
+
+	if _, err = client.GetDevices(ctx); err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err = client.GetDeviceInfo(ctx, 10); err != nil {
+			t.Fatalf("GetDeviceInfo() error = %v", err)
+		}
+	}
+
+	exchanges, err := ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+
+	if len(exchanges) != 3 {
+		t.Fatalf("recorded %d exchanges, want 3", len(exchanges))
+	}
+
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		data, _ := os.ReadFile(dir + "/" + f.Name())
+		if strings.Contains(string(data), "secret") || strings.Contains(string(data), `"user"`) {
+			t.Errorf("%s contains credentials", f.Name())
+		}
+	}
+
+	// Воспроизведение без доступа к исходному API
+	replayer, err := LoadReplayer(dir)
+	if err != nil {
+		t.Fatalf("LoadReplayer() error = %v", err)
+	}
+
+	srv := httptest.NewServer(replayer)
+	defer srv.Close()
+
+	client = myheat.NewClient(myheat.Config{EndpointURL: srv.URL, Login: "other", Key: "other"}, nopwrap.NewNopWrapper())
+
+	devices, err := client.GetDevices(ctx)
+	if err != nil {
+		t.Fatalf("replayed GetDevices() error = %v", err)
+	}
+
+	if len(devices.Data["devices"]) != 1 {
+		t.Errorf("replayed devices = %+v", devices.Data)
+	}
+
+	// Ответы отдаются по порядку записи, последний повторяется
+	for _, want := range []float64{22, 23, 23} {
+		info, err := client.GetDeviceInfo(ctx, 10)
+		if err != nil {
+			t.Fatalf("replayed GetDeviceInfo() error = %v", err)
+		}
+
+		if got := info.Data.Envs[0].Value; got != myheat.NewFlexFloat(want) {
+			t.Errorf("replayed env value = %v, want %v", got, want)
+		}
+	}
+
+	if _, err = client.GetDeviceInfo(ctx, 20); err == nil {
+		t.Errorf("GetDeviceInfo() without recording must return error")
+	}
+}
+
+func TestRecorder_SaveError(t *testing.T) {
+	api := myheattest.NewServer("user", "secret")
+	defer api.Close()
+
+	api.AddDevice(myheat.Device{ID: 10, Name: "Дом"}, myheat.DeviceInfo{})
+
+	dir := t.TempDir()
+
+	rec, err := NewRecorder(dir, nil, nopwrap.NewNopWrapper())
+	if err != nil {
+		t.Fatalf("NewRecorder() error = %v", err)
+	}
+
+	// Каталог удален, записать обмен не получится
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := api.Config()
+	cfg.Transport = rec
+
+	res, err := myheat.NewClient(cfg, nopwrap.NewNopWrapper()).GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+
+	if len(res.Data["devices"]) != 1 {
+		t.Errorf("devices = %+v, want one", res.Data)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got, err := Redact([]byte(`{"action":"getDevices","login":"user","key":"secret"}`))
+	if err != nil {
+		t.Fatalf("Redact() error = %v", err)
+	}
+
+	want := `{"action":"getDevices","key":"REDACTED","login":"REDACTED"}`
+	if string(got) != want {
+		t.Errorf("Redact() = %s, want %s", got, want)
+	}
+}
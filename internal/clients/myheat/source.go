@@ -0,0 +1,106 @@
+package myheat
+
+import (
+	"context"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+)
+
+// NewSource создает источник данных экспортера поверх клиента API
+func NewSource(c *Client) *Source {
+	return &Source{client: c}
+}
+
+// Source отдает данные MyHeat API в виде типов domain
+type Source struct {
+	client *Client
+}
+
+func (s *Source) GetDevices(ctx context.Context) ([]domain.Device, error) {
+	res, err := s.client.GetDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make([]domain.Device, 0, len(res.Data["devices"]))
+	for _, d := range res.Data["devices"] {
+		devices = append(devices, d.Domain())
+	}
+
+	return devices, nil
+}
+
+func (s *Source) GetDeviceInfo(ctx context.Context, id int64) (domain.DeviceInfo, error) {
+	res, err := s.client.GetDeviceInfo(ctx, id)
+	if err != nil {
+		return domain.DeviceInfo{}, err
+	}
+
+	return res.Data.Domain(), nil
+}
+
+// SetEnvGoal устанавливает целевую температуру помещения
+func (s *Source) SetEnvGoal(ctx context.Context, deviceID, envID int64, goal float64) error {
+	return s.client.SetEnvGoal(ctx, deviceID, envID, goal)
+}
+
+func (d Device) Domain() domain.Device {
+	return domain.Device{
+		ID:           d.ID,
+		Name:         d.Name,
+		City:         d.City,
+		Severity:     d.Severity,
+		SeverityDesc: d.SeverityDesc,
+	}
+}
+
+func (i DeviceInfo) Domain() domain.DeviceInfo {
+	info := domain.DeviceInfo{
+		City:         i.City,
+		DataActual:   i.DataActual,
+		Severity:     i.Severity,
+		SeverityDesc: i.SeverityDesc,
+		WeatherTemp:  i.WeatherTemp.Ptr(),
+		Alarms:       i.Alarms,
+		Envs:         make([]domain.Env, 0, len(i.Envs)),
+		Heaters:      make([]domain.Heater, 0, len(i.Heaters)),
+	}
+
+	for _, e := range i.Envs {
+		info.Envs = append(info.Envs, e.Domain())
+	}
+
+	for _, h := range i.Heaters {
+		info.Heaters = append(info.Heaters, h.Domain())
+	}
+
+	return info
+}
+
+func (e Env) Domain() domain.Env {
+	return domain.Env{
+		ID:           e.ID,
+		Name:         e.Name,
+		Type:         e.Type,
+		Value:        e.Value.Ptr(),
+		Target:       e.Target.Ptr(),
+		Demand:       e.Demand,
+		Severity:     e.Severity,
+		SeverityDesc: e.SeverityDesc,
+	}
+}
+
+func (h Heater) Domain() domain.Heater {
+	return domain.Heater{
+		ID:            h.ID,
+		Name:          h.Name,
+		BurnerHeating: h.BurnerHeating,
+		BurnerWater:   h.BurnerWater,
+		Disabled:      h.Disabled,
+		FlowTemp:      h.FlowTemp.Ptr(),
+		ReturnTemp:    h.ReturnTemp.Ptr(),
+		Pressure:      h.Pressure.Ptr(),
+		Modulation:    h.Modulation.Ptr(),
+		TargetTemp:    h.TargetTemp.Ptr(),
+	}
+}
@@ -0,0 +1,64 @@
+package myheat_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/myheattest"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestSource_GetDeviceInfo(t *testing.T) {
+	srv := newTestServer(t)
+	srv.Script(myheattest.Step{
+		Action: myheattest.ActionGetDeviceInfo,
+		Body: `{"data": {"dataActual": true, "weatherTemp": "",
+			"envs": [{"id": 1, "name": "Гостиная", "type": "room_temperature", "value": 21.25, "target": 22, "demand": true}],
+			"heaters": [{"id": 5, "name": "Котел", "burnerHeating": true, "flowTemp": 45.5, "pressure": "1.6",
+				"returnTemp": null, "modulation": "", "targetTemp": "38.56874939532035"}]}, "err": 0}`,
+	})
+
+	source := myheat.NewSource(myheat.NewClient(srv.Config(), nopwrap.NewNopWrapper()))
+
+	devices, err := source.GetDevices(context.Background())
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+
+	if len(devices) != 1 || devices[0].ID != 10 || devices[0].City != "Москва" {
+		t.Errorf("devices = %+v", devices)
+	}
+
+	info, err := source.GetDeviceInfo(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+
+	if info.WeatherTemp != nil {
+		t.Errorf("WeatherTemp = %v, want nil for empty string", *info.WeatherTemp)
+	}
+
+	if len(info.Envs) != 1 || info.Envs[0].Value == nil || *info.Envs[0].Value != 21.25 || !info.Envs[0].Demand {
+		t.Errorf("envs = %+v", info.Envs)
+	}
+
+	heater := info.Heaters[0]
+
+	for name, tt := range map[string]struct {
+		got  *float64
+		want float64
+	}{
+		"FlowTemp":   {heater.FlowTemp, 45.5},
+		"Pressure":   {heater.Pressure, 1.6},
+		"TargetTemp": {heater.TargetTemp, 38.56874939532035},
+	} {
+		if tt.got == nil || *tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", name, tt.got, tt.want)
+		}
+	}
+
+	if heater.ReturnTemp != nil || heater.Modulation != nil {
+		t.Errorf("missing readings must be nil, got ReturnTemp = %v, Modulation = %v", heater.ReturnTemp, heater.Modulation)
+	}
+}
@@ -0,0 +1,165 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type Config struct {
+	// URL адрес remote_write эндпоинта, например: http://mimir:9009/api/v1/push
+	URL         string
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+func (c Config) Validate() error {
+	if c.URL == "" {
+		return errors.New("url cannot be empty")
+	}
+
+	if c.BearerToken != "" && c.Username != "" {
+		return errors.New("bearer token and basic auth cannot be used together")
+	}
+
+	return nil
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Timestamp время в миллисекундах
+	Timestamp int64
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Encode сериализует временные ряды в сжатое snappy сообщение prometheus.WriteRequest.
+// Метки каждого ряда сортируются по имени, как того требует протокол
+func Encode(series []TimeSeries) []byte {
+	var buf []byte
+
+	for _, ts := range series {
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, encodeTimeSeries(ts))
+	}
+
+	return snappy.Encode(nil, buf)
+}
+
+func encodeTimeSeries(ts TimeSeries) []byte {
+	labels := make([]Label, len(ts.Labels))
+	copy(labels, ts.Labels)
+
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].Name < labels[j].Name
+	})
+
+	var buf []byte
+
+	for _, l := range labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Value)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, lb)
+	}
+
+	for _, s := range ts.Samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+
+		buf = protowire.AppendTag(buf, 2, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, sb)
+	}
+
+	return buf
+}
+
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Client отправляет данные по протоколу Prometheus remote_write 1.0
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+// Error ошибка отправки. Recoverable означает, что запрос имеет смысл повторить позже
+type Error struct {
+	StatusCode  int
+	Recoverable bool
+	err         error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Send отправляет сообщение, подготовленное Encode
+func (c *Client) Send(ctx context.Context, data []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	switch {
+	case c.cfg.BearerToken != "":
+		httpReq.Header.Set("Authorization", "Bearer "+c.cfg.BearerToken)
+	case c.cfg.Username != "":
+		httpReq.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+
+	res, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return &Error{Recoverable: true, err: err}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return &Error{
+		StatusCode: res.StatusCode,
+		// Ошибки 4xx, кроме 429, означают, что данные некорректны и повтор не поможет
+		Recoverable: res.StatusCode/100 == 5 || res.StatusCode == http.StatusTooManyRequests,
+		err:         fmt.Errorf("unexpected status code %d: %s", res.StatusCode, bytes.TrimSpace(body)),
+	}
+}
@@ -0,0 +1,173 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decode разбирает WriteRequest обратно в TimeSeries, чтобы проверить Encode
+func decode(t *testing.T, data []byte) []TimeSeries {
+	t.Helper()
+
+	raw, err := snappy.Decode(nil, data)
+	if err != nil {
+		t.Fatalf("snappy decode: %v", err)
+	}
+
+	var series []TimeSeries
+
+	forEachField(t, raw, func(num protowire.Number, v []byte, _ uint64) {
+		if num != 1 {
+			return
+		}
+
+		ts := TimeSeries{}
+
+		forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				l := Label{}
+				forEachField(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					if num == 1 {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+				})
+				ts.Labels = append(ts.Labels, l)
+			case 2:
+				s := Sample{}
+				forEachField(t, v, func(num protowire.Number, _ []byte, n uint64) {
+					if num == 1 {
+						s.Value = math.Float64frombits(n)
+					} else {
+						s.Timestamp = int64(n)
+					}
+				})
+				ts.Samples = append(ts.Samples, s)
+			}
+		})
+
+		series = append(series, ts)
+	})
+
+	return series
+}
+
+func forEachField(t *testing.T, b []byte, fn func(num protowire.Number, v []byte, n uint64)) {
+	t.Helper()
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("consume tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			fn(num, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			fn(num, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			fn(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	series := []TimeSeries{
+		{
+			Labels: []Label{
+				{Name: "name", Value: "Гостиная"},
+				{Name: "__name__", Value: "myheat_env_temp_current"},
+				{Name: "id", Value: "1"},
+			},
+			Samples: []Sample{{Value: 21.5, Timestamp: 1704067200000}},
+		},
+	}
+
+	want := []TimeSeries{
+		{
+			Labels: []Label{
+				{Name: "__name__", Value: "myheat_env_temp_current"},
+				{Name: "id", Value: "1"},
+				{Name: "name", Value: "Гостиная"},
+			},
+			Samples: []Sample{{Value: 21.5, Timestamp: 1704067200000}},
+		},
+	}
+
+	if got := decode(t, Encode(series)); !reflect.DeepEqual(got, want) {
+		t.Errorf("decode(Encode()) = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_Send(t *testing.T) {
+	tests := []struct {
+		name            string
+		statusCode      int
+		wantErr         bool
+		wantRecoverable bool
+	}{
+		{name: "успешная отправка", statusCode: http.StatusNoContent},
+		{name: "ошибка сервера", statusCode: http.StatusServiceUnavailable, wantErr: true, wantRecoverable: true},
+		{name: "превышен лимит", statusCode: http.StatusTooManyRequests, wantErr: true, wantRecoverable: true},
+		{name: "некорректные данные", statusCode: http.StatusBadRequest, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") != "snappy" {
+					t.Errorf("unexpected Content-Encoding %q", r.Header.Get("Content-Encoding"))
+				}
+
+				if r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("unexpected Authorization %q", r.Header.Get("Authorization"))
+				}
+
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer srv.Close()
+
+			c := NewClient(Config{URL: srv.URL, BearerToken: "secret"})
+			err := c.Send(context.Background(), Encode(nil))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil {
+				return
+			}
+
+			rwErr := &Error{}
+			if !errors.As(err, &rwErr) {
+				t.Fatalf("Send() error type = %T, want *Error", err)
+			}
+
+			if rwErr.Recoverable != tt.wantRecoverable {
+				t.Errorf("Recoverable = %v, want %v", rwErr.Recoverable, tt.wantRecoverable)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultBaseURL = "https://api.telegram.org"
+
+func NewDefaultConfig() Config {
+	return Config{
+		BaseURL: defaultBaseURL,
+	}
+}
+
+type Config struct {
+	// BaseURL адрес Bot API. Можно заменить на локальный Bot API сервер или заглушку для тестов
+	BaseURL string
+	Token   string
+}
+
+func (c Config) Validate() error {
+	if c.BaseURL == "" {
+		return errors.New("base url cannot be empty")
+	}
+
+	if c.Token == "" {
+		return errors.New("token cannot be empty")
+	}
+
+	return nil
+}
+
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:        cfg,
+		httpClient: http.DefaultClient,
+	}
+}
+
+// Client минимальный клиент Telegram Bot API: получение обновлений и отправка сообщений
+type Client struct {
+	cfg        Config
+	httpClient *http.Client
+}
+
+type Chat struct {
+	ID int64 `json:"id"`
+}
+
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+type Update struct {
+	UpdateID int64    `json:"update_id"`
+	Message  *Message `json:"message"`
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+}
+
+// Error ошибка, которую вернул Bot API
+type Error struct {
+	Code        int
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram api error %d: %s", e.Code, e.Description)
+}
+
+type getUpdatesRequest struct {
+	Offset         int64    `json:"offset,omitempty"`
+	Timeout        int      `json:"timeout"`
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+// GetUpdates получает обновления с идентификатором не меньше offset. timeout - время long polling в секундах
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout int) ([]Update, error) {
+	updates := make([]Update, 0)
+
+	req := getUpdatesRequest{Offset: offset, Timeout: timeout, AllowedUpdates: []string{"message"}}
+	if err := c.call(ctx, "getUpdates", req, &updates); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
+}
+
+type sendMessageRequest struct {
+	ChatID int64  `json:"chat_id"`
+	Text   string `json:"text"`
+}
+
+func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) error {
+	return c.call(ctx, "sendMessage", sendMessageRequest{ChatID: chatID, Text: text}, nil)
+}
+
+func (c *Client) call(ctx context.Context, method string, req, result interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(c.cfg.BaseURL, "/") + "/bot" + c.cfg.Token + "/" + method
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpRes, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		// Ошибка содержит URL, а в нем токен бота
+		return errors.New(strings.ReplaceAll(err.Error(), c.cfg.Token, "<token>"))
+	}
+	defer httpRes.Body.Close()
+
+	res := response{}
+	if err = json.NewDecoder(httpRes.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decoding %s response (status %d): %w", method, httpRes.StatusCode, err)
+	}
+
+	if !res.OK {
+		return &Error{Code: res.ErrorCode, Description: res.Description}
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(res.Result, result)
+}
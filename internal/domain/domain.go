@@ -0,0 +1,61 @@
+// Package domain модель данных отопления, с которой работают метрики и остальные выходы экспортера.
+//
+// Типы не зависят от формата ответов MyHeat API: источник данных (клиент API, симуляция) приводит к ним свои данные,
+// например, разбирает числа, которые API передает строками.
+package domain
+
+const EnvTypeRoomTemperature = "room_temperature"
+
+const (
+	DevSeverityNormal     = 1
+	DevSeverityLowBalance = 32
+)
+
+// Device контроллер
+type Device struct {
+	ID           int64
+	Name         string
+	City         string
+	Severity     int64
+	SeverityDesc string
+}
+
+// DeviceInfo текущее состояние контроллера
+type DeviceInfo struct {
+	City         string
+	DataActual   bool
+	Severity     int64
+	SeverityDesc string
+	// WeatherTemp уличная температура или nil, если она неизвестна
+	WeatherTemp *float64
+	// Alarms аварии. Формат в API не описан, поэтому аварии передаются как есть
+	Alarms  []interface{}
+	Envs    []Env
+	Heaters []Heater
+}
+
+// Env помещение или другой контур, например, бойлер. Показания, которых нет (например, датчик отключен), равны nil
+type Env struct {
+	ID           int64
+	Name         string
+	Type         string
+	Value        *float64
+	Target       *float64
+	Demand       bool
+	Severity     int64
+	SeverityDesc string
+}
+
+// Heater котел. Показания датчиков, которых нет у котла, равны nil
+type Heater struct {
+	ID            int64
+	Name          string
+	BurnerHeating bool
+	BurnerWater   bool
+	Disabled      bool
+	FlowTemp      *float64
+	ReturnTemp    *float64
+	Pressure      *float64
+	Modulation    *float64
+	TargetTemp    *float64
+}
@@ -0,0 +1,327 @@
+package export
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+)
+
+// TemperatureRow показания помещения в момент опроса. Отсутствующие показания Value и Target равны nil:
+// пустая ячейка в CSV, null в JSON и Parquet
+type TemperatureRow struct {
+	Time     string   `json:"time" parquet:"name=time, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeviceID int64    `json:"deviceId" parquet:"name=device_id, type=INT64"`
+	EnvID    int64    `json:"envId" parquet:"name=env_id, type=INT64"`
+	EnvName  string   `json:"envName" parquet:"name=env_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value    *float64 `json:"value" parquet:"name=value, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Target   *float64 `json:"target" parquet:"name=target, type=DOUBLE, repetitiontype=OPTIONAL"`
+	Demand   bool     `json:"demand" parquet:"name=demand, type=BOOLEAN"`
+}
+
+func (TemperatureRow) CSVHeader() []string {
+	return []string{"time", "device_id", "env_id", "env_name", "value", "target", "demand"}
+}
+
+func (r TemperatureRow) CSVRecord() []string {
+	return []string{
+		r.Time,
+		strconv.FormatInt(r.DeviceID, 10),
+		strconv.FormatInt(r.EnvID, 10),
+		r.EnvName,
+		formatOptionalFloat(r.Value),
+		formatOptionalFloat(r.Target),
+		strconv.FormatBool(r.Demand),
+	}
+}
+
+// DemandIntervalRow интервал, в течение которого в помещении был запрошен нагрев
+type DemandIntervalRow struct {
+	DeviceID        int64   `json:"deviceId" parquet:"name=device_id, type=INT64"`
+	EnvID           int64   `json:"envId" parquet:"name=env_id, type=INT64"`
+	EnvName         string  `json:"envName" parquet:"name=env_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Start           string  `json:"start" parquet:"name=start, type=BYTE_ARRAY, convertedtype=UTF8"`
+	End             string  `json:"end" parquet:"name=end, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DurationSeconds float64 `json:"durationSeconds" parquet:"name=duration_seconds, type=DOUBLE"`
+}
+
+func (DemandIntervalRow) CSVHeader() []string {
+	return []string{"device_id", "env_id", "env_name", "start", "end", "duration_seconds"}
+}
+
+func (r DemandIntervalRow) CSVRecord() []string {
+	return []string{
+		strconv.FormatInt(r.DeviceID, 10),
+		strconv.FormatInt(r.EnvID, 10),
+		r.EnvName,
+		r.Start,
+		r.End,
+		formatFloat(r.DurationSeconds),
+	}
+}
+
+// TariffDurationRow время нагрева помещения за сутки в рамках тарифа
+type TariffDurationRow struct {
+	Date     string  `json:"date" parquet:"name=date, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DeviceID int64   `json:"deviceId" parquet:"name=device_id, type=INT64"`
+	EnvID    int64   `json:"envId" parquet:"name=env_id, type=INT64"`
+	EnvName  string  `json:"envName" parquet:"name=env_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Tariff   int64   `json:"tariff" parquet:"name=tariff, type=INT64"`
+	Seconds  float64 `json:"seconds" parquet:"name=seconds, type=DOUBLE"`
+	Hours    float64 `json:"hours" parquet:"name=hours, type=DOUBLE"`
+}
+
+func (TariffDurationRow) CSVHeader() []string {
+	return []string{"date", "device_id", "env_id", "env_name", "tariff", "seconds", "hours"}
+}
+
+func (r TariffDurationRow) CSVRecord() []string {
+	return []string{
+		r.Date,
+		strconv.FormatInt(r.DeviceID, 10),
+		strconv.FormatInt(r.EnvID, 10),
+		r.EnvName,
+		strconv.FormatInt(r.Tariff, 10),
+		formatFloat(r.Seconds),
+		formatFloat(r.Hours),
+	}
+}
+
+type envKey struct {
+	deviceID int64
+	envID    int64
+}
+
+// TemperatureRows собирает показания помещений в строки, по одной на помещение и момент опроса
+func TemperatureRows(readings []history.Reading) []TemperatureRow {
+	type rowKey struct {
+		env envKey
+		ts  int64
+	}
+
+	rows := make(map[rowKey]*TemperatureRow)
+	keys := make([]rowKey, 0)
+
+	for _, r := range readings {
+		if r.ObjectType != history.ObjectEnv {
+			continue
+		}
+
+		key := rowKey{env: envKey{deviceID: r.DeviceID, envID: r.ObjectID}, ts: r.Time.UnixMilli()}
+
+		row, ok := rows[key]
+		if !ok {
+			row = &TemperatureRow{
+				Time:     r.Time.Format(time.RFC3339),
+				DeviceID: r.DeviceID,
+				EnvID:    r.ObjectID,
+				EnvName:  r.ObjectName,
+			}
+			rows[key] = row
+			keys = append(keys, key)
+		}
+
+		value := r.Value
+
+		switch r.Metric {
+		case "value":
+			row.Value = &value
+		case "target":
+			row.Target = &value
+		case "demand":
+			row.Demand = r.Value != 0
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].ts != keys[j].ts {
+			return keys[i].ts < keys[j].ts
+		}
+		if keys[i].env.deviceID != keys[j].env.deviceID {
+			return keys[i].env.deviceID < keys[j].env.deviceID
+		}
+		return keys[i].env.envID < keys[j].env.envID
+	})
+
+	out := make([]TemperatureRow, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *rows[k])
+	}
+
+	return out
+}
+
+// DemandInterval интервал нагрева
+type DemandInterval struct {
+	DeviceID int64
+	EnvID    int64
+	EnvName  string
+	Start    time.Time
+	End      time.Time
+}
+
+// DefaultMaxGap промежуток между опросами, после которого состояние нагрева считается неизвестным
+const DefaultMaxGap = time.Hour
+
+// DemandIntervals восстанавливает интервалы нагрева по показаниям demand. Интервал начинается с первого опроса,
+// в котором нагрев запрошен, и заканчивается опросом, в котором нагрев уже не запрошен, либо последним опросом.
+// Если до следующего опроса прошло больше maxGap (например, экспортер не работал), интервал заканчивается
+// последним опросом перед перерывом
+func DemandIntervals(readings []history.Reading, maxGap time.Duration) []DemandInterval {
+	byEnv := make(map[envKey][]history.Reading)
+	keys := make([]envKey, 0)
+
+	for _, r := range readings {
+		if r.ObjectType != history.ObjectEnv || r.Metric != "demand" {
+			continue
+		}
+
+		key := envKey{deviceID: r.DeviceID, envID: r.ObjectID}
+		if _, ok := byEnv[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		byEnv[key] = append(byEnv[key], r)
+	}
+
+	out := make([]DemandInterval, 0)
+
+	for _, key := range keys {
+		envReadings := byEnv[key]
+
+		sort.SliceStable(envReadings, func(i, j int) bool {
+			return envReadings[i].Time.Before(envReadings[j].Time)
+		})
+
+		var curr *DemandInterval
+
+		for _, r := range envReadings {
+			demand := r.Value != 0
+
+			if curr != nil && r.Time.Sub(curr.End) > maxGap {
+				if curr.End.After(curr.Start) {
+					out = append(out, *curr)
+				}
+
+				curr = nil
+			}
+
+			switch {
+			case demand && curr == nil:
+				curr = &DemandInterval{DeviceID: r.DeviceID, EnvID: r.ObjectID, EnvName: r.ObjectName, Start: r.Time, End: r.Time}
+			case demand:
+				curr.End = r.Time
+			case curr != nil:
+				curr.End = r.Time
+				out = append(out, *curr)
+				curr = nil
+			}
+		}
+
+		if curr != nil && curr.End.After(curr.Start) {
+			out = append(out, *curr)
+		}
+	}
+
+	return out
+}
+
+func DemandIntervalRows(intervals []DemandInterval) []DemandIntervalRow {
+	out := make([]DemandIntervalRow, 0, len(intervals))
+
+	for _, i := range intervals {
+		out = append(out, DemandIntervalRow{
+			DeviceID:        i.DeviceID,
+			EnvID:           i.EnvID,
+			EnvName:         i.EnvName,
+			Start:           i.Start.Format(time.RFC3339),
+			End:             i.End.Format(time.RFC3339),
+			DurationSeconds: i.End.Sub(i.Start).Seconds(),
+		})
+	}
+
+	return out
+}
+
+// TariffDurationRows раскладывает интервалы нагрева по суткам (в часовом поясе time.Local) и тарифам
+func TariffDurationRows(intervals []DemandInterval, ts *services.TariffSelector) []TariffDurationRow {
+	type rowKey struct {
+		date   string
+		env    envKey
+		tariff services.TariffType
+	}
+
+	rows := make(map[rowKey]*TariffDurationRow)
+	keys := make([]rowKey, 0)
+
+	for _, interval := range intervals {
+		for from := interval.Start; from.Before(interval.End); {
+			y, m, d := from.Date()
+			to := time.Date(y, m, d+1, 0, 0, 0, 0, from.Location())
+			if to.After(interval.End) {
+				to = interval.End
+			}
+
+			date := from.Format(time.DateOnly)
+
+			for tariff, seconds := range ts.Split(from, to) {
+				key := rowKey{date: date, env: envKey{deviceID: interval.DeviceID, envID: interval.EnvID}, tariff: tariff}
+
+				row, ok := rows[key]
+				if !ok {
+					row = &TariffDurationRow{
+						Date:     date,
+						DeviceID: interval.DeviceID,
+						EnvID:    interval.EnvID,
+						EnvName:  interval.EnvName,
+						Tariff:   int64(tariff),
+					}
+					rows[key] = row
+					keys = append(keys, key)
+				}
+
+				row.Seconds += seconds
+				row.Hours = row.Seconds / 3600
+			}
+
+			from = to
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+
+		if a.date != b.date {
+			return a.date < b.date
+		}
+		if a.env.deviceID != b.env.deviceID {
+			return a.env.deviceID < b.env.deviceID
+		}
+		if a.env.envID != b.env.envID {
+			return a.env.envID < b.env.envID
+		}
+		return a.tariff < b.tariff
+	})
+
+	out := make([]TariffDurationRow, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *rows[k])
+	}
+
+	return out
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatOptionalFloat форматирует показание, отсутствующее показание - пустая строка
+func formatOptionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+
+	return formatFloat(*v)
+}
@@ -0,0 +1,239 @@
+package export
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func testReading(ts time.Time, metric string, value float64) history.Reading {
+	return history.Reading{
+		Time:       ts,
+		DeviceID:   10,
+		ObjectType: history.ObjectEnv,
+		ObjectID:   1,
+		ObjectName: "Гостиная",
+		Metric:     metric,
+		Value:      value,
+	}
+}
+
+func testDemandReadings() []history.Reading {
+	start := time.Date(2024, time.January, 1, 22, 0, 0, 0, time.UTC)
+
+	return []history.Reading{
+		testReading(start, "demand", 1),
+		testReading(start.Add(time.Hour), "demand", 1),
+		testReading(start.Add(3*time.Hour), "demand", 0),
+		testReading(start.Add(4*time.Hour), "demand", 1),
+		testReading(start.Add(5*time.Hour), "demand", 1),
+	}
+}
+
+func TestTemperatureRows(t *testing.T) {
+	ts := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	readings := []history.Reading{
+		testReading(ts, "value", 21.5),
+		testReading(ts, "target", 22),
+		testReading(ts, "demand", 1),
+		{Time: ts, DeviceID: 10, ObjectType: history.ObjectDevice, ObjectID: 10, Metric: "weather_temp", Value: -5},
+	}
+
+	want := []TemperatureRow{{
+		Time:     "2024-01-01T12:00:00Z",
+		DeviceID: 10,
+		EnvID:    1,
+		EnvName:  "Гостиная",
+		Value:    floatPtr(21.5),
+		Target:   floatPtr(22),
+		Demand:   true,
+	}}
+
+	if got := TemperatureRows(readings); !reflect.DeepEqual(got, want) {
+		t.Errorf("TemperatureRows() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWrite_MissingReadings(t *testing.T) {
+	ts := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	// У помещения есть цель, но нет текущей температуры
+	rows := TemperatureRows([]history.Reading{
+		testReading(ts, "target", 22),
+		testReading(ts, "demand", 1),
+	})
+
+	tests := []struct {
+		format Format
+		check  func(t *testing.T, out []byte)
+	}{
+		{
+			format: FormatCSV,
+			check: func(t *testing.T, out []byte) {
+				want := "time,device_id,env_id,env_name,value,target,demand\n" +
+					"2024-01-01T12:00:00Z,10,1,Гостиная,,22,true\n"
+
+				if string(out) != want {
+					t.Errorf("csv = %q, want %q", out, want)
+				}
+			},
+		},
+		{
+			format: FormatJSON,
+			check: func(t *testing.T, out []byte) {
+				if !strings.Contains(string(out), `"value": null`) {
+					t.Errorf("json does not contain null value: %s", out)
+				}
+			},
+		},
+		{
+			format: FormatParquet,
+			check: func(t *testing.T, out []byte) {
+				pr, err := reader.NewParquetReader(buffer.NewBufferFileFromBytes(out), new(TemperatureRow), 1)
+				if err != nil {
+					t.Fatalf("NewParquetReader() error = %v", err)
+				}
+				defer pr.ReadStop()
+
+				got := make([]TemperatureRow, pr.GetNumRows())
+				if err = pr.Read(&got); err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+
+				if !reflect.DeepEqual(got, rows) {
+					t.Errorf("parquet rows = %+v, want %+v", got, rows)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			if err := Write(buf, tt.format, rows); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			tt.check(t, buf.Bytes())
+		})
+	}
+}
+
+func TestDemandIntervals(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 22, 0, 0, 0, time.UTC)
+
+	got := DemandIntervals(testDemandReadings(), 2*time.Hour)
+
+	want := []DemandInterval{
+		{DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Start: start, End: start.Add(3 * time.Hour)},
+		{DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Start: start.Add(4 * time.Hour), End: start.Add(5 * time.Hour)},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DemandIntervals() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDemandIntervals_MaxGap(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 22, 0, 0, 0, time.UTC)
+
+	// Экспортер не работал 10 часов, пока нагрев был запрошен
+	readings := []history.Reading{
+		testReading(start, "demand", 1),
+		testReading(start.Add(30*time.Minute), "demand", 1),
+		testReading(start.Add(10*time.Hour+30*time.Minute), "demand", 1),
+		testReading(start.Add(11*time.Hour), "demand", 0),
+		testReading(start.Add(12*time.Hour), "demand", 1),
+		testReading(start.Add(20*time.Hour), "demand", 0),
+	}
+
+	got := DemandIntervals(readings, time.Hour)
+
+	want := []DemandInterval{
+		{DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Start: start, End: start.Add(30 * time.Minute)},
+		{DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Start: start.Add(10*time.Hour + 30*time.Minute), End: start.Add(11 * time.Hour)},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DemandIntervals() = %+v, want %+v", got, want)
+	}
+}
+
+func TestTariffDurationRows(t *testing.T) {
+	ts := services.NewTariffSelector(time.Now, []services.Tariff{services.NewNightTariff(23, 7)})
+
+	got := TariffDurationRows(DemandIntervals(testDemandReadings(), 2*time.Hour), ts)
+
+	want := []TariffDurationRow{
+		{Date: "2024-01-01", DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Tariff: 1, Seconds: 3600, Hours: 1},
+		{Date: "2024-01-01", DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Tariff: 2, Seconds: 3600, Hours: 1},
+		{Date: "2024-01-02", DeviceID: 10, EnvID: 1, EnvName: "Гостиная", Tariff: 2, Seconds: 7200, Hours: 2},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("TariffDurationRows() = %+v, want %+v", got, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	rows := DemandIntervalRows(DemandIntervals(testDemandReadings(), 2*time.Hour))
+
+	tests := []struct {
+		format Format
+		check  func(t *testing.T, out string)
+	}{
+		{
+			format: FormatCSV,
+			check: func(t *testing.T, out string) {
+				want := "device_id,env_id,env_name,start,end,duration_seconds\n" +
+					"10,1,Гостиная,2024-01-01T22:00:00Z,2024-01-02T01:00:00Z,10800\n" +
+					"10,1,Гостиная,2024-01-02T02:00:00Z,2024-01-02T03:00:00Z,3600\n"
+
+				if out != want {
+					t.Errorf("csv = %q, want %q", out, want)
+				}
+			},
+		},
+		{
+			format: FormatJSON,
+			check: func(t *testing.T, out string) {
+				if !strings.Contains(out, `"durationSeconds": 10800`) {
+					t.Errorf("json does not contain duration: %s", out)
+				}
+			},
+		},
+		{
+			format: FormatParquet,
+			check: func(t *testing.T, out string) {
+				if !strings.HasPrefix(out, "PAR1") || !strings.HasSuffix(out, "PAR1") {
+					t.Errorf("output is not a parquet file")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			buf := &bytes.Buffer{}
+
+			if err := Write(buf, tt.format, rows); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+
+			tt.check(t, buf.String())
+		})
+	}
+}
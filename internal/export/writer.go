@@ -0,0 +1,84 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type Format string
+
+const (
+	FormatCSV     Format = "csv"
+	FormatJSON    Format = "json"
+	FormatParquet Format = "parquet"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSON, FormatParquet:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q", s)
+	}
+}
+
+// Row строка отчета. Для формата parquet схема берется из тегов parquet структуры
+type Row interface {
+	CSVHeader() []string
+	CSVRecord() []string
+}
+
+// Write записывает строки отчета в w в заданном формате
+func Write[T Row](w io.Writer, format Format, rows []T) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, rows)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(rows)
+	case FormatParquet:
+		return writeParquet(w, rows)
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func writeCSV[T Row](w io.Writer, rows []T) error {
+	cw := csv.NewWriter(w)
+
+	var zero T
+	if err := cw.Write(zero.CSVHeader()); err != nil {
+		return err
+	}
+
+	for _, r := range rows {
+		if err := cw.Write(r.CSVRecord()); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func writeParquet[T Row](w io.Writer, rows []T) error {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(T), 1)
+	if err != nil {
+		return fmt.Errorf("creating parquet writer: %w", err)
+	}
+
+	for _, r := range rows {
+		if err = pw.Write(r); err != nil {
+			return fmt.Errorf("writing parquet row: %w", err)
+		}
+	}
+
+	return pw.WriteStop()
+}
@@ -0,0 +1,281 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// SQLite драйвер без cgo, чтобы собирать бинарь с CGO_ENABLED=0
+	_ "modernc.org/sqlite"
+)
+
+// Типы объектов, к которым относятся показания
+const (
+	ObjectDevice = "device"
+	ObjectEnv    = "env"
+	ObjectHeater = "heater"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS readings (
+	ts          INTEGER NOT NULL,
+	device_id   INTEGER NOT NULL,
+	object_type TEXT    NOT NULL,
+	object_id   INTEGER NOT NULL,
+	object_name TEXT    NOT NULL,
+	metric      TEXT    NOT NULL,
+	value       REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS readings_object_idx ON readings (object_type, object_id, metric, ts);
+CREATE INDEX IF NOT EXISTS readings_ts_idx ON readings (ts);
+`
+
+// Reading одно показание, полученное при опросе
+type Reading struct {
+	Time       time.Time
+	DeviceID   int64
+	ObjectType string
+	ObjectID   int64
+	ObjectName string
+	Metric     string
+	Value      float64
+}
+
+// Open открывает или создает базу по пути path
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite не поддерживает параллельную запись, поэтому сериализуем обращения на уровне пула
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.Exec("PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("configuring database: %w", err)
+	}
+
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating schema: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Store хранилище показаний в SQLite
+type Store struct {
+	db *sql.DB
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Insert(ctx context.Context, readings []Reading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO readings (ts, device_id, object_type, object_id, object_name, metric, value)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range readings {
+		_, err = stmt.ExecContext(ctx, r.Time.UnixMilli(), r.DeviceID, r.ObjectType, r.ObjectID, r.ObjectName, r.Metric, r.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBefore удаляет показания старше t и возвращает число удаленных строк
+func (s *Store) DeleteBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM readings WHERE ts < ?", t.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Object объект, к которому относятся показания
+type Object struct {
+	Type     string `json:"type"`
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	DeviceID int64  `json:"deviceId"`
+}
+
+var ErrObjectNotFound = errors.New("object not found")
+
+// FindObject ищет объект по идентификатору или по имени (последнему известному)
+func (s *Store) FindObject(ctx context.Context, objectType, idOrName string) (Object, error) {
+	obj := Object{Type: objectType}
+
+	row := s.db.QueryRowContext(ctx, `
+		SELECT object_id, object_name, device_id FROM readings
+		WHERE object_type = ? AND (CAST(object_id AS TEXT) = ? OR object_name = ?)
+		ORDER BY ts DESC LIMIT 1`, objectType, idOrName, idOrName)
+
+	err := row.Scan(&obj.ID, &obj.Name, &obj.DeviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return obj, ErrObjectNotFound
+	}
+
+	return obj, err
+}
+
+// Objects возвращает все известные объекты
+func (s *Store) Objects(ctx context.Context) ([]Object, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT object_type, object_id, device_id, object_name FROM readings r
+		WHERE ts = (SELECT MAX(ts) FROM readings WHERE object_type = r.object_type AND object_id = r.object_id)
+		GROUP BY object_type, object_id
+		ORDER BY object_type, object_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Object, 0)
+
+	for rows.Next() {
+		obj := Object{}
+		if err = rows.Scan(&obj.Type, &obj.ID, &obj.DeviceID, &obj.Name); err != nil {
+			return nil, err
+		}
+
+		out = append(out, obj)
+	}
+
+	return out, rows.Err()
+}
+
+type Query struct {
+	ObjectType string
+	ObjectID   int64
+	// Metrics если пусто, возвращаются все метрики объекта
+	Metrics []string
+	From    time.Time
+	To      time.Time
+	// Step если задан, значения усредняются по интервалам такой длины
+	Step time.Duration
+}
+
+type Point struct {
+	Time  time.Time `json:"t"`
+	Value float64   `json:"v"`
+}
+
+// Query возвращает показания объекта за интервал [From, To], сгруппированные по метрикам
+func (s *Store) Query(ctx context.Context, q Query) (map[string][]Point, error) {
+	stepMs := q.Step.Milliseconds()
+	if stepMs <= 0 {
+		stepMs = 1
+	}
+
+	args := []interface{}{stepMs, stepMs, q.ObjectType, q.ObjectID, q.From.UnixMilli(), q.To.UnixMilli()}
+
+	metricFilter := ""
+	if len(q.Metrics) > 0 {
+		metricFilter = " AND metric IN (" + strings.TrimSuffix(strings.Repeat("?,", len(q.Metrics)), ",") + ")"
+
+		for _, m := range q.Metrics {
+			args = append(args, m)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT (ts / ?) * ? AS bucket, metric, AVG(value) FROM readings
+		WHERE object_type = ? AND object_id = ? AND ts >= ? AND ts <= ?`+metricFilter+`
+		GROUP BY bucket, metric
+		ORDER BY bucket`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]Point)
+
+	for rows.Next() {
+		var (
+			bucket int64
+			metric string
+			value  float64
+		)
+
+		if err = rows.Scan(&bucket, &metric, &value); err != nil {
+			return nil, err
+		}
+
+		out[metric] = append(out[metric], Point{Time: time.UnixMilli(bucket).UTC(), Value: value})
+	}
+
+	return out, rows.Err()
+}
+
+type ReadingsQuery struct {
+	ObjectType string
+	// DeviceIDs если пусто, возвращаются показания всех устройств
+	DeviceIDs []int64
+	From      time.Time
+	To        time.Time
+}
+
+// Readings возвращает показания объектов за интервал [From, To], упорядоченные по объекту и времени
+func (s *Store) Readings(ctx context.Context, q ReadingsQuery) ([]Reading, error) {
+	args := []interface{}{q.ObjectType, q.From.UnixMilli(), q.To.UnixMilli()}
+
+	deviceFilter := ""
+	if len(q.DeviceIDs) > 0 {
+		deviceFilter = " AND device_id IN (" + strings.TrimSuffix(strings.Repeat("?,", len(q.DeviceIDs)), ",") + ")"
+
+		for _, id := range q.DeviceIDs {
+			args = append(args, id)
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ts, device_id, object_type, object_id, object_name, metric, value FROM readings
+		WHERE object_type = ? AND ts >= ? AND ts <= ?`+deviceFilter+`
+		ORDER BY device_id, object_id, ts, metric`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Reading, 0)
+
+	for rows.Next() {
+		var (
+			r  Reading
+			ts int64
+		)
+
+		if err = rows.Scan(&ts, &r.DeviceID, &r.ObjectType, &r.ObjectID, &r.ObjectName, &r.Metric, &r.Value); err != nil {
+			return nil, err
+		}
+
+		r.Time = time.UnixMilli(ts)
+		out = append(out, r)
+	}
+
+	return out, rows.Err()
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+	"gopkg.in/yaml.v2"
+)
+
+type AlertKind string
+
+const (
+	// AlertLowBalance у контроллера низкий баланс SIM-карты
+	AlertLowBalance AlertKind = "low_balance"
+	// AlertEnvBelow температура помещения ниже порога
+	AlertEnvBelow AlertKind = "env_below"
+	// AlertDataNotActual данные контроллера неактуальны или их не удалось получить, обычно контроллер не в сети
+	AlertDataNotActual AlertKind = "data_not_actual"
+	// AlertHeaterFault контроллер сообщает об авариях
+	AlertHeaterFault AlertKind = "heater_fault"
+)
+
+type AlertStatus string
+
+const (
+	AlertFiring   AlertStatus = "firing"
+	AlertResolved AlertStatus = "resolved"
+)
+
+type AlertRule struct {
+	Name string    `yaml:"name"`
+	Kind AlertKind `yaml:"kind"`
+	// Threshold порог температуры для env_below
+	Threshold float64 `yaml:"threshold"`
+	// For сколько условие должно выполняться, прежде чем сработает оповещение
+	For time.Duration `yaml:"for"`
+	// Env идентификатор или имя помещения для env_below. Если не задан, проверяются все помещения
+	Env string `yaml:"env"`
+}
+
+func (r AlertRule) Validate() error {
+	if r.Name == "" {
+		return errors.New("rule name cannot be empty")
+	}
+
+	switch r.Kind {
+	case AlertLowBalance, AlertEnvBelow, AlertDataNotActual, AlertHeaterFault:
+	default:
+		return fmt.Errorf("rule %s: unknown kind %q", r.Name, r.Kind)
+	}
+
+	if r.For < 0 {
+		return fmt.Errorf("rule %s: for cannot be negative", r.Name)
+	}
+
+	return nil
+}
+
+// Alert оповещение о срабатывании или завершении правила
+type Alert struct {
+	// Key идентифицирует оповещение: правило и объект, к которому оно относится
+	Key        string      `json:"key"`
+	Rule       string      `json:"rule"`
+	Kind       AlertKind   `json:"kind"`
+	Status     AlertStatus `json:"status"`
+	StartsAt   time.Time   `json:"startsAt"`
+	ResolvedAt *time.Time  `json:"resolvedAt,omitempty"`
+	DeviceID   int64       `json:"deviceId"`
+	DeviceName string      `json:"deviceName"`
+	EnvID      int64       `json:"envId,omitempty"`
+	EnvName    string      `json:"envName,omitempty"`
+	Value      float64     `json:"value"`
+	Threshold  float64     `json:"threshold,omitempty"`
+	Summary    string      `json:"summary"`
+}
+
+// AlertNotifier доставляет оповещения. Notify не должен блокировать опрос
+type AlertNotifier interface {
+	Notify(a Alert)
+}
+
+// NotificationsConfig содержимое файла настройки оповещений
+type NotificationsConfig struct {
+	Rules    []AlertRule     `yaml:"rules"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// DefaultAlertRules правила, которые используются, если файл настройки оповещений не задан
+func DefaultAlertRules() []AlertRule {
+	return []AlertRule{
+		{Name: "low-balance", Kind: AlertLowBalance},
+		{Name: "room-cold", Kind: AlertEnvBelow, Threshold: 12, For: time.Minute * 30},
+		{Name: "offline", Kind: AlertDataNotActual, For: time.Minute * 10},
+	}
+}
+
+func (c NotificationsConfig) Validate() error {
+	names := make(map[string]bool, len(c.Rules))
+
+	for _, r := range c.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+
+		if names[r.Name] {
+			return fmt.Errorf("duplicate rule name %s", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	for i, w := range c.Webhooks {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("webhook %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func LoadNotificationsConfig(path string) (NotificationsConfig, error) {
+	cfg := NotificationsConfig{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func NewAlerter(rules []AlertRule, l wdlogger.Logger) *Alerter {
+	return &Alerter{
+		rules:   rules,
+		logger:  l,
+		states:  make(map[string]*alertState),
+		devices: make(map[int64]string),
+	}
+}
+
+// Alerter проверяет правила после каждого опроса и сообщает получателям о срабатывании и завершении оповещений.
+// Пока оповещение активно, повторно оно не отправляется
+type Alerter struct {
+	rules     []AlertRule
+	logger    wdlogger.Logger
+	notifiers []AlertNotifier
+
+	mu     sync.Mutex
+	states map[string]*alertState
+	// devices имена всех известных устройств, чтобы заметить устройства, пропавшие из опроса
+	devices map[int64]string
+}
+
+type alertState struct {
+	alert  Alert
+	firing bool
+}
+
+// alertCondition результат проверки правила для одного объекта
+type alertCondition struct {
+	alert  Alert
+	active bool
+	// waitFor сколько условие должно выполняться до срабатывания
+	waitFor time.Duration
+}
+
+// AddNotifier добавляет получателей оповещений. Должен вызываться до Run экспортера
+func (a *Alerter) AddNotifier(n ...AlertNotifier) {
+	a.notifiers = append(a.notifiers, n...)
+}
+
+// Firing возвращает активные оповещения
+func (a *Alerter) Firing() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]Alert, 0)
+
+	for _, st := range a.states {
+		if st.firing {
+			out = append(out, st.alert)
+		}
+	}
+
+	return out
+}
+
+func (a *Alerter) HandlePull(_ context.Context, res PullResult) {
+	for _, alert := range a.evaluate(res) {
+		for _, n := range a.notifiers {
+			n.Notify(alert)
+		}
+	}
+}
+
+// evaluate обновляет состояние правил и возвращает оповещения, которые нужно отправить
+func (a *Alerter) evaluate(res PullResult) []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	conditions := make([]alertCondition, 0)
+	seen := make(map[int64]bool, len(res.Devices))
+	// envs помещения устройств этого опроса: true, если у помещения есть показание
+	envs := make(map[int64]map[int64]bool, len(res.Devices))
+
+	for _, dev := range res.Devices {
+		seen[dev.Device.ID] = true
+		a.devices[dev.Device.ID] = dev.Device.Name
+
+		envs[dev.Device.ID] = make(map[int64]bool, len(dev.Info.Envs))
+
+		for _, env := range dev.Info.Envs {
+			if env.Type == domain.EnvTypeRoomTemperature {
+				envs[dev.Device.ID][env.ID] = env.Value != nil
+			}
+		}
+
+		for _, r := range a.rules {
+			conditions = append(conditions, r.check(dev)...)
+		}
+	}
+
+	// Устройство, данные которого не удалось получить, считается неактуальным
+	for id, name := range a.devices {
+		if seen[id] {
+			continue
+		}
+
+		for _, r := range a.rules {
+			if r.Kind == AlertDataNotActual {
+				conditions = append(conditions, alertCondition{
+					alert:   newAlert(r, DeviceSnapshot{Device: domain.Device{ID: id, Name: name}}, nil, 1),
+					active:  true,
+					waitFor: r.For,
+				})
+			}
+		}
+	}
+
+	out := make([]Alert, 0)
+	checked := make(map[string]bool, len(conditions))
+
+	for _, c := range conditions {
+		checked[c.alert.Key] = true
+
+		st, ok := a.states[c.alert.Key]
+
+		if !c.active {
+			if ok && st.firing {
+				resolved := st.alert
+				resolved.Status = AlertResolved
+				resolved.ResolvedAt = &res.Time
+				resolved.Value = c.alert.Value
+				resolved.Summary = alertSummary(resolved)
+
+				out = append(out, resolved)
+			}
+
+			delete(a.states, c.alert.Key)
+
+			continue
+		}
+
+		if !ok {
+			st = &alertState{alert: c.alert}
+			st.alert.StartsAt = res.Time
+			a.states[c.alert.Key] = st
+		}
+
+		st.alert.Value = c.alert.Value
+
+		if !st.firing && res.Time.Sub(st.alert.StartsAt) >= c.waitFor {
+			st.firing = true
+			st.alert.Status = AlertFiring
+			st.alert.Summary = alertSummary(st.alert)
+
+			out = append(out, st.alert)
+		}
+	}
+
+	// Помещение, которое больше не проверяется (удалено, переименовано или отфильтровано), завершает оповещение.
+	// Оповещения устройства, пропавшего из опроса, и помещения без показания сохраняют состояние
+	stale := make([]string, 0)
+
+	for key, st := range a.states {
+		if checked[key] || !seen[st.alert.DeviceID] || st.alert.EnvID == 0 {
+			continue
+		}
+
+		hasValue, ok := envs[st.alert.DeviceID][st.alert.EnvID]
+		if !ok || hasValue {
+			stale = append(stale, key)
+		}
+	}
+
+	sort.Strings(stale)
+
+	for _, key := range stale {
+		if st := a.states[key]; st.firing {
+			resolved := st.alert
+			resolved.Status = AlertResolved
+			resolved.ResolvedAt = &res.Time
+			resolved.Summary = alertSummary(resolved)
+
+			out = append(out, resolved)
+		}
+
+		delete(a.states, key)
+	}
+
+	return out
+}
+
+// check проверяет правило для устройства
+func (r AlertRule) check(dev DeviceSnapshot) []alertCondition {
+	switch r.Kind {
+	case AlertLowBalance:
+		severity := dev.Info.Severity
+		if severity == 0 {
+			severity = dev.Device.Severity
+		}
+
+		return []alertCondition{{
+			alert:   newAlert(r, dev, nil, float64(severity)),
+			active:  severity == domain.DevSeverityLowBalance,
+			waitFor: r.For,
+		}}
+	case AlertDataNotActual:
+		return []alertCondition{{
+			alert:   newAlert(r, dev, nil, boolToFloat64(!dev.Info.DataActual)),
+			active:  !dev.Info.DataActual,
+			waitFor: r.For,
+		}}
+	case AlertHeaterFault:
+		return []alertCondition{{
+			alert:   newAlert(r, dev, nil, float64(len(dev.Info.Alarms))),
+			active:  len(dev.Info.Alarms) > 0,
+			waitFor: r.For,
+		}}
+	case AlertEnvBelow:
+		out := make([]alertCondition, 0)
+
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			if r.Env != "" && r.Env != env.Name && r.Env != strconv.FormatInt(env.ID, 10) {
+				continue
+			}
+
+			// Без показания условие не проверяется, активное оповещение сохраняет состояние
+			if env.Value == nil {
+				continue
+			}
+
+			env := env
+
+			out = append(out, alertCondition{
+				alert:   newAlert(r, dev, &env, *env.Value),
+				active:  *env.Value < r.Threshold,
+				waitFor: r.For,
+			})
+		}
+
+		return out
+	default:
+		return nil
+	}
+}
+
+func newAlert(r AlertRule, dev DeviceSnapshot, env *domain.Env, value float64) Alert {
+	a := Alert{
+		Key:        fmt.Sprintf("%s/%d", r.Name, dev.Device.ID),
+		Rule:       r.Name,
+		Kind:       r.Kind,
+		DeviceID:   dev.Device.ID,
+		DeviceName: dev.Device.Name,
+		Value:      value,
+	}
+
+	if env != nil {
+		a.Key = fmt.Sprintf("%s/%d", a.Key, env.ID)
+		a.EnvID = env.ID
+		a.EnvName = env.Name
+		a.Threshold = r.Threshold
+	}
+
+	return a
+}
+
+// alertSummary текст оповещения для людей
+func alertSummary(a Alert) string {
+	var text string
+
+	switch a.Kind {
+	case AlertLowBalance:
+		text = fmt.Sprintf("%s: низкий баланс SIM-карты контроллера", a.DeviceName)
+	case AlertEnvBelow:
+		text = fmt.Sprintf("%s, %s: температура %s °C ниже %s °C", a.DeviceName, a.EnvName, formatFloat(a.Value), formatFloat(a.Threshold))
+	case AlertDataNotActual:
+		text = fmt.Sprintf("%s: данные контроллера неактуальны, возможно он не в сети", a.DeviceName)
+	case AlertHeaterFault:
+		text = fmt.Sprintf("%s: контроллер сообщает об авариях (%s)", a.DeviceName, formatFloat(a.Value))
+	default:
+		text = fmt.Sprintf("%s: %s", a.DeviceName, a.Rule)
+	}
+
+	if a.Status == AlertResolved {
+		return "Решено. " + text
+	}
+
+	return text
+}
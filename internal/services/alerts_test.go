@@ -0,0 +1,168 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestAlerter_evaluate(t *testing.T) {
+	a := NewAlerter([]AlertRule{
+		{Name: "cold", Kind: AlertEnvBelow, Threshold: 22, For: 10 * time.Minute, Env: "Гостиная"},
+		{Name: "offline", Kind: AlertDataNotActual},
+	}, nopwrap.NewNopWrapper())
+
+	pull := func(offset time.Duration, value float64, dataActual bool) PullResult {
+		res := testPullResult()
+		res.Time = res.Time.Add(offset)
+		res.Devices[0].Info.DataActual = dataActual
+		res.Devices[0].Info.Envs[0].Value = &value
+
+		return res
+	}
+
+	withoutEnv := func(offset time.Duration) PullResult {
+		res := pull(offset, 22.5, true)
+		res.Devices[0].Info.Envs = res.Devices[0].Info.Envs[1:]
+
+		return res
+	}
+
+	steps := []struct {
+		name string
+		res  PullResult
+		want []AlertStatus
+		keys []string
+	}{
+		{name: "холодно, но еще не 10 минут", res: pull(0, 21, true)},
+		{name: "холодно 5 минут", res: pull(5*time.Minute, 20, true)},
+		{name: "холодно 10 минут", res: pull(10*time.Minute, 20, true), want: []AlertStatus{AlertFiring}, keys: []string{"cold/10/1"}},
+		{name: "повторно не отправляется", res: pull(15*time.Minute, 19, true)},
+		{name: "потеплело", res: pull(20*time.Minute, 22.5, true), want: []AlertStatus{AlertResolved}, keys: []string{"cold/10/1"}},
+		{name: "данные неактуальны", res: pull(25*time.Minute, 22.5, false), want: []AlertStatus{AlertFiring}, keys: []string{"offline/10"}},
+		{name: "устройство пропало из опроса", res: PullResult{Time: testPullResult().Time.Add(30 * time.Minute)}},
+		{name: "устройство вернулось", res: pull(35*time.Minute, 22.5, true), want: []AlertStatus{AlertResolved}, keys: []string{"offline/10"}},
+		{name: "снова холодно", res: pull(40*time.Minute, 20, true)},
+		{name: "холодно 10 минут после возвращения", res: pull(50*time.Minute, 20, true), want: []AlertStatus{AlertFiring}, keys: []string{"cold/10/1"}},
+		{name: "помещение пропало из опроса", res: withoutEnv(55 * time.Minute), want: []AlertStatus{AlertResolved}, keys: []string{"cold/10/1"}},
+	}
+
+	for _, s := range steps {
+		got := a.evaluate(s.res)
+
+		if len(got) != len(s.want) {
+			t.Fatalf("%s: alerts = %+v, want statuses %v", s.name, got, s.want)
+		}
+
+		for i := range got {
+			if got[i].Status != s.want[i] || got[i].Key != s.keys[i] {
+				t.Errorf("%s: alert = %s %s, want %s %s", s.name, got[i].Status, got[i].Key, s.want[i], s.keys[i])
+			}
+		}
+	}
+}
+
+func TestAlerter_evaluate_keepsUncheckedState(t *testing.T) {
+	a := NewAlerter([]AlertRule{
+		{Name: "cold", Kind: AlertEnvBelow, Threshold: 22},
+		{Name: "lb", Kind: AlertLowBalance},
+	}, nopwrap.NewNopWrapper())
+
+	cold := testPullResult()
+	cold.Devices[0].Device.Severity = domain.DevSeverityLowBalance
+	cold.Devices[0].Info.Envs[0].Value = floatPtr(20)
+
+	withoutDevice := testPullResult()
+	withoutDevice.Time = cold.Time.Add(time.Minute)
+	withoutDevice.Devices = nil
+
+	withoutValue := testPullResult()
+	withoutValue.Time = cold.Time.Add(2 * time.Minute)
+	withoutValue.Devices[0].Device.Severity = domain.DevSeverityLowBalance
+	withoutValue.Devices[0].Info.Envs[0].Value = nil
+
+	coldAgain := cold
+	coldAgain.Time = cold.Time.Add(3 * time.Minute)
+
+	steps := []struct {
+		name string
+		res  PullResult
+		want int
+	}{
+		{name: "срабатывание", res: cold, want: 2},
+		{name: "устройство пропало из опроса", res: withoutDevice},
+		{name: "нет показания помещения", res: withoutValue},
+		{name: "снова холодно", res: coldAgain},
+	}
+
+	for _, s := range steps {
+		if got := a.evaluate(s.res); len(got) != s.want {
+			t.Errorf("%s: alerts = %+v, want %d", s.name, got, s.want)
+		}
+	}
+
+	if firing := a.Firing(); len(firing) != 2 {
+		t.Errorf("Firing() = %+v, want 2 alerts", firing)
+	}
+}
+
+func TestAlerter_lowBalance(t *testing.T) {
+	a := NewAlerter([]AlertRule{{Name: "balance", Kind: AlertLowBalance}}, nopwrap.NewNopWrapper())
+
+	got := a.evaluate(testPullResult())
+	if len(got) != 1 {
+		t.Fatalf("alerts = %+v, want one", got)
+	}
+
+	if got[0].Summary != "Дом: низкий баланс SIM-карты контроллера" {
+		t.Errorf("summary = %q", got[0].Summary)
+	}
+
+	if firing := a.Firing(); len(firing) != 1 || firing[0].Key != "balance/10" {
+		t.Errorf("Firing() = %+v", firing)
+	}
+}
+
+func TestLoadNotificationsConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.yml")
+
+	data := `
+rules:
+  - name: cold
+    kind: env_below
+    threshold: 15
+    for: 30m
+webhooks:
+  - url: http://localhost/hook
+    send_resolved: false
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadNotificationsConfig(path)
+	if err != nil {
+		t.Fatalf("LoadNotificationsConfig() error = %v", err)
+	}
+
+	if cfg.Rules[0].For != 30*time.Minute || cfg.Rules[0].Threshold != 15 {
+		t.Errorf("unexpected rule: %+v", cfg.Rules[0])
+	}
+
+	wh := cfg.Webhooks[0]
+	if wh.SendResolved || wh.MaxRetries != defaultWebhookMaxRetries || wh.Timeout != defaultWebhookTimeout {
+		t.Errorf("webhook defaults are not applied: %+v", wh)
+	}
+
+	if err = os.WriteFile(path, []byte("rules:\n  - name: x\n    kind: unknown\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadNotificationsConfig(path); err == nil {
+		t.Errorf("expected error for unknown rule kind")
+	}
+}
@@ -0,0 +1,577 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultDashboardTitle   = "MyHeat"
+	defaultDashboardUID     = "myheat-exporter"
+	defaultDashboardJob     = "myheat"
+	dashboardSchemaVersion  = 39
+	dashboardGridWidth      = 24
+	dashboardDatasourceName = "datasource"
+)
+
+func NewDefaultDashboardConfig() DashboardConfig {
+	return DashboardConfig{
+		Title:        defaultDashboardTitle,
+		UID:          defaultDashboardUID,
+		Job:          defaultDashboardJob,
+		TariffPrices: make(map[TariffType]float64),
+	}
+}
+
+type DashboardConfig struct {
+	Title string
+	UID   string
+	// Job имя задания Prometheus, которое собирает метрики экспортера
+	Job string
+	// HeaterPowerKW мощность котла, используется для подсчета энергии и стоимости
+	HeaterPowerKW float64
+	// Tariffs тарифы, для которых строятся панели энергии и стоимости
+	Tariffs []TariffType
+	// TariffPrices стоимость кВт⋅ч по тарифам
+	TariffPrices map[TariffType]float64
+	// DeviceIDs устройства для переменной device. Если не заданы, список берется из Prometheus
+	DeviceIDs []int64
+}
+
+func (c DashboardConfig) Validate() error {
+	if c.Title == "" {
+		return errors.New("title cannot be empty")
+	}
+
+	if c.UID == "" {
+		return errors.New("uid cannot be empty")
+	}
+
+	if c.Job == "" {
+		return errors.New("job cannot be empty")
+	}
+
+	if c.HeaterPowerKW < 0 {
+		return errors.New("heater power cannot be negative")
+	}
+
+	if len(c.Tariffs) == 0 {
+		return errors.New("tariffs cannot be empty")
+	}
+
+	return nil
+}
+
+// MetricNames возвращает имена всех метрик, которые регистрирует экспортер
+func MetricNames() map[string]bool {
+	names := make(map[string]bool)
+
+	for _, list := range [][]string{metricsNames, dutyCycleMetricNames, degreeDaysMetricNames} {
+		for _, name := range list {
+			names[name] = true
+		}
+	}
+
+	return names
+}
+
+// dashboardPanel описание панели. Панель попадает на дэшборд, только если зарегистрированы все ее метрики
+type dashboardPanel struct {
+	typ         string
+	title       string
+	description string
+	unit        string
+	width       int
+	height      int
+	metrics     []string
+	targets     []dashboardTarget
+	options     map[string]interface{}
+}
+
+type dashboardTarget struct {
+	expr   string
+	legend string
+}
+
+type dashboardRow struct {
+	title  string
+	panels []dashboardPanel
+}
+
+// GenerateDashboard формирует JSON дэшборда Grafana. metrics - зарегистрированные метрики, см. MetricNames
+func GenerateDashboard(cfg DashboardConfig, metrics map[string]bool) ([]byte, error) {
+	rows := []dashboardRow{
+		dashboardEnvRow(),
+		dashboardHeaterRow(),
+		dashboardEnergyRow(cfg),
+		dashboardDeviceRow(),
+		dashboardExporterRow(cfg),
+	}
+
+	panels := make([]map[string]interface{}, 0)
+	id, y := 1, 0
+
+	for _, row := range rows {
+		rowPanels := make([]dashboardPanel, 0, len(row.panels))
+
+		for _, p := range row.panels {
+			if hasMetrics(metrics, p.metrics) {
+				rowPanels = append(rowPanels, p)
+			}
+		}
+
+		if len(rowPanels) == 0 {
+			continue
+		}
+
+		panels = append(panels, map[string]interface{}{
+			"id":        id,
+			"type":      "row",
+			"title":     row.title,
+			"collapsed": false,
+			"panels":    []interface{}{},
+			"gridPos":   map[string]int{"x": 0, "y": y, "w": dashboardGridWidth, "h": 1},
+		})
+		id++
+		y++
+
+		// Панели раскладываются слева направо и переносятся на следующую строку, когда не помещаются по ширине
+		x, lineHeight := 0, 0
+
+		for _, p := range rowPanels {
+			if x+p.width > dashboardGridWidth {
+				x, y, lineHeight = 0, y+lineHeight, 0
+			}
+
+			panels = append(panels, p.build(id, x, y))
+			id++
+
+			x += p.width
+			if p.height > lineHeight {
+				lineHeight = p.height
+			}
+		}
+
+		y += lineHeight
+	}
+
+	dashboard := map[string]interface{}{
+		"uid":           cfg.UID,
+		"title":         cfg.Title,
+		"description":   "Сформирован командой myheat-exporter dashboard",
+		"tags":          []string{"myheat"},
+		"editable":      true,
+		"graphTooltip":  1,
+		"schemaVersion": dashboardSchemaVersion,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"timezone":      "browser",
+		"panels":        panels,
+		"templating":    map[string]interface{}{"list": dashboardVariables(cfg)},
+		"annotations":   map[string]interface{}{"list": []interface{}{}},
+	}
+
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+func hasMetrics(registered map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !registered[name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p dashboardPanel) build(id, x, y int) map[string]interface{} {
+	targets := make([]map[string]interface{}, 0, len(p.targets))
+
+	for i, t := range p.targets {
+		targets = append(targets, map[string]interface{}{
+			"refId":        string(rune('A' + i)),
+			"expr":         t.expr,
+			"legendFormat": t.legend,
+			"datasource":   dashboardDatasource(),
+		})
+	}
+
+	options := p.options
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+
+	panel := map[string]interface{}{
+		"id":         id,
+		"type":       p.typ,
+		"title":      p.title,
+		"datasource": dashboardDatasource(),
+		"gridPos":    map[string]int{"x": x, "y": y, "w": p.width, "h": p.height},
+		"targets":    targets,
+		"options":    options,
+		"fieldConfig": map[string]interface{}{
+			"defaults":  map[string]interface{}{"unit": p.unit},
+			"overrides": []interface{}{},
+		},
+	}
+
+	if p.description != "" {
+		panel["description"] = p.description
+	}
+
+	return panel
+}
+
+func dashboardDatasource() map[string]string {
+	return map[string]string{"type": "prometheus", "uid": "${" + dashboardDatasourceName + "}"}
+}
+
+func dashboardEnvRow() dashboardRow {
+	return dashboardRow{
+		title: "Помещения",
+		panels: []dashboardPanel{
+			{
+				typ:     "stat",
+				title:   "Температура",
+				unit:    "celsius",
+				width:   8,
+				height:  6,
+				metrics: []string{metricNameEnvTempCurrent},
+				targets: []dashboardTarget{{expr: metricNameEnvTempCurrent, legend: "{{name}}"}},
+			},
+			{
+				typ:     "state-timeline",
+				title:   "Нагрев",
+				width:   16,
+				height:  6,
+				metrics: []string{metricNameEnvHeatDemand},
+				targets: []dashboardTarget{{expr: metricNameEnvHeatDemand, legend: "{{name}}"}},
+			},
+			{
+				typ:     "timeseries",
+				title:   "Температура и цель",
+				unit:    "celsius",
+				width:   24,
+				height:  8,
+				metrics: []string{metricNameEnvTempCurrent, metricNameEnvTempTarget},
+				targets: []dashboardTarget{
+					{expr: metricNameEnvTempCurrent, legend: "{{name}}"},
+					{expr: metricNameEnvTempTarget, legend: "{{name}}: цель"},
+				},
+			},
+		},
+	}
+}
+
+func dashboardHeaterRow() dashboardRow {
+	selector := `{device_id=~"$device"}`
+
+	return dashboardRow{
+		title: "Котел",
+		panels: []dashboardPanel{
+			{
+				typ:         "stat",
+				title:       "Время нагрева за период",
+				description: "Время, в течение которого помещения запрашивали нагрев",
+				unit:        "s",
+				width:       8,
+				height:      6,
+				metrics:     []string{metricNameEnvHeatDemandSeconds},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("increase(%s[$__range])", metricNameEnvHeatDemandSeconds), legend: "{{name}}"},
+				},
+			},
+			{
+				typ:         "timeseries",
+				title:       "Доля времени нагрева",
+				description: "Доля времени за час, в течение которого помещения запрашивали нагрев",
+				unit:        "percentunit",
+				width:       16,
+				height:      6,
+				metrics:     []string{metricNameEnvHeatDemandSeconds},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("rate(%s[1h])", metricNameEnvHeatDemandSeconds), legend: "{{name}}"},
+				},
+			},
+			{
+				typ:         "stat",
+				title:       "Включения за период",
+				description: "burner - включения горелки котла, demand - запросы нагрева помещений",
+				unit:        "short",
+				width:       8,
+				height:      6,
+				metrics:     []string{metricNameHeaterStarts},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("increase(%s%s[$__range])", metricNameHeaterStarts, selector), legend: "{{name}} ({{source}})"},
+				},
+			},
+			{
+				typ:         "timeseries",
+				title:       "Коэффициент загрузки",
+				description: "Доля времени работы за скользящее окно window",
+				unit:        "percentunit",
+				width:       16,
+				height:      6,
+				metrics:     []string{metricNameHeaterDutyCycle},
+				targets: []dashboardTarget{
+					{expr: metricNameHeaterDutyCycle + selector, legend: "{{name}} ({{source}}, {{window}})"},
+				},
+			},
+			{
+				typ:         "timeseries",
+				title:       "Средняя длительность циклов",
+				description: "Короткие периоды работы и простоя говорят о слишком малом гистерезисе",
+				unit:        "s",
+				width:       24,
+				height:      6,
+				metrics:     []string{metricNameHeaterOnPeriod, metricNameHeaterOffPeriod},
+				targets: []dashboardTarget{
+					{
+						expr:   fmt.Sprintf("rate(%[1]s_sum%[2]s[1h]) / rate(%[1]s_count%[2]s[1h])", metricNameHeaterOnPeriod, selector),
+						legend: "{{name}} ({{source}}): работа",
+					},
+					{
+						expr:   fmt.Sprintf("rate(%[1]s_sum%[2]s[1h]) / rate(%[1]s_count%[2]s[1h])", metricNameHeaterOffPeriod, selector),
+						legend: "{{name}} ({{source}}): простой",
+					},
+				},
+			},
+		},
+	}
+}
+
+func dashboardEnergyRow(cfg DashboardConfig) dashboardRow {
+	// Котел один на все помещения, поэтому из счетчиков помещений берется максимальный
+	energyExpr := func(tariff TariffType, rng string) string {
+		return fmt.Sprintf(`max(increase(%s{tariff="%s"}[%s])) / 3600 * $heater_kwt`, metricNameEnvHeatTariffSeconds, tariff, rng)
+	}
+
+	energyTargets := make([]dashboardTarget, 0, len(cfg.Tariffs))
+	costTargets := make([]dashboardTarget, 0, len(cfg.Tariffs))
+
+	for _, tariff := range cfg.Tariffs {
+		legend := "Тариф " + tariff.String()
+
+		energyTargets = append(energyTargets, dashboardTarget{expr: energyExpr(tariff, "$__range"), legend: legend})
+		costTargets = append(costTargets, dashboardTarget{
+			expr:   fmt.Sprintf("%s * $electricity_tariff_%s", energyExpr(tariff, "$__range"), tariff),
+			legend: legend,
+		})
+	}
+
+	description := "Оценка по времени нагрева и мощности котла. Если нагрев запрашивают несколько помещений, учитывается максимальное время"
+	deviceSelector := `{id=~"$device"}`
+
+	return dashboardRow{
+		title: "Энергия и стоимость",
+		panels: []dashboardPanel{
+			{
+				typ:         "stat",
+				title:       "Энергия за период",
+				description: description,
+				unit:        "kwatth",
+				width:       8,
+				height:      5,
+				metrics:     []string{metricNameEnvHeatTariffSeconds},
+				targets:     energyTargets,
+			},
+			{
+				typ:         "stat",
+				title:       "Стоимость за период",
+				description: description,
+				unit:        "currencyRUB",
+				width:       8,
+				height:      5,
+				metrics:     []string{metricNameEnvHeatTariffSeconds},
+				targets:     costTargets,
+			},
+			{
+				typ:         "bargauge",
+				title:       "Доля тарифов",
+				description: "Время нагрева за период по тарифам",
+				unit:        "s",
+				width:       8,
+				height:      5,
+				metrics:     []string{metricNameEnvHeatTariffSeconds},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("max by (tariff) (increase(%s[$__range]))", metricNameEnvHeatTariffSeconds), legend: "Тариф {{tariff}}"},
+				},
+			},
+			{
+				typ:         "stat",
+				title:       "Градусо-сутки за период",
+				description: "Отопительные градусо-сутки по уличной температуре устройства",
+				unit:        "short",
+				width:       8,
+				height:      5,
+				metrics:     []string{metricNameHeatingDegreeDays},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("increase(%s%s[$__range])", metricNameHeatingDegreeDays, deviceSelector), legend: "{{name}}"},
+				},
+			},
+			{
+				typ:         "timeseries",
+				title:       "Энергия на градусо-сутки",
+				description: "Энергия на нагрев, деленная на градусо-сутки, за последние сутки. Позволяет сравнивать потребление при разной погоде",
+				unit:        "kwatth",
+				width:       16,
+				height:      5,
+				metrics:     []string{metricNameHeatingEnergy, metricNameHeatingDegreeDays},
+				targets: []dashboardTarget{
+					{
+						expr:   fmt.Sprintf("increase(%s%s[1d]) / increase(%s%s[1d])", metricNameHeatingEnergy, deviceSelector, metricNameHeatingDegreeDays, deviceSelector),
+						legend: "{{name}}",
+					},
+				},
+			},
+		},
+	}
+}
+
+func dashboardDeviceRow() dashboardRow {
+	selector := `{id=~"$device"}`
+
+	return dashboardRow{
+		title: "Устройства",
+		panels: []dashboardPanel{
+			{
+				typ:     "timeseries",
+				title:   "Температура на улице",
+				unit:    "celsius",
+				width:   12,
+				height:  6,
+				metrics: []string{metricNameDeviceWeatherTemp},
+				targets: []dashboardTarget{{expr: metricNameDeviceWeatherTemp + selector, legend: "{{name}} ({{city}})"}},
+			},
+			{
+				typ:     "state-timeline",
+				title:   "Состояние",
+				width:   6,
+				height:  6,
+				metrics: []string{metricNameDeviceSeverity},
+				targets: []dashboardTarget{{expr: metricNameDeviceSeverity + selector, legend: "{{name}}"}},
+			},
+			{
+				typ:     "state-timeline",
+				title:   "Актуальность данных",
+				width:   6,
+				height:  6,
+				metrics: []string{metricNameDeviceDataActual},
+				targets: []dashboardTarget{{expr: metricNameDeviceDataActual + selector, legend: "{{name}}"}},
+			},
+		},
+	}
+}
+
+func dashboardExporterRow(cfg DashboardConfig) dashboardRow {
+	job := fmt.Sprintf(`{job=%q}`, cfg.Job)
+
+	return dashboardRow{
+		title: "Экспортер",
+		panels: []dashboardPanel{
+			{
+				typ:    "stat",
+				title:  "Экспортер доступен",
+				width:  6,
+				height: 4,
+				targets: []dashboardTarget{
+					{expr: "up" + job},
+				},
+			},
+			{
+				typ:     "stat",
+				title:   "С последнего успешного опроса",
+				unit:    "s",
+				width:   6,
+				height:  4,
+				metrics: []string{metricNameExporterLastSuccessfulPull},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("time() - %s%s", metricNameExporterLastSuccessfulPull, job)},
+				},
+			},
+			{
+				typ:     "timeseries",
+				title:   "Опросы MyHeat API",
+				unit:    "short",
+				width:   12,
+				height:  4,
+				metrics: []string{metricNameExporterPulls, metricNameExporterPullErrors},
+				targets: []dashboardTarget{
+					{expr: fmt.Sprintf("increase(%s%s[$__rate_interval])", metricNameExporterPulls, job), legend: "Опросы"},
+					{expr: fmt.Sprintf("increase(%s%s[$__rate_interval])", metricNameExporterPullErrors, job), legend: "Ошибки"},
+				},
+			},
+		},
+	}
+}
+
+func dashboardVariables(cfg DashboardConfig) []map[string]interface{} {
+	vars := []map[string]interface{}{
+		{
+			"name":  dashboardDatasourceName,
+			"label": "Источник данных",
+			"type":  "datasource",
+			"query": "prometheus",
+		},
+	}
+
+	device := map[string]interface{}{
+		"name":  "device",
+		"label": "Устройство",
+		// Метрики помещений не содержат идентификатор устройства, поэтому панели помещений, времени нагрева
+		// и энергии по тарифам не фильтруются
+		"description": "Фильтрует панели устройств, циклов котла и градусо-суток. Панели помещений показывают все устройства",
+		"includeAll":  true,
+		"multi":       true,
+		"current":     map[string]interface{}{"text": "All", "value": "$__all"},
+	}
+
+	if len(cfg.DeviceIDs) > 0 {
+		ids := make([]string, 0, len(cfg.DeviceIDs))
+		for _, id := range cfg.DeviceIDs {
+			ids = append(ids, strconv.FormatInt(id, 10))
+		}
+
+		device["type"] = "custom"
+		device["query"] = strings.Join(ids, ",")
+	} else {
+		device["type"] = "query"
+		device["datasource"] = dashboardDatasource()
+		// Уличной температуры может не быть, а состояние публикуется для каждого устройства
+		device["query"] = fmt.Sprintf("label_values(%s, id)", metricNameDeviceSeverity)
+		device["refresh"] = 1
+	}
+
+	vars = append(vars, device)
+
+	// Мощность котла и цены задаются константами: их можно поменять в настройках дэшборда
+	vars = append(vars, dashboardConstant("heater_kwt", "Мощность котла, кВт", cfg.HeaterPowerKW))
+
+	tariffs := make([]TariffType, len(cfg.Tariffs))
+	copy(tariffs, cfg.Tariffs)
+	sort.Slice(tariffs, func(i, j int) bool { return tariffs[i] < tariffs[j] })
+
+	for _, tariff := range tariffs {
+		vars = append(vars, dashboardConstant(
+			"electricity_tariff_"+tariff.String(),
+			"Стоимость кВт⋅ч, тариф "+tariff.String(),
+			cfg.TariffPrices[tariff],
+		))
+	}
+
+	return vars
+}
+
+func dashboardConstant(name, label string, value float64) map[string]interface{} {
+	return map[string]interface{}{
+		"name":  name,
+		"label": label,
+		"type":  "constant",
+		"hide":  2,
+		"query": formatFloat(value),
+	}
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// testDashboardConfig конфигурация, с которой сформирован grafana-dashboard.json в корне репозитория:
+//
+//	MYHEAT_TARIFF2_FROM=23 MYHEAT_TARIFF2_TO=7 myheat-exporter dashboard --heater-kw 9 --tariff-prices 1=4.61,2=2.49 --output grafana-dashboard.json
+func testDashboardConfig() DashboardConfig {
+	cfg := NewDefaultDashboardConfig()
+	cfg.HeaterPowerKW = 9
+	cfg.Tariffs = NewTariffSelector(time.Now, []Tariff{NewNightTariff(23, 7)}).TariffTypes()
+	cfg.TariffPrices = map[TariffType]float64{TariffOne: 4.61, TariffTwo: 2.49}
+
+	return cfg
+}
+
+// TestMetricNames сверяет MetricNames с метриками, которые публикуются после заполнения всех временных рядов
+func TestMetricNames(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m := NewMetrics(nopwrap.NewNopWrapper(), NewTariffSelector(time.Now, nil), LabelsConfig{}, reg)
+	m.SetEnvironmentTempCurrent(1, "Гостиная", 21)
+	m.SetEnvironmentTempTarget(1, "Гостиная", 22)
+	m.SetEnvironmentHeatDemand(1, "Гостиная", true)
+	m.AddEnvHeatDemandSeconds(1, "Гостиная", 1)
+	m.AddEnvHeatTariffSeconds(1, TariffOne, 1)
+	m.SetDeviceWeatherTemp(10, "Дом", "Москва", -5)
+	m.SetDeviceSeverity(10, "Дом", 1, "")
+	m.SetDeviceDataActual(10, "Дом", true)
+	m.ObservePull(time.Now(), nil)
+
+	degreeDaysCfg := NewDefaultDegreeDaysConfig()
+	degreeDaysCfg.HeaterPowerKW = 9
+
+	subscribers := []PullSubscriber{NewDutyCycle(NewDefaultDutyCycleConfig(), reg), NewDegreeDays(degreeDaysCfg, reg)}
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Нагрев выключается, включается и снова выключается, чтобы наблюдались периоды работы и простоя
+	for i, demand := range []bool{false, true, false, true} {
+		res := PullResult{
+			Time: t0.Add(time.Duration(i) * 10 * time.Minute),
+			Devices: []DeviceSnapshot{{
+				Device: domain.Device{ID: 10, Name: "Дом"},
+				Info: domain.DeviceInfo{
+					DataActual:  true,
+					WeatherTemp: floatPtr(-5),
+					Envs:        []domain.Env{{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature, Demand: demand}},
+					Heaters:     []domain.Heater{{ID: 5, Name: "Котел", BurnerHeating: demand}},
+				},
+			}},
+		}
+
+		for _, s := range subscribers {
+			s.HandlePull(context.Background(), res)
+		}
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	gathered := make(map[string]bool, len(families))
+	for _, f := range families {
+		gathered[f.GetName()] = true
+	}
+
+	if names := MetricNames(); !reflect.DeepEqual(names, gathered) {
+		t.Errorf("MetricNames() = %v, gathered %v", names, gathered)
+	}
+}
+
+func TestGenerateDashboard_upToDate(t *testing.T) {
+	data, err := GenerateDashboard(testDashboardConfig(), MetricNames())
+	if err != nil {
+		t.Fatalf("GenerateDashboard() error = %v", err)
+	}
+
+	committed, err := os.ReadFile("../../grafana-dashboard.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(append(data, '\n'), committed) {
+		t.Errorf("grafana-dashboard.json is out of date, regenerate it with the command from testDashboardConfig")
+	}
+}
+
+func TestGenerateDashboard_skipsUnregisteredMetrics(t *testing.T) {
+	cfg := testDashboardConfig()
+	cfg.DeviceIDs = []int64{10, 20}
+
+	data, err := GenerateDashboard(cfg, map[string]bool{metricNameDeviceWeatherTemp: true})
+	if err != nil {
+		t.Fatalf("GenerateDashboard() error = %v", err)
+	}
+
+	dashboard := struct {
+		Panels []struct {
+			Type  string `json:"type"`
+			Title string `json:"title"`
+		} `json:"panels"`
+		Templating struct {
+			List []map[string]interface{} `json:"list"`
+		} `json:"templating"`
+	}{}
+
+	if err = json.Unmarshal(data, &dashboard); err != nil {
+		t.Fatalf("dashboard is not valid json: %v", err)
+	}
+
+	titles := make([]string, 0)
+	for _, p := range dashboard.Panels {
+		titles = append(titles, p.Title)
+	}
+
+	// Остаются только панель погоды и панель доступности экспортера, которая не зависит от метрик экспортера
+	want := []string{"Устройства", "Температура на улице", "Экспортер", "Экспортер доступен"}
+	if len(titles) != len(want) {
+		t.Fatalf("panels = %v, want %v", titles, want)
+	}
+
+	for i := range want {
+		if titles[i] != want[i] {
+			t.Errorf("panel %d = %s, want %s", i, titles[i], want[i])
+		}
+	}
+
+	device := dashboard.Templating.List[1]
+	if device["type"] != "custom" || device["query"] != "10,20" {
+		t.Errorf("device variable = %v", device)
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricNameHeatingDegreeDays  = "myheat_heating_degree_days_total"
+	metricNameHeatingEnergy      = "myheat_heating_energy_kwh_total"
+	metricNameEnergyPerDegreeDay = "myheat_energy_per_degree_day_kwh"
+)
+
+// degreeDaysMetricNames метрики, которые регистрирует NewDegreeDays
+var degreeDaysMetricNames = []string{
+	metricNameHeatingDegreeDays,
+	metricNameHeatingEnergy,
+	metricNameEnergyPerDegreeDay,
+}
+
+const (
+	defaultDegreeDaysBaseTemp = 18
+	defaultDegreeDaysMaxGap   = time.Hour
+)
+
+func NewDefaultDegreeDaysConfig() DegreeDaysConfig {
+	return DegreeDaysConfig{
+		BaseTemp: defaultDegreeDaysBaseTemp,
+		MaxGap:   defaultDegreeDaysMaxGap,
+	}
+}
+
+type DegreeDaysConfig struct {
+	// BaseTemp базовая температура, °C. Градусо-сутки набираются, пока на улице холоднее
+	BaseTemp float64
+	// HeaterPowerKW мощность котла. Если не задана, энергия не считается
+	HeaterPowerKW float64
+	// MaxGap если между опросами прошло больше, интервал не учитывается, например, после перерыва в работе экспортера
+	MaxGap time.Duration
+	// Labels алиасы и дополнительные лейблы устройств
+	Labels LabelsConfig
+}
+
+func (c DegreeDaysConfig) Validate() error {
+	if c.HeaterPowerKW < 0 {
+		return errors.New("heater power cannot be negative")
+	}
+
+	if c.MaxGap <= 0 {
+		return errors.New("max gap must be positive number")
+	}
+
+	return nil
+}
+
+// NewDegreeDays регистрирует метрики градусо-суток в reg
+func NewDegreeDays(cfg DegreeDaysConfig, reg prometheus.Registerer) *DegreeDays {
+	factory := promauto.With(reg)
+
+	devLabels := newLabelMapper(cfg.Labels.NameFormat, cfg.Labels.Devices)
+
+	return &DegreeDays{
+		cfg:       cfg,
+		devLabels: devLabels,
+		states:    make(map[int64]*degreeDaysState),
+
+		degreeDaysMetric: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: metricNameHeatingDegreeDays,
+			Help: "Отопительные градусо-сутки по уличной температуре устройства",
+		}, devLabels.labelNames("id", "name")),
+		energyMetric: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: metricNameHeatingEnergy,
+			Help: "Энергия на нагрев, оценка по времени запроса нагрева и мощности котла",
+		}, devLabels.labelNames("id", "name")),
+		energyPerDegreeDayMetric: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricNameEnergyPerDegreeDay,
+			Help: "Энергия на нагрев в расчете на одни градусо-сутки с момента запуска экспортера",
+		}, devLabels.labelNames("id", "name")),
+	}
+}
+
+// DegreeDays считает отопительные градусо-сутки: сумму (BaseTemp - уличная температура) по времени, пока на улице
+// холоднее BaseTemp. Уличная температура между опросами считается постоянной. Энергия оценивается по времени,
+// когда нагрев запрашивало хотя бы одно помещение устройства, и мощности котла. Энергию на градусо-сутки за любой
+// период, в том числе после перезапуска, дает increase(энергия) / increase(градусо-сутки), gauge - только с запуска
+type DegreeDays struct {
+	cfg       DegreeDaysConfig
+	devLabels labelMapper
+
+	degreeDaysMetric         *prometheus.CounterVec
+	energyMetric             *prometheus.CounterVec
+	energyPerDegreeDayMetric *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	states map[int64]*degreeDaysState
+}
+
+type degreeDaysState struct {
+	labels prometheus.Labels
+	time   time.Time
+	// weatherTemp уличная температура последнего опроса или nil, если она неизвестна или данные неактуальны
+	weatherTemp *float64
+	// heating нагрев запрашивался в последнем опросе с актуальными данными
+	heating bool
+
+	degreeDays     float64
+	heatingSeconds float64
+}
+
+func (d *DegreeDays) HandlePull(_ context.Context, res PullResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dev := range res.Devices {
+		d.observe(dev, res.Time)
+	}
+}
+
+func (d *DegreeDays) observe(dev DeviceSnapshot, t time.Time) {
+	s, ok := d.states[dev.Device.ID]
+	if !ok {
+		s = &degreeDaysState{labels: d.devLabels.labels(dev.Device.ID, dev.Device.Name)}
+		d.states[dev.Device.ID] = s
+
+		// Счетчики публикуются с нуля, чтобы increase учитывал первый интервал
+		d.degreeDaysMetric.With(s.labels)
+
+		if d.cfg.HeaterPowerKW > 0 {
+			d.energyMetric.With(s.labels)
+		}
+	}
+
+	// Интервал с прошлого опроса учитывается по его показаниям. Для градусо-суток нужна уличная температура,
+	// энергия считается и без нее
+	if gap := t.Sub(s.time); ok && gap > 0 && gap <= d.cfg.MaxGap {
+		if s.weatherTemp != nil {
+			if diff := d.cfg.BaseTemp - *s.weatherTemp; diff > 0 {
+				dd := diff * gap.Hours() / 24
+
+				s.degreeDays += dd
+				d.degreeDaysMetric.With(s.labels).Add(dd)
+			}
+		}
+
+		if s.heating {
+			s.heatingSeconds += gap.Seconds()
+
+			if d.cfg.HeaterPowerKW > 0 {
+				d.energyMetric.With(s.labels).Add(gap.Hours() * d.cfg.HeaterPowerKW)
+			}
+		}
+	}
+
+	s.time = t
+	s.weatherTemp = nil
+	s.heating = false
+
+	if dev.Info.DataActual {
+		if dev.Info.WeatherTemp != nil {
+			weatherTemp := *dev.Info.WeatherTemp
+			s.weatherTemp = &weatherTemp
+		}
+
+		for _, env := range dev.Info.Envs {
+			if env.Type == domain.EnvTypeRoomTemperature && env.Demand {
+				s.heating = true
+				break
+			}
+		}
+	}
+
+	if d.cfg.HeaterPowerKW > 0 && s.degreeDays > 0 {
+		energy := s.heatingSeconds / 3600 * d.cfg.HeaterPowerKW
+		d.energyPerDegreeDayMetric.With(s.labels).Set(energy / s.degreeDays)
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDegreeDays_HandlePull(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	cfg := NewDefaultDegreeDaysConfig()
+	cfg.HeaterPowerKW = 9
+	cfg.MaxGap = 4 * time.Hour
+
+	d := NewDegreeDays(cfg, reg)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pull := func(offset time.Duration, weatherTemp *float64, demand, actual bool) {
+		d.HandlePull(context.Background(), PullResult{
+			Time: t0.Add(offset),
+			Devices: []DeviceSnapshot{{
+				Device: domain.Device{ID: 10, Name: "Дом"},
+				Info: domain.DeviceInfo{
+					DataActual:  actual,
+					WeatherTemp: weatherTemp,
+					Envs: []domain.Env{
+						{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature, Demand: demand},
+						{ID: 2, Name: "Бойлер", Type: "boiler_temperature", Demand: true},
+					},
+				},
+			}},
+		})
+	}
+
+	// 6 часов при -6 °C: (18 - -6) * 6 / 24 = 6 градусо-суток, из них 3 часа нагрева
+	pull(0, floatPtr(-6), true, true)
+	pull(3*time.Hour, floatPtr(-6), false, true)
+	// Теплее базовой температуры градусо-сутки не набираются
+	pull(6*time.Hour, floatPtr(20), true, true)
+	// Пока данные неактуальны, интервалы не учитываются. Без уличной температуры считается только энергия
+	pull(7*time.Hour, floatPtr(-30), true, false)
+	pull(8*time.Hour, nil, true, true)
+	pull(9*time.Hour, floatPtr(-6), false, true)
+	// Перерыв длиннее MaxGap не учитывается
+	pull(20*time.Hour, floatPtr(-6), false, true)
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_heating_degree_days_total{id="10",name="Дом"} 6`,
+		// 5 часов нагрева, в том числе при 20 °C и неизвестной температуре, при 9 кВт - 45 кВт⋅ч на 6 градусо-суток
+		`myheat_heating_energy_kwh_total{id="10",name="Дом"} 45`,
+		`myheat_energy_per_degree_day_kwh{id="10",name="Дом"} 7.5`,
+	}, nil)
+}
+
+func TestDegreeDays_WithoutHeaterPower(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	d := NewDegreeDays(NewDefaultDegreeDaysConfig(), reg)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		d.HandlePull(context.Background(), PullResult{
+			Time: t0.Add(time.Duration(i) * 30 * time.Minute),
+			Devices: []DeviceSnapshot{{
+				Device: domain.Device{ID: 10, Name: "Дом"},
+				Info:   domain.DeviceInfo{DataActual: true, WeatherTemp: floatPtr(6)},
+			}},
+		})
+	}
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_heating_degree_days_total{id="10",name="Дом"} 0.25`,
+	}, []string{
+		metricNameHeatingEnergy,
+		metricNameEnergyPerDegreeDay,
+	})
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	metricNameHeaterStarts    = "myheat_heater_starts_total"
+	metricNameHeaterOnPeriod  = "myheat_heater_on_period_seconds"
+	metricNameHeaterOffPeriod = "myheat_heater_off_period_seconds"
+	metricNameHeaterDutyCycle = "myheat_heater_duty_cycle"
+)
+
+// dutyCycleMetricNames метрики, которые регистрирует NewDutyCycle
+var dutyCycleMetricNames = []string{
+	metricNameHeaterStarts,
+	metricNameHeaterOnPeriod,
+	metricNameHeaterOffPeriod,
+	metricNameHeaterDutyCycle,
+}
+
+const (
+	// dutyCycleSourceBurner циклы горелки котла (BurnerHeating)
+	dutyCycleSourceBurner = "burner"
+	// dutyCycleSourceDemand циклы запроса нагрева помещения, тот же признак, по которому считается
+	// myheat_env_heat_demand_seconds_total
+	dutyCycleSourceDemand = "demand"
+)
+
+// dutyCyclePeriodBuckets границы гистограмм длительности периодов работы и простоя, с
+var dutyCyclePeriodBuckets = []float64{30, 60, 120, 300, 600, 1200, 1800, 3600, 7200, 14400}
+
+func NewDefaultDutyCycleConfig() DutyCycleConfig {
+	return DutyCycleConfig{
+		Windows: []time.Duration{time.Hour, 24 * time.Hour},
+	}
+}
+
+type DutyCycleConfig struct {
+	// Windows окна, за которые считается доля времени работы
+	Windows []time.Duration
+	// Labels формат имен и алиасы помещений. Дополнительные лейблы к метрикам циклов не добавляются
+	Labels LabelsConfig
+}
+
+func (c DutyCycleConfig) Validate() error {
+	if len(c.Windows) == 0 {
+		return errors.New("windows cannot be empty")
+	}
+
+	seen := make(map[time.Duration]bool, len(c.Windows))
+
+	for _, w := range c.Windows {
+		if w <= 0 {
+			return errors.New("window must be positive duration")
+		}
+
+		if seen[w] {
+			return errors.New("windows must be unique")
+		}
+
+		seen[w] = true
+	}
+
+	return nil
+}
+
+// NewDutyCycle регистрирует метрики циклов в reg
+func NewDutyCycle(cfg DutyCycleConfig, reg prometheus.Registerer) *DutyCycle {
+	factory := promauto.With(reg)
+
+	labels := []string{"device_id", "id", "name", "source"}
+
+	d := &DutyCycle{
+		cfg:       cfg,
+		envLabels: newLabelMapper(cfg.Labels.NameFormat, cfg.Labels.Envs),
+		cycles:    make(map[dutyCycleKey]*dutyCycleState),
+
+		startsMetric: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: metricNameHeaterStarts,
+			Help: "Количество включений котла или запроса нагрева помещения",
+		}, labels),
+		onPeriodMetric: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    metricNameHeaterOnPeriod,
+			Help:    "Длительность периодов работы",
+			Buckets: dutyCyclePeriodBuckets,
+		}, labels),
+		offPeriodMetric: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    metricNameHeaterOffPeriod,
+			Help:    "Длительность периодов простоя",
+			Buckets: dutyCyclePeriodBuckets,
+		}, labels),
+		dutyCycleMetric: factory.NewGaugeVec(prometheus.GaugeOpts{
+			Name: metricNameHeaterDutyCycle,
+			Help: "Доля времени работы за окно window",
+		}, append(labels, "window")),
+	}
+
+	for _, w := range cfg.Windows {
+		if w > d.maxWindow {
+			d.maxWindow = w
+		}
+	}
+
+	return d
+}
+
+// DutyCycle считает включения, длительность периодов работы и простоя и долю времени работы горелок котлов
+// и запросов нагрева помещений по результатам опросов. Частые короткие циклы говорят о слишком малом гистерезисе.
+// Состояние определяется только в моменты опроса, поэтому точность длительностей равна интервалу опроса
+type DutyCycle struct {
+	cfg       DutyCycleConfig
+	envLabels labelMapper
+	maxWindow time.Duration
+
+	startsMetric    *prometheus.CounterVec
+	onPeriodMetric  *prometheus.HistogramVec
+	offPeriodMetric *prometheus.HistogramVec
+	dutyCycleMetric *prometheus.GaugeVec
+
+	mu     sync.Mutex
+	cycles map[dutyCycleKey]*dutyCycleState
+}
+
+type dutyCycleKey struct {
+	source   string
+	deviceID int64
+	id       int64
+}
+
+type dutyCycleState struct {
+	labels prometheus.Labels
+	on     bool
+	// since начало текущего периода. Нулевое, если период начался до первого опроса и его длительность неизвестна
+	since time.Time
+	// changes переключения за последнее окно. Первый элемент - состояние на начало окна или на момент первого опроса
+	changes []dutyCycleChange
+}
+
+type dutyCycleChange struct {
+	time time.Time
+	on   bool
+}
+
+func (d *DutyCycle) HandlePull(_ context.Context, res PullResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, dev := range res.Devices {
+		actual := dev.Info.DataActual
+
+		for _, h := range dev.Info.Heaters {
+			key := dutyCycleKey{source: dutyCycleSourceBurner, deviceID: dev.Device.ID, id: h.ID}
+
+			if !actual {
+				d.freeze(key)
+				continue
+			}
+
+			d.observe(key, formatName(d.cfg.Labels.NameFormat, h.Name), h.BurnerHeating, res.Time)
+		}
+
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			key := dutyCycleKey{source: dutyCycleSourceDemand, deviceID: dev.Device.ID, id: env.ID}
+
+			if !actual {
+				d.freeze(key)
+				continue
+			}
+
+			d.observe(key, d.envLabels.labels(env.ID, env.Name)["name"], env.Demand, res.Time)
+		}
+	}
+}
+
+// freeze вызывается, пока данные устройства неактуальны. Настоящее состояние неизвестно, поэтому переключение
+// не засчитывается, а длительность текущего периода после восстановления данных считается неизвестной
+func (d *DutyCycle) freeze(key dutyCycleKey) {
+	if c, ok := d.cycles[key]; ok {
+		c.since = time.Time{}
+	}
+}
+
+func (d *DutyCycle) observe(key dutyCycleKey, name string, on bool, t time.Time) {
+	c, ok := d.cycles[key]
+
+	switch {
+	case !ok:
+		c = &dutyCycleState{
+			labels: prometheus.Labels{
+				"device_id": strconv.FormatInt(key.deviceID, 10),
+				"id":        strconv.FormatInt(key.id, 10),
+				"name":      name,
+				"source":    key.source,
+			},
+			on:      on,
+			changes: []dutyCycleChange{{time: t, on: on}},
+		}
+		d.cycles[key] = c
+
+		// Счетчик публикуется с нуля, чтобы increase учитывал первое включение
+		d.startsMetric.With(c.labels)
+
+	case c.on != on:
+		if !c.since.IsZero() {
+			period := d.offPeriodMetric
+			if c.on {
+				period = d.onPeriodMetric
+			}
+
+			period.With(c.labels).Observe(t.Sub(c.since).Seconds())
+		}
+
+		if on {
+			d.startsMetric.With(c.labels).Inc()
+		}
+
+		c.on = on
+		c.since = t
+		c.changes = append(c.changes, dutyCycleChange{time: t, on: on})
+	}
+
+	c.prune(t.Add(-d.maxWindow))
+
+	for _, w := range d.cfg.Windows {
+		value, ok := c.dutyCycle(t, w)
+		if !ok {
+			continue
+		}
+
+		labels := copyLabels(c.labels)
+		labels["window"] = model.Duration(w).String()
+
+		d.dutyCycleMetric.With(labels).Set(value)
+	}
+}
+
+// prune удаляет переключения, которые произошли раньше from, сохраняя состояние на момент from
+func (c *dutyCycleState) prune(from time.Time) {
+	i := 0
+	for i+1 < len(c.changes) && !c.changes[i+1].time.After(from) {
+		i++
+	}
+
+	c.changes = c.changes[i:]
+
+	if c.changes[0].time.Before(from) {
+		c.changes[0].time = from
+	}
+}
+
+// dutyCycle доля времени работы за окно window, заканчивающееся в now. Если опросы начались позже начала окна,
+// доля считается от времени наблюдения. ok равен false, пока время наблюдения нулевое
+func (c *dutyCycleState) dutyCycle(now time.Time, window time.Duration) (value float64, ok bool) {
+	from := now.Add(-window)
+
+	var on, total time.Duration
+
+	for i, ch := range c.changes {
+		start, end := ch.time, now
+		if i+1 < len(c.changes) {
+			end = c.changes[i+1].time
+		}
+
+		if start.Before(from) {
+			start = from
+		}
+
+		if !end.After(start) {
+			continue
+		}
+
+		total += end.Sub(start)
+
+		if ch.on {
+			on += end.Sub(start)
+		}
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	return on.Seconds() / total.Seconds(), true
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDutyCycleConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []time.Duration
+		wantErr bool
+	}{
+		{name: "по умолчанию", windows: NewDefaultDutyCycleConfig().Windows},
+		{name: "без окон", windows: nil, wantErr: true},
+		{name: "нулевое окно", windows: []time.Duration{0}, wantErr: true},
+		{name: "повторяющиеся окна", windows: []time.Duration{time.Hour, time.Hour}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := DutyCycleConfig{Windows: tt.windows}.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDutyCycle_HandlePull(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	d := NewDutyCycle(NewDefaultDutyCycleConfig(), reg)
+
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	pull := func(offset time.Duration, burner, demand, actual bool) {
+		d.HandlePull(context.Background(), PullResult{
+			Time: t0.Add(offset),
+			Devices: []DeviceSnapshot{{
+				Device: domain.Device{ID: 10, Name: "Дом"},
+				Info: domain.DeviceInfo{
+					DataActual: actual,
+					Envs: []domain.Env{
+						{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature, Demand: demand},
+						{ID: 2, Name: "Бойлер", Type: "boiler_temperature", Demand: true},
+					},
+					Heaters: []domain.Heater{{ID: 5, Name: "Котел", BurnerHeating: burner}},
+				},
+			}},
+		})
+	}
+
+	// Длительность первого периода неизвестна, он начался до первого опроса
+	pull(0, false, true, true)
+	pull(10*time.Minute, true, true, true)
+	pull(20*time.Minute, false, true, true)
+	pull(30*time.Minute, true, true, true)
+	// Пока данные неактуальны, состояние не меняется, даже если в них котел и запрос нагрева выключены
+	pull(50*time.Minute, false, false, false)
+	pull(60*time.Minute, true, true, true)
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_heater_starts_total{device_id="10",id="5",name="Котел",source="burner"} 2`,
+		`myheat_heater_on_period_seconds_count{device_id="10",id="5",name="Котел",source="burner"} 1`,
+		`myheat_heater_on_period_seconds_sum{device_id="10",id="5",name="Котел",source="burner"} 600`,
+		`myheat_heater_off_period_seconds_count{device_id="10",id="5",name="Котел",source="burner"} 1`,
+		`myheat_heater_off_period_seconds_sum{device_id="10",id="5",name="Котел",source="burner"} 600`,
+		// Котел работал с 10 по 20 и с 30 по 60 минуту
+		`myheat_heater_duty_cycle{device_id="10",id="5",name="Котел",source="burner",window="1h"} 0.6666666666666666`,
+		`myheat_heater_duty_cycle{device_id="10",id="5",name="Котел",source="burner",window="1d"} 0.6666666666666666`,
+		`myheat_heater_starts_total{device_id="10",id="1",name="Гостиная",source="demand"} 0`,
+		`myheat_heater_duty_cycle{device_id="10",id="1",name="Гостиная",source="demand",window="1h"} 1`,
+	}, []string{
+		`myheat_heater_on_period_seconds_count{device_id="10",id="1"`,
+		`myheat_heater_off_period_seconds_count{device_id="10",id="1"`,
+		`id="2"`,
+	})
+
+	// Период, во время которого данные были неактуальны, не учитывается: его начало неизвестно
+	pull(70*time.Minute, true, false, true)
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_heater_starts_total{device_id="10",id="1",name="Гостиная",source="demand"} 0`,
+	}, []string{
+		`myheat_heater_on_period_seconds_count{device_id="10",id="1"`,
+	})
+
+	// Переключения старше самого длинного окна удаляются
+	pull(25*time.Hour, true, true, true)
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_heater_duty_cycle{device_id="10",id="5",name="Котел",source="burner",window="1h"} 1`,
+		`myheat_heater_duty_cycle{device_id="10",id="5",name="Котел",source="burner",window="1d"} 1`,
+	}, nil)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if n := len(d.cycles[dutyCycleKey{source: dutyCycleSourceBurner, deviceID: 10, id: 5}].changes); n != 1 {
+		t.Errorf("changes = %d, want 1", n)
+	}
+}
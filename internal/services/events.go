@@ -0,0 +1,228 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+)
+
+type EventType string
+
+const (
+	EventEnvDemandChanged      EventType = "env_demand_changed"
+	EventEnvTargetChanged      EventType = "env_target_changed"
+	EventDeviceSeverityChanged EventType = "device_severity_changed"
+	EventDeviceAlarm           EventType = "device_alarm"
+	EventDeviceOffline         EventType = "device_offline"
+	EventDeviceOnline          EventType = "device_online"
+)
+
+// Event изменение состояния, обнаруженное при очередном опросе MyHeat API
+type Event struct {
+	Type       EventType   `json:"type"`
+	Time       time.Time   `json:"time"`
+	DeviceID   int64       `json:"deviceId"`
+	DeviceName string      `json:"deviceName"`
+	EnvID      int64       `json:"envId,omitempty"`
+	EnvName    string      `json:"envName,omitempty"`
+	Old        interface{} `json:"old,omitempty"`
+	New        interface{} `json:"new,omitempty"`
+}
+
+const defaultEventSubscriberBuffer = 64
+
+func NewEventStream(l wdlogger.Logger) *EventStream {
+	return &EventStream{
+		logger:      l,
+		devices:     make(map[int64]trackedDevice),
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// EventStream сравнивает результат каждого опроса с предыдущим и рассылает обнаруженные изменения подписчикам
+type EventStream struct {
+	logger wdlogger.Logger
+
+	// devices последние данные и доступность каждого известного устройства. Используется только в HandlePull
+	devices map[int64]trackedDevice
+
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+type trackedDevice struct {
+	snapshot DeviceSnapshot
+	online   bool
+}
+
+// Run закрывает каналы подписчиков при завершении работы, чтобы долгие HTTP-соединения не мешали остановке сервера
+func (s *EventStream) Run(ctx context.Context) {
+	<-ctx.Done()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+}
+
+// Subscribe возвращает канал событий и функцию отписки. Канал закрывается при отписке или завершении работы.
+// Если подписчик не успевает читать события, новые события для него отбрасываются
+func (s *EventStream) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, defaultEventSubscriberBuffer)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.subscribers[ch]; ok {
+			close(ch)
+			delete(s.subscribers, ch)
+		}
+	}
+}
+
+func (s *EventStream) HandlePull(_ context.Context, res PullResult) {
+	for _, e := range s.detect(res) {
+		s.publish(e)
+	}
+}
+
+func (s *EventStream) publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			s.logger.Warn("event subscriber is too slow, dropping event", wdlogger.NewStringField("type", string(e.Type)))
+		}
+	}
+}
+
+// detect находит изменения относительно предыдущего опроса. Для устройств, которые встретились впервые,
+// события не формируются
+func (s *EventStream) detect(res PullResult) []Event {
+	events := make([]Event, 0)
+	seen := make(map[int64]bool, len(res.Devices))
+
+	for _, dev := range res.Devices {
+		seen[dev.Device.ID] = true
+
+		prev, ok := s.devices[dev.Device.ID]
+		online := dev.Info.DataActual
+
+		if ok {
+			events = append(events, diffDevice(res.Time, prev, dev, online)...)
+		}
+
+		s.devices[dev.Device.ID] = trackedDevice{snapshot: dev, online: online}
+	}
+
+	for id, prev := range s.devices {
+		if seen[id] || !prev.online {
+			continue
+		}
+
+		prev.online = false
+		s.devices[id] = prev
+
+		events = append(events, Event{
+			Type:       EventDeviceOffline,
+			Time:       res.Time,
+			DeviceID:   id,
+			DeviceName: prev.snapshot.Device.Name,
+		})
+	}
+
+	return events
+}
+
+func diffDevice(t time.Time, prev trackedDevice, dev DeviceSnapshot, online bool) []Event {
+	events := make([]Event, 0)
+
+	newEvent := func(typ EventType, oldValue, newValue interface{}) Event {
+		return Event{Type: typ, Time: t, DeviceID: dev.Device.ID, DeviceName: dev.Device.Name, Old: oldValue, New: newValue}
+	}
+
+	if prev.online != online {
+		typ := EventDeviceOnline
+		if !online {
+			typ = EventDeviceOffline
+		}
+
+		events = append(events, newEvent(typ, nil, nil))
+	}
+
+	if prevSeverity := prev.snapshot.Info.Severity; prevSeverity != dev.Info.Severity {
+		events = append(events, newEvent(EventDeviceSeverityChanged, prevSeverity, dev.Info.Severity))
+	}
+
+	prevAlarms := make(map[string]bool, len(prev.snapshot.Info.Alarms))
+	for _, a := range prev.snapshot.Info.Alarms {
+		prevAlarms[alarmKey(a)] = true
+	}
+
+	for _, a := range dev.Info.Alarms {
+		if !prevAlarms[alarmKey(a)] {
+			events = append(events, newEvent(EventDeviceAlarm, nil, a))
+		}
+	}
+
+	prevEnvs := make(map[int64]domain.Env, len(prev.snapshot.Info.Envs))
+	for _, env := range prev.snapshot.Info.Envs {
+		prevEnvs[env.ID] = env
+	}
+
+	for _, env := range dev.Info.Envs {
+		prevEnv, ok := prevEnvs[env.ID]
+		if !ok {
+			continue
+		}
+
+		if prevEnv.Demand != env.Demand {
+			e := newEvent(EventEnvDemandChanged, prevEnv.Demand, env.Demand)
+			e.EnvID, e.EnvName = env.ID, env.Name
+			events = append(events, e)
+		}
+
+		// Если целевая температура неизвестна, изменение не определить
+		if prevEnv.Target != nil && env.Target != nil && *prevEnv.Target != *env.Target {
+			e := newEvent(EventEnvTargetChanged, *prevEnv.Target, *env.Target)
+			e.EnvID, e.EnvName = env.ID, env.Name
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// alarmKey формат аварий в API MyHeat не описан, поэтому аварии сравниваются по их JSON-представлению
+func alarmKey(a interface{}) string {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestEventStream_HandlePull(t *testing.T) {
+	s := NewEventStream(nopwrap.NewNopWrapper())
+
+	events, unsubscribe := s.Subscribe()
+	defer unsubscribe()
+
+	first := testPullResult()
+	first.Devices[0].Info.DataActual = true
+
+	// Первый опрос только запоминает состояние
+	s.HandlePull(context.Background(), first)
+
+	second := testPullResult()
+	second.Time = first.Time.Add(time.Minute)
+	second.Devices[0].Info.DataActual = true
+	second.Devices[0].Info.Severity = 32
+	second.Devices[0].Info.Alarms = []interface{}{map[string]interface{}{"id": 1.0}}
+	second.Devices[0].Info.Envs[0].Demand = false
+	second.Devices[0].Info.Envs[0].Target = floatPtr(23)
+
+	s.HandlePull(context.Background(), second)
+
+	// Устройство пропало из опроса
+	third := PullResult{Time: second.Time.Add(time.Minute)}
+
+	s.HandlePull(context.Background(), third)
+
+	want := []EventType{
+		EventDeviceSeverityChanged,
+		EventDeviceAlarm,
+		EventEnvDemandChanged,
+		EventEnvTargetChanged,
+		EventDeviceOffline,
+	}
+
+	for _, typ := range want {
+		select {
+		case e := <-events:
+			if e.Type != typ {
+				t.Fatalf("event type = %s, want %s", e.Type, typ)
+			}
+
+			if e.DeviceID != 10 {
+				t.Errorf("event device = %d, want 10", e.DeviceID)
+			}
+
+			if e.Type == EventEnvTargetChanged && (e.Old != 22.0 || e.New != 23.0 || e.EnvID != 1) {
+				t.Errorf("unexpected target event: %+v", e)
+			}
+		default:
+			t.Fatalf("event %s was not published", typ)
+		}
+	}
+
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event: %+v", e)
+	default:
+	}
+}
+
+func TestEventStream_Run(t *testing.T) {
+	s := NewEventStream(nopwrap.NewNopWrapper())
+
+	events, _ := s.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.Run(ctx)
+
+	if _, ok := <-events; ok {
+		t.Errorf("subscriber channel must be closed after shutdown")
+	}
+}
@@ -5,18 +5,22 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
 	"github.com/denistv/wdlogger"
 )
 
 func NewExporterConfig(pullInterval time.Duration) ExporterConfig {
 	return ExporterConfig{
 		PullInterval: pullInterval,
+		StaleData:    StaleDataMark,
 	}
 }
 
 type ExporterConfig struct {
 	PullInterval time.Duration
+	StaleData    StaleDataPolicy
+	// Filter какие устройства и помещения экспортируются. По умолчанию все
+	Filter FilterConfig
 }
 
 func (e ExporterConfig) Validate() error {
@@ -24,14 +28,51 @@ func (e ExporterConfig) Validate() error {
 		return fmt.Errorf("exporter pull interval must be positive number")
 	}
 
+	if _, err := ParseStaleDataPolicy(string(e.StaleData)); err != nil {
+		return err
+	}
+
+	if err := e.Filter.Validate(); err != nil {
+		return fmt.Errorf("validating filter: %w", err)
+	}
+
 	return nil
 }
 
-func NewExporter(cfg ExporterConfig, evanClient *myheat.Client, l wdlogger.Logger, metricsService *Metrics) *Exporter {
+// StaleDataPolicy что делать с показаниями устройства, данные которого MyHeat считает неактуальными (DataActual),
+// например, когда контроллер потерял связь и облако отдает последние сохраненные значения.
+// Политика применяется в Exporter до передачи результата опроса подписчикам, поэтому действует на все выходы:
+// метрики, MQTT, InfluxDB, историю, API и оповещения. При любой политике время нагрева таких устройств
+// не учитывается в счетчиках, а признак запроса нагрева публикуется как есть
+type StaleDataPolicy string
+
+const (
+	// StaleDataMark показания публикуются, а неактуальность отмечается метрикой myheat_dev_data_actual
+	StaleDataMark StaleDataPolicy = "mark"
+	// StaleDataSkip показания помещений и уличная температура не публикуются, пока данные неактуальны
+	StaleDataSkip StaleDataPolicy = "skip"
+)
+
+func ParseStaleDataPolicy(s string) (StaleDataPolicy, error) {
+	switch p := StaleDataPolicy(s); p {
+	case StaleDataMark, StaleDataSkip:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown stale data policy %q, expected %q or %q", s, StaleDataMark, StaleDataSkip)
+	}
+}
+
+// DataSource источник данных, который опрашивает Exporter: MyHeat API (myheat.Source), симуляция и т.п.
+type DataSource interface {
+	GetDevices(ctx context.Context) ([]domain.Device, error)
+	GetDeviceInfo(ctx context.Context, id int64) (domain.DeviceInfo, error)
+}
+
+func NewExporter(cfg ExporterConfig, source DataSource, l wdlogger.Logger, metricsService *Metrics) *Exporter {
 	return &Exporter{
 		cfg:            cfg,
 		logger:         l,
-		myheat:         evanClient,
+		source:         source,
 		metricsService: metricsService,
 	}
 }
@@ -39,14 +80,38 @@ func NewExporter(cfg ExporterConfig, evanClient *myheat.Client, l wdlogger.Logge
 type Exporter struct {
 	cfg            ExporterConfig
 	logger         wdlogger.Logger
-	myheat         *myheat.Client
+	source         DataSource
 	metricsService *Metrics
+	subscribers    []PullSubscriber
+}
+
+// DeviceSnapshot данные устройства, полученные за один опрос MyHeat API
+type DeviceSnapshot struct {
+	Device domain.Device
+	Info   domain.DeviceInfo
+}
+
+// PullResult результат одного опроса MyHeat API
+type PullResult struct {
+	Time    time.Time
+	Devices []DeviceSnapshot
+}
+
+// PullSubscriber получает результат каждого опроса MyHeat API, например, для отправки данных во внешние системы
+type PullSubscriber interface {
+	HandlePull(ctx context.Context, res PullResult)
+}
+
+// Subscribe добавляет получателей результатов опроса. Должен вызываться до Run
+func (e *Exporter) Subscribe(s ...PullSubscriber) {
+	e.subscribers = append(e.subscribers, s...)
 }
 
 func (e *Exporter) Run(ctx context.Context) {
 	e.logger.Info("exporter started")
 
-	ticker := time.NewTicker(time.Second * 30)
+	ticker := time.NewTicker(e.cfg.PullInterval)
+	defer ticker.Stop()
 
 	err := e.pull(ctx)
 	if err != nil {
@@ -68,23 +133,42 @@ func (e *Exporter) Run(ctx context.Context) {
 	}
 }
 
-func (e *Exporter) pull(ctx context.Context) error {
+// Pull выполняет один опрос MyHeat API. Используется для разовых запусков, в остальных случаях опрос выполняет Run
+func (e *Exporter) Pull(ctx context.Context) error {
+	return e.pull(ctx)
+}
+
+func (e *Exporter) pull(ctx context.Context) (err error) {
+	defer func() {
+		e.metricsService.ObservePull(time.Now(), err)
+	}()
+
 	e.logger.Info("pull data from myheat")
 	defer func() {
 		e.logger.Info("pull data from myheat complete")
 	}()
 
-	getDevicesResp, err := e.myheat.GetDevices(ctx)
+	devices, err := e.source.GetDevices(ctx)
 	if err != nil {
 		return fmt.Errorf("getting devices: %w", err)
 	}
 
-	if len(getDevicesResp.Data["devices"]) == 0 {
+	if len(devices) == 0 {
 		return nil
 	}
 
-	for _, device := range getDevicesResp.Data["devices"] {
-		deviceInfo, err := e.myheat.GetDeviceInfo(ctx, device.ID)
+	res := PullResult{
+		Time:    time.Now(),
+		Devices: make([]DeviceSnapshot, 0, len(devices)),
+	}
+
+	for _, device := range devices {
+		// Отфильтрованные устройства не запрашиваются, чтобы не тратить на них запросы к API
+		if !e.cfg.Filter.MatchDevice(device) {
+			continue
+		}
+
+		deviceInfo, err := e.source.GetDeviceInfo(ctx, device.ID)
 		if err != nil {
 			e.logger.Error(
 				"get device info error",
@@ -93,25 +177,90 @@ func (e *Exporter) pull(ctx context.Context) error {
 			continue
 		}
 
-		if len(deviceInfo.Data.Envs) == 0 {
+		if len(deviceInfo.Envs) == 0 {
 			e.logger.Warn("empty device info data", wdlogger.NewInt64Field("id", device.ID))
 			continue
 		}
 
-		e.metricsService.SetDeviceWeatherTemp(device.ID, device.Name, device.City, deviceInfo.Data.WeatherTemp)
-		e.metricsService.SetDeviceSeverity(device.ID, device.Name, device.Severity, device.SeverityDesc)
+		deviceInfo.Envs = e.cfg.Filter.FilterEnvs(deviceInfo.Envs)
+		deviceInfo = applyStaleDataPolicy(deviceInfo, e.cfg.StaleData)
 
-		for _, env := range deviceInfo.Data.Envs {
-			if env.Type != myheat.EnvTypeRoomTemperature {
-				continue
-			}
+		snapshot := DeviceSnapshot{Device: device, Info: deviceInfo}
+		setDeviceMetrics(e.metricsService, snapshot, e.cfg.StaleData)
 
-			e.metricsService.SetEnvironmentTempCurrent(env.ID, env.Name, env.Value)
-			e.metricsService.SetEnvironmentTempTarget(env.ID, env.Name, env.Target)
-			e.metricsService.SetEnvironmentHeatDemand(env.ID, env.Name, env.Demand)
-			e.metricsService.CountEnvHeatDemandSeconds(env.ID, env.Name, env.Demand)
-		}
+		res.Devices = append(res.Devices, snapshot)
+	}
+
+	for _, s := range e.subscribers {
+		s.HandlePull(ctx, res)
 	}
 
 	return nil
 }
+
+// applyStaleDataPolicy убирает показания из неактуальных данных устройства при StaleDataSkip
+func applyStaleDataPolicy(info domain.DeviceInfo, policy StaleDataPolicy) domain.DeviceInfo {
+	if info.DataActual || policy != StaleDataSkip {
+		return info
+	}
+
+	info.WeatherTemp = nil
+
+	// Помещения копируются, чтобы не изменять данные источника
+	envs := make([]domain.Env, 0, len(info.Envs))
+
+	for _, env := range info.Envs {
+		env.Value = nil
+		env.Target = nil
+
+		envs = append(envs, env)
+	}
+
+	info.Envs = envs
+
+	return info
+}
+
+// setDeviceMetrics обновляет метрики устройства и его помещений
+func setDeviceMetrics(m *Metrics, dev DeviceSnapshot, staleData StaleDataPolicy) {
+	device := dev.Device
+	skip := !dev.Info.DataActual && staleData == StaleDataSkip
+
+	if dev.Info.WeatherTemp != nil && !skip {
+		m.SetDeviceWeatherTemp(device.ID, device.Name, device.City, *dev.Info.WeatherTemp)
+	} else {
+		m.DeleteDeviceWeatherTemp(device.ID, device.Name, device.City)
+	}
+
+	m.SetDeviceSeverity(device.ID, device.Name, device.Severity, device.SeverityDesc)
+	m.SetDeviceDataActual(device.ID, device.Name, dev.Info.DataActual)
+
+	for _, env := range dev.Info.Envs {
+		if env.Type != domain.EnvTypeRoomTemperature {
+			continue
+		}
+
+		// Пока данные неактуальны, настоящее состояние нагрева неизвестно, поэтому время нагрева не считается
+		m.CountEnvHeatDemandSeconds(env.ID, env.Name, env.Demand && dev.Info.DataActual)
+
+		if skip {
+			m.DeleteEnvironmentReadings(env.ID, env.Name)
+			continue
+		}
+
+		// Отсутствующее показание удаляется, а не публикуется как 0
+		if env.Value != nil {
+			m.SetEnvironmentTempCurrent(env.ID, env.Name, *env.Value)
+		} else {
+			m.DeleteEnvironmentTempCurrent(env.ID, env.Name)
+		}
+
+		if env.Target != nil {
+			m.SetEnvironmentTempTarget(env.ID, env.Name, *env.Target)
+		} else {
+			m.DeleteEnvironmentTempTarget(env.ID, env.Name)
+		}
+
+		m.SetEnvironmentHeatDemand(env.ID, env.Name, env.Demand)
+	}
+}
@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat/myheattest"
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type exporterTestEnv struct {
+	srv      *myheattest.Server
+	exporter *Exporter
+	reg      *prometheus.Registry
+	state    *StateStore
+}
+
+func newExporterTestEnv(t *testing.T) exporterTestEnv {
+	t.Helper()
+
+	srv := myheattest.NewServer("user", "secret")
+	t.Cleanup(srv.Close)
+
+	srv.AddDevice(
+		myheat.Device{ID: 10, Name: "Дом", City: "Москва", Severity: myheat.DevSeverityNormal},
+		myheat.DeviceInfo{
+			DataActual:  true,
+			WeatherTemp: myheat.NewFlexFloat(-5.5),
+			Envs: []myheat.Env{
+				{ID: 1, Name: "Гостиная", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(21.25), Target: myheat.NewFlexFloat(22), Demand: true},
+				{ID: 2, Name: "Котел", Type: "boiler_temperature", Value: myheat.NewFlexFloat(60)},
+			},
+		},
+	)
+	srv.AddDevice(
+		myheat.Device{ID: 20, Name: "Дача", City: "Тверь", Severity: myheat.DevSeverityLowBalance},
+		myheat.DeviceInfo{
+			DataActual:  true,
+			WeatherTemp: myheat.NewFlexFloat(-8),
+			Envs: []myheat.Env{
+				{ID: 3, Name: "Спальня", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(12), Target: myheat.NewFlexFloat(10)},
+			},
+		},
+	)
+
+	l := nopwrap.NewNopWrapper()
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(l, NewTariffSelector(time.Now, nil), LabelsConfig{}, reg)
+	client := myheat.NewClient(srv.Config(), l)
+
+	state := NewStateStore()
+	exporter := NewExporter(NewExporterConfig(time.Minute), myheat.NewSource(client), l, metrics)
+	exporter.Subscribe(state)
+
+	return exporterTestEnv{srv: srv, exporter: exporter, reg: reg, state: state}
+}
+
+// scrape возвращает вывод /metrics
+func (e exporterTestEnv) scrape(t *testing.T) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(e.reg, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("reading metrics: %v", err)
+	}
+
+	return string(body)
+}
+
+func assertMetrics(t *testing.T, body string, want []string, notWant []string) {
+	t.Helper()
+
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics do not contain %q", line)
+		}
+	}
+
+	for _, s := range notWant {
+		if strings.Contains(body, s) {
+			t.Errorf("metrics must not contain %q", s)
+		}
+	}
+}
+
+func TestExporter_Pull(t *testing.T) {
+	env := newExporterTestEnv(t)
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	assertMetrics(t, env.scrape(t), []string{
+		`myheat_env_temp_current{id="1",name="Гостиная"} 21.25`,
+		`myheat_env_temp_target{id="1",name="Гостиная"} 22`,
+		`myheat_env_heat_demand{id="1",name="Гостиная"} 1`,
+		`myheat_env_temp_current{id="3",name="Спальня"} 12`,
+		`myheat_env_heat_demand{id="3",name="Спальня"} 0`,
+		`myheat_dev_weather_temp{city="Москва",id="10",name="Дом"} -5.5`,
+		`myheat_dev_severity{id="10",name="Дом"} 1`,
+		`myheat_dev_severity{id="20",name="Дача"} 32`,
+		`myheat_dev_data_actual{id="10",name="Дом"} 1`,
+		`myheat_exporter_pulls_total 1`,
+		`myheat_exporter_pull_errors_total 0`,
+	}, []string{
+		`id="2"`,
+	})
+
+	// Температура меняется между опросами, сценарий изменяет данные перед ответом
+	env.srv.Script(myheattest.Step{
+		Action:   myheattest.ActionGetDeviceInfo,
+		DeviceID: 10,
+		Update: func(d *myheattest.Data) {
+			room := d.Env(10, 1)
+			room.Value = myheat.NewFlexFloat(22.5)
+			room.Demand = false
+		},
+	})
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	assertMetrics(t, env.scrape(t), []string{
+		`myheat_env_temp_current{id="1",name="Гостиная"} 22.5`,
+		`myheat_env_heat_demand{id="1",name="Гостиная"} 0`,
+		`myheat_exporter_pulls_total 2`,
+	}, nil)
+}
+
+func TestExporter_Pull_MissingReadings(t *testing.T) {
+	env := newExporterTestEnv(t)
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	// Датчики уличной температуры и помещения перестали передавать данные: API отдает пустую строку и null,
+	// целевая температура передается строкой
+	env.srv.Script(myheattest.Step{
+		Action:   myheattest.ActionGetDeviceInfo,
+		DeviceID: 10,
+		Body: `{"data": {"dataActual": true, "weatherTemp": "", "envs": [
+			{"id": 1, "name": "Гостиная", "type": "room_temperature", "value": null, "target": "22.5", "demand": true}]}, "err": 0}`,
+	})
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	assertMetrics(t, env.scrape(t), []string{
+		`myheat_dev_weather_temp{city="Тверь",id="20",name="Дача"} -8`,
+		`myheat_env_temp_target{id="1",name="Гостиная"} 22.5`,
+		`myheat_env_heat_demand{id="1",name="Гостиная"} 1`,
+	}, []string{
+		`myheat_dev_weather_temp{city="Москва"`,
+		`myheat_env_temp_current{id="1"`,
+	})
+
+	dev, ok := env.state.Device(10)
+	if !ok || len(dev.Info.Envs) != 1 || dev.Info.Envs[0].Value != nil {
+		t.Errorf("device state = %+v, want env without value", dev)
+	}
+}
+
+func TestExporter_Pull_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		step        myheattest.Step
+		timeout     time.Duration
+		wantErr     bool
+		wantDevices int
+	}{
+		{
+			name:    "код ошибки getDevices",
+			step:    myheattest.Step{Action: myheattest.ActionGetDevices, Err: myheattest.ErrCodeAuth},
+			wantErr: true,
+		},
+		{
+			name:    "некорректный JSON getDevices",
+			step:    myheattest.Step{Action: myheattest.ActionGetDevices, Body: `{"data":`},
+			wantErr: true,
+		},
+		{
+			name:    "превышено время ожидания",
+			step:    myheattest.Step{Action: myheattest.ActionGetDevices, Latency: time.Second},
+			timeout: 100 * time.Millisecond,
+			wantErr: true,
+		},
+		{
+			name:        "ошибка getDeviceInfo одного устройства",
+			step:        myheattest.Step{Action: myheattest.ActionGetDeviceInfo, DeviceID: 20, Err: myheattest.ErrCodeNotFound},
+			wantDevices: 1,
+		},
+		{
+			name:        "некорректный JSON getDeviceInfo",
+			step:        myheattest.Step{Action: myheattest.ActionGetDeviceInfo, DeviceID: 10, Body: `{"data": {"envs": "oops"}}`},
+			wantDevices: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newExporterTestEnv(t)
+			env.srv.Script(tt.step)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			err := env.exporter.Pull(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pull() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := len(env.state.Devices()); got != tt.wantDevices {
+				t.Errorf("devices = %d, want %d", got, tt.wantDevices)
+			}
+
+			errors := "0"
+			if tt.wantErr {
+				errors = "1"
+			}
+
+			assertMetrics(t, env.scrape(t), []string{
+				`myheat_exporter_pulls_total 1`,
+				`myheat_exporter_pull_errors_total ` + errors,
+			}, nil)
+		})
+	}
+}
+
+func TestExporter_SetEnvGoal(t *testing.T) {
+	env := newExporterTestEnv(t)
+
+	client := myheat.NewClient(env.srv.Config(), nopwrap.NewNopWrapper())
+	if err := client.SetEnvGoal(context.Background(), 10, 1, 24); err != nil {
+		t.Fatalf("SetEnvGoal() error = %v", err)
+	}
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	assertMetrics(t, env.scrape(t), []string{
+		`myheat_env_temp_target{id="1",name="Гостиная"} 24`,
+	}, nil)
+}
+
+// pullRecorder запоминает последний результат опроса
+type pullRecorder struct {
+	last PullResult
+}
+
+func (r *pullRecorder) HandlePull(_ context.Context, res PullResult) {
+	r.last = res
+}
+
+func TestExporter_Pull_StaleData(t *testing.T) {
+	tests := []struct {
+		policy  StaleDataPolicy
+		want    []string
+		notWant []string
+		// wantHistory показания помещения 1 в истории
+		wantHistory map[string]float64
+	}{
+		{
+			policy:      StaleDataMark,
+			wantHistory: map[string]float64{"value": 21.25, "target": 22, "demand": 1},
+			want: []string{
+				`myheat_dev_data_actual{id="10",name="Дом"} 0`,
+				`myheat_env_temp_current{id="1",name="Гостиная"} 21.25`,
+				`myheat_env_heat_demand{id="1",name="Гостиная"} 1`,
+				`myheat_dev_weather_temp{city="Москва",id="10",name="Дом"} -5.5`,
+			},
+		},
+		{
+			policy:      StaleDataSkip,
+			wantHistory: map[string]float64{"demand": 1},
+			want: []string{
+				`myheat_dev_data_actual{id="10",name="Дом"} 0`,
+				`myheat_env_temp_current{id="3",name="Спальня"} 12`,
+			},
+			notWant: []string{
+				`myheat_env_temp_current{id="1"`,
+				`myheat_env_heat_demand{id="1"`,
+				`myheat_dev_weather_temp{city="Москва"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			env := newExporterTestEnv(t)
+			env.exporter.cfg.StaleData = tt.policy
+
+			rec := &pullRecorder{}
+			env.exporter.Subscribe(rec)
+
+			if err := env.exporter.Pull(context.Background()); err != nil {
+				t.Fatalf("Pull() error = %v", err)
+			}
+
+			if !env.exporter.metricsService.envHeatDemandSecondsState[1].value {
+				t.Fatalf("heat demand must be counted while data is actual")
+			}
+
+			// Контроллер потерял связь, облако отдает последние сохраненные данные
+			env.srv.Update(func(d *myheattest.Data) {
+				info := d.Infos[10]
+				info.DataActual = false
+				d.Infos[10] = info
+			})
+
+			if err := env.exporter.Pull(context.Background()); err != nil {
+				t.Fatalf("Pull() error = %v", err)
+			}
+
+			assertMetrics(t, env.scrape(t), tt.want, tt.notWant)
+
+			if env.exporter.metricsService.envHeatDemandSecondsState[1].value {
+				t.Errorf("heat demand must not be counted while data is stale")
+			}
+
+			// Подписчики получают признак запроса нагрева как есть, не учитывается только время нагрева в счетчиках
+			gotHistory := make(map[string]float64)
+
+			for _, r := range PullResultToReadings(rec.last) {
+				if r.ObjectType == history.ObjectEnv && r.ObjectID == 1 {
+					gotHistory[r.Metric] = r.Value
+				}
+			}
+
+			if !reflect.DeepEqual(gotHistory, tt.wantHistory) {
+				t.Errorf("history readings = %v, want %v", gotHistory, tt.wantHistory)
+			}
+		})
+	}
+}
+
+func TestExporter_Pull_Filter(t *testing.T) {
+	env := newExporterTestEnv(t)
+	env.srv.AddDevice(
+		myheat.Device{ID: 30, Name: "Тестовый стенд", City: "Москва", Severity: myheat.DevSeverityNormal},
+		myheat.DeviceInfo{
+			DataActual: true,
+			Envs: []myheat.Env{
+				{ID: 4, Name: "Стенд", Type: myheat.EnvTypeRoomTemperature, Value: myheat.NewFlexFloat(20), Target: myheat.NewFlexFloat(20)},
+			},
+		},
+	)
+
+	env.exporter.cfg.Filter = FilterConfig{
+		Devices: FilterRules{
+			Include: []FilterRule{{Cities: []string{"Москва"}}},
+			Exclude: []FilterRule{{NameRegex: &Regexp{regexp.MustCompile(`(?i)тест`)}}},
+		},
+		Envs: FilterRules{
+			Exclude: []FilterRule{{Types: []string{"boiler_temperature"}}},
+		},
+	}
+
+	if err := env.exporter.Pull(context.Background()); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	assertMetrics(t, env.scrape(t), []string{
+		`myheat_env_temp_current{id="1",name="Гостиная"} 21.25`,
+	}, []string{
+		`id="20"`,
+		`id="30"`,
+		`myheat_env_temp_current{id="3"`,
+		`myheat_env_temp_current{id="4"`,
+	})
+
+	// Отфильтрованные устройства не запрашиваются
+	for _, r := range env.srv.Requests() {
+		if r.Action == myheattest.ActionGetDeviceInfo && r.DeviceID != 10 {
+			t.Errorf("unexpected getDeviceInfo request for device %d", r.DeviceID)
+		}
+	}
+
+	// Помещения фильтруются и в результате опроса, который получают подписчики
+	devices := env.state.Devices()
+	if len(devices) != 1 || devices[0].Device.ID != 10 {
+		t.Fatalf("devices = %+v", devices)
+	}
+
+	if envs := devices[0].Info.Envs; len(envs) != 1 || envs[0].ID != 1 {
+		t.Errorf("envs = %+v", envs)
+	}
+}
+
+func TestExporter_Run_PullInterval(t *testing.T) {
+	env := newExporterTestEnv(t)
+	env.exporter.cfg.PullInterval = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+
+	env.exporter.Run(ctx)
+
+	pulls := 0
+
+	for _, r := range env.srv.Requests() {
+		if r.Action == myheattest.ActionGetDevices {
+			pulls++
+		}
+	}
+
+	// Первый опрос при запуске и затем каждые 20ms
+	if pulls < 3 {
+		t.Errorf("pulls = %d, want at least 3 with 20ms interval", pulls)
+	}
+}
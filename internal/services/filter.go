@@ -0,0 +1,201 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"gopkg.in/yaml.v2"
+)
+
+// FilterConfig какие устройства и помещения экспортируются. Загружается из YAML-файла
+type FilterConfig struct {
+	Devices FilterRules `yaml:"devices"`
+	Envs    FilterRules `yaml:"envs"`
+}
+
+// FilterRules объект проходит фильтр, если подходит хотя бы под одно правило Include (или Include пуст)
+// и не подходит ни под одно правило Exclude
+type FilterRules struct {
+	Include []FilterRule `yaml:"include"`
+	Exclude []FilterRule `yaml:"exclude"`
+}
+
+// FilterRule правило фильтра. Объект подходит под правило, если выполнены все заданные в нем условия.
+// Cities применяются только к устройствам, Types - только к помещениям
+type FilterRule struct {
+	IDs       []int64  `yaml:"ids"`
+	Names     []string `yaml:"names"`
+	NameRegex *Regexp  `yaml:"name_regex"`
+	Cities    []string `yaml:"cities"`
+	CityRegex *Regexp  `yaml:"city_regex"`
+	Types     []string `yaml:"types"`
+	TypeRegex *Regexp  `yaml:"type_regex"`
+}
+
+// Regexp регулярное выражение, которое компилируется при чтении конфигурации
+type Regexp struct {
+	*regexp.Regexp
+}
+
+func (r *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
+	}
+
+	r.Regexp = re
+
+	return nil
+}
+
+func LoadFilterConfig(path string) (FilterConfig, error) {
+	cfg := FilterConfig{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c FilterConfig) Validate() error {
+	for _, rules := range [][]FilterRule{c.Devices.Include, c.Devices.Exclude} {
+		for i, r := range rules {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("device rule %d: %w", i, err)
+			}
+
+			if len(r.Types) > 0 || r.TypeRegex != nil {
+				return fmt.Errorf("device rule %d: types cannot be used for devices", i)
+			}
+		}
+	}
+
+	for _, rules := range [][]FilterRule{c.Envs.Include, c.Envs.Exclude} {
+		for i, r := range rules {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("env rule %d: %w", i, err)
+			}
+
+			if len(r.Cities) > 0 || r.CityRegex != nil {
+				return fmt.Errorf("env rule %d: cities cannot be used for envs", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r FilterRule) validate() error {
+	if len(r.IDs) == 0 && len(r.Names) == 0 && r.NameRegex == nil &&
+		len(r.Cities) == 0 && r.CityRegex == nil && len(r.Types) == 0 && r.TypeRegex == nil {
+		return errors.New("rule must have at least one condition")
+	}
+
+	return nil
+}
+
+// MatchDevice проверяет, экспортируется ли устройство
+func (c FilterConfig) MatchDevice(d domain.Device) bool {
+	return c.Devices.match(func(r FilterRule) bool {
+		return r.matchID(d.ID) && r.matchName(d.Name) && matchString(d.City, r.Cities, r.CityRegex)
+	})
+}
+
+// MatchEnv проверяет, экспортируется ли помещение
+func (c FilterConfig) MatchEnv(e domain.Env) bool {
+	return c.Envs.match(func(r FilterRule) bool {
+		return r.matchID(e.ID) && r.matchName(e.Name) && matchString(e.Type, r.Types, r.TypeRegex)
+	})
+}
+
+// FilterEnvs возвращает помещения, которые экспортируются
+func (c FilterConfig) FilterEnvs(envs []domain.Env) []domain.Env {
+	if len(c.Envs.Include) == 0 && len(c.Envs.Exclude) == 0 {
+		return envs
+	}
+
+	res := make([]domain.Env, 0, len(envs))
+
+	for _, e := range envs {
+		if c.MatchEnv(e) {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+func (f FilterRules) match(matchRule func(r FilterRule) bool) bool {
+	included := len(f.Include) == 0
+
+	for _, r := range f.Include {
+		if matchRule(r) {
+			included = true
+			break
+		}
+	}
+
+	if !included {
+		return false
+	}
+
+	for _, r := range f.Exclude {
+		if matchRule(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r FilterRule) matchID(id int64) bool {
+	if len(r.IDs) == 0 {
+		return true
+	}
+
+	for _, v := range r.IDs {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r FilterRule) matchName(name string) bool {
+	return matchString(name, r.Names, r.NameRegex)
+}
+
+// matchString проверяет значение по списку точных значений и регулярному выражению. Незаданное условие выполняется всегда
+func matchString(s string, values []string, re *Regexp) bool {
+	if len(values) > 0 {
+		found := false
+
+		for _, v := range values {
+			if v == s {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return re == nil || re.MatchString(s)
+}
@@ -0,0 +1,133 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+)
+
+func TestLoadFilterConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "корректный фильтр",
+			data: `
+devices:
+  include:
+    - ids: [10, 20]
+  exclude:
+    - name_regex: "(?i)тест"
+envs:
+  exclude:
+    - types: [boiler_temperature]
+    - names: [Гараж]
+`,
+		},
+		{
+			name:    "некорректное регулярное выражение",
+			data:    "devices:\n  exclude:\n    - name_regex: \"(\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "правило без условий",
+			data:    "devices:\n  include:\n    - {}\n",
+			wantErr: true,
+		},
+		{
+			name:    "тип в правиле устройства",
+			data:    "devices:\n  include:\n    - types: [room_temperature]\n",
+			wantErr: true,
+		},
+		{
+			name:    "город в правиле помещения",
+			data:    "envs:\n  include:\n    - cities: [Москва]\n",
+			wantErr: true,
+		},
+		{
+			name:    "неизвестное поле",
+			data:    "devices:\n  include:\n    - id: 10\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "filter.yml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadFilterConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadFilterConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterConfig_Match(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filter.yml")
+	data := `
+devices:
+  include:
+    - cities: [Москва]
+    - ids: [30]
+  exclude:
+    - name_regex: "(?i)тест"
+envs:
+  include:
+    - types: [room_temperature]
+  exclude:
+    - ids: [2]
+    - names: [Гараж]
+      type_regex: "^room_"
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFilterConfig(path)
+	if err != nil {
+		t.Fatalf("LoadFilterConfig() error = %v", err)
+	}
+
+	devices := []struct {
+		name   string
+		device domain.Device
+		want   bool
+	}{
+		{name: "подходит по городу", device: domain.Device{ID: 10, Name: "Дом", City: "Москва"}, want: true},
+		{name: "подходит по id", device: domain.Device{ID: 30, Name: "Баня", City: "Тверь"}, want: true},
+		{name: "не подходит под include", device: domain.Device{ID: 20, Name: "Дача", City: "Тверь"}},
+		{name: "исключено по имени", device: domain.Device{ID: 40, Name: "Тестовый стенд", City: "Москва"}},
+	}
+
+	for _, tt := range devices {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.MatchDevice(tt.device); got != tt.want {
+				t.Errorf("MatchDevice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	envs := []domain.Env{
+		{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature},
+		{ID: 2, Name: "Спальня", Type: domain.EnvTypeRoomTemperature},
+		{ID: 3, Name: "Гараж", Type: domain.EnvTypeRoomTemperature},
+		{ID: 4, Name: "Котел", Type: "boiler_temperature"},
+	}
+
+	got := cfg.FilterEnvs(envs)
+	if len(got) != 1 || got[0].ID != 1 {
+		t.Errorf("FilterEnvs() = %+v", got)
+	}
+
+	if got := (FilterConfig{}).FilterEnvs(envs); len(got) != len(envs) {
+		t.Errorf("empty filter must keep all envs, got %+v", got)
+	}
+}
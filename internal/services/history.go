@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
+	"github.com/denistv/wdlogger"
+)
+
+const (
+	defaultHistoryCleanupInterval = time.Hour
+	defaultHistoryTimeout         = time.Second * 30
+)
+
+func NewDefaultHistoryRecorderConfig() HistoryRecorderConfig {
+	return HistoryRecorderConfig{
+		CleanupInterval: defaultHistoryCleanupInterval,
+		Timeout:         defaultHistoryTimeout,
+	}
+}
+
+type HistoryRecorderConfig struct {
+	// Retention сколько хранить показания. 0 - хранить всегда
+	Retention       time.Duration
+	CleanupInterval time.Duration
+	Timeout         time.Duration
+}
+
+func (c HistoryRecorderConfig) Validate() error {
+	if c.Retention < 0 {
+		return errors.New("retention cannot be negative")
+	}
+
+	if c.CleanupInterval <= 0 {
+		return errors.New("cleanup interval must be positive number")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive number")
+	}
+
+	return nil
+}
+
+func NewHistoryRecorder(cfg HistoryRecorderConfig, store *history.Store, l wdlogger.Logger) *HistoryRecorder {
+	return &HistoryRecorder{
+		cfg:         cfg,
+		logger:      l,
+		store:       store,
+		timeNowFunc: time.Now,
+	}
+}
+
+// HistoryRecorder сохраняет показания каждого опроса в локальную базу и удаляет устаревшие
+type HistoryRecorder struct {
+	cfg         HistoryRecorderConfig
+	logger      wdlogger.Logger
+	store       *history.Store
+	timeNowFunc func() time.Time
+}
+
+func (h *HistoryRecorder) Run(ctx context.Context) {
+	h.logger.Info("history recorder started")
+
+	if h.cfg.Retention == 0 {
+		<-ctx.Done()
+		return
+	}
+
+	h.cleanup(ctx)
+
+	ticker := time.NewTicker(h.cfg.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.logger.Info("received shutdown signal, exiting")
+			return
+		case <-ticker.C:
+			h.cleanup(ctx)
+		}
+	}
+}
+
+func (h *HistoryRecorder) cleanup(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	deleted, err := h.store.DeleteBefore(ctx, h.timeNowFunc().Add(-h.cfg.Retention))
+	if err != nil {
+		h.logger.Error("deleting old history", wdlogger.NewErrorField("error", err))
+		return
+	}
+
+	if deleted > 0 {
+		h.logger.Info("old history deleted", wdlogger.NewInt64Field("rows", deleted))
+	}
+}
+
+func (h *HistoryRecorder) HandlePull(ctx context.Context, res PullResult) {
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.Timeout)
+	defer cancel()
+
+	if err := h.store.Insert(ctx, PullResultToReadings(res)); err != nil {
+		h.logger.Error("saving history", wdlogger.NewErrorField("error", err))
+	}
+}
+
+// PullResultToReadings преобразует результат опроса в показания для локальной истории
+func PullResultToReadings(res PullResult) []history.Reading {
+	readings := make([]history.Reading, 0)
+
+	for _, dev := range res.Devices {
+		add := func(objectType string, objectID int64, objectName, metric string, value float64) {
+			readings = append(readings, history.Reading{
+				Time:       res.Time,
+				DeviceID:   dev.Device.ID,
+				ObjectType: objectType,
+				ObjectID:   objectID,
+				ObjectName: objectName,
+				Metric:     metric,
+				Value:      value,
+			})
+		}
+
+		if dev.Info.WeatherTemp != nil {
+			add(history.ObjectDevice, dev.Device.ID, dev.Device.Name, "weather_temp", *dev.Info.WeatherTemp)
+		}
+
+		add(history.ObjectDevice, dev.Device.ID, dev.Device.Name, "severity", float64(dev.Device.Severity))
+		add(history.ObjectDevice, dev.Device.ID, dev.Device.Name, "data_actual", boolToFloat64(dev.Info.DataActual))
+
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			if env.Value != nil {
+				add(history.ObjectEnv, env.ID, env.Name, "value", *env.Value)
+			}
+
+			if env.Target != nil {
+				add(history.ObjectEnv, env.ID, env.Name, "target", *env.Target)
+			}
+
+			add(history.ObjectEnv, env.ID, env.Name, "demand", boolToFloat64(env.Demand))
+		}
+
+		for _, heater := range dev.Info.Heaters {
+			add(history.ObjectHeater, heater.ID, heater.Name, "burner_heating", boolToFloat64(heater.BurnerHeating))
+			add(history.ObjectHeater, heater.ID, heater.Name, "burner_water", boolToFloat64(heater.BurnerWater))
+			add(history.ObjectHeater, heater.ID, heater.Name, "disabled", boolToFloat64(heater.Disabled))
+
+			// Показаний датчиков, которых нет у котла, нет и в истории
+			for metric, value := range map[string]*float64{
+				"flow_temp":   heater.FlowTemp,
+				"return_temp": heater.ReturnTemp,
+				"pressure":    heater.Pressure,
+				"modulation":  heater.Modulation,
+			} {
+				if value != nil {
+					add(history.ObjectHeater, heater.ID, heater.Name, metric, *value)
+				}
+			}
+		}
+	}
+
+	return readings
+}
@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/influx"
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+)
+
+const (
+	measurementEnv    = "myheat_env"
+	measurementDevice = "myheat_dev"
+
+	defaultInfluxBatchSize     = 1000
+	defaultInfluxMaxBufferSize = 100000
+	defaultInfluxFlushInterval = time.Second * 10
+	defaultInfluxMaxRetries    = 3
+	defaultInfluxRetryBackoff  = time.Second
+	defaultInfluxTimeout       = time.Second * 10
+)
+
+func NewDefaultInfluxSinkConfig() InfluxSinkConfig {
+	return InfluxSinkConfig{
+		BatchSize:     defaultInfluxBatchSize,
+		MaxBufferSize: defaultInfluxMaxBufferSize,
+		FlushInterval: defaultInfluxFlushInterval,
+		MaxRetries:    defaultInfluxMaxRetries,
+		RetryBackoff:  defaultInfluxRetryBackoff,
+		Timeout:       defaultInfluxTimeout,
+	}
+}
+
+type InfluxSinkConfig struct {
+	// BatchSize число строк, при накоплении которого данные отправляются, не дожидаясь FlushInterval
+	BatchSize int
+	// MaxBufferSize максимальное число строк в буфере. При недоступности базы самые старые строки отбрасываются
+	MaxBufferSize int
+	FlushInterval time.Duration
+	MaxRetries    int
+	// RetryBackoff пауза перед первым повтором, с каждой попыткой удваивается
+	RetryBackoff time.Duration
+	Timeout      time.Duration
+}
+
+func (c InfluxSinkConfig) Validate() error {
+	if c.BatchSize <= 0 {
+		return errors.New("batch size must be positive number")
+	}
+
+	if c.MaxBufferSize < c.BatchSize {
+		return errors.New("max buffer size must not be less than batch size")
+	}
+
+	if c.FlushInterval <= 0 {
+		return errors.New("flush interval must be positive number")
+	}
+
+	if c.MaxRetries < 0 {
+		return errors.New("max retries cannot be negative")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive number")
+	}
+
+	return nil
+}
+
+// LineWriter записывает пачку строк line protocol. Реализуется influx.Client и influx.FileWriter
+type LineWriter interface {
+	Write(ctx context.Context, data []byte) error
+}
+
+func NewInfluxSink(cfg InfluxSinkConfig, w LineWriter, l wdlogger.Logger) *InfluxSink {
+	return &InfluxSink{
+		cfg:     cfg,
+		logger:  l,
+		writer:  w,
+		flushCh: make(chan struct{}, 1),
+	}
+}
+
+// InfluxSink после каждого опроса MyHeat API записывает данные в формате InfluxDB line protocol
+type InfluxSink struct {
+	cfg    InfluxSinkConfig
+	logger wdlogger.Logger
+	writer LineWriter
+
+	bufferMu sync.Mutex
+	buffer   [][]byte
+
+	flushCh chan struct{}
+}
+
+func (s *InfluxSink) Run(ctx context.Context) {
+	s.logger.Info("influx sink started")
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("received shutdown signal, flushing influx buffer")
+
+			// Контекст уже отменен, поэтому на последнюю отправку даем отдельный таймаут
+			flushCtx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+			s.flush(flushCtx)
+			cancel()
+
+			return
+		case <-ticker.C:
+			s.flush(ctx)
+		case <-s.flushCh:
+			s.flush(ctx)
+		}
+	}
+}
+
+func (s *InfluxSink) HandlePull(_ context.Context, res PullResult) {
+	lines := s.pullResultToLines(res)
+
+	s.bufferMu.Lock()
+
+	s.buffer = append(s.buffer, lines...)
+
+	if dropped := len(s.buffer) - s.cfg.MaxBufferSize; dropped > 0 {
+		s.logger.Warn("influx buffer overflow, dropping oldest lines", wdlogger.NewIntField("dropped", dropped))
+		s.buffer = s.buffer[dropped:]
+	}
+
+	full := len(s.buffer) >= s.cfg.BatchSize
+
+	s.bufferMu.Unlock()
+
+	if full {
+		select {
+		case s.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flush отправляет буфер пачками по BatchSize строк. При ошибке неотправленные строки возвращаются в буфер
+func (s *InfluxSink) flush(ctx context.Context) {
+	for {
+		s.bufferMu.Lock()
+		n := len(s.buffer)
+		if n > s.cfg.BatchSize {
+			n = s.cfg.BatchSize
+		}
+		batch := s.buffer[:n:n]
+		s.buffer = s.buffer[n:]
+		s.bufferMu.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+
+		data := make([]byte, 0, len(batch)*128)
+		for _, line := range batch {
+			data = append(data, line...)
+		}
+
+		if err := s.writeWithRetries(ctx, data); err != nil {
+			s.logger.Error("writing to influx", wdlogger.NewErrorField("error", err), wdlogger.NewIntField("lines", len(batch)))
+			s.restore(batch)
+			return
+		}
+	}
+}
+
+// restore возвращает неотправленную пачку в начало буфера
+func (s *InfluxSink) restore(batch [][]byte) {
+	s.bufferMu.Lock()
+	defer s.bufferMu.Unlock()
+
+	buffer := make([][]byte, 0, len(batch)+len(s.buffer))
+	buffer = append(buffer, batch...)
+	buffer = append(buffer, s.buffer...)
+
+	if dropped := len(buffer) - s.cfg.MaxBufferSize; dropped > 0 {
+		s.logger.Warn("influx buffer overflow, dropping oldest lines", wdlogger.NewIntField("dropped", dropped))
+		buffer = buffer[dropped:]
+	}
+
+	s.buffer = buffer
+}
+
+func (s *InfluxSink) writeWithRetries(ctx context.Context, data []byte) error {
+	backoff := s.cfg.RetryBackoff
+
+	var err error
+
+	for attempt := 0; attempt <= s.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			s.logger.Warn("retrying influx write", wdlogger.NewIntField("attempt", attempt), wdlogger.NewErrorField("error", err))
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+		err = s.writer.Write(writeCtx, data)
+		cancel()
+
+		if err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (s *InfluxSink) pullResultToLines(res PullResult) [][]byte {
+	lines := make([][]byte, 0)
+
+	// Точка, которую не удалось сформировать, пропускается, чтобы не терять остальные
+	add := func(p influx.Point) {
+		line, err := influx.AppendLine(nil, p)
+		if err != nil {
+			s.logger.Error("formatting influx line", wdlogger.NewErrorField("error", err), wdlogger.NewStringField("measurement", p.Measurement))
+			return
+		}
+
+		lines = append(lines, line)
+	}
+
+	for _, dev := range res.Devices {
+		deviceID := strconv.FormatInt(dev.Device.ID, 10)
+
+		deviceFields := map[string]interface{}{
+			"severity": dev.Device.Severity,
+		}
+
+		if dev.Info.WeatherTemp != nil {
+			deviceFields["weather_temp"] = *dev.Info.WeatherTemp
+		}
+
+		add(influx.Point{
+			Measurement: measurementDevice,
+			Tags: map[string]string{
+				"id":   deviceID,
+				"name": dev.Device.Name,
+				"city": dev.Device.City,
+			},
+			Fields: deviceFields,
+			Time:   res.Time,
+		})
+
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			envFields := map[string]interface{}{
+				"heat_demand": env.Demand,
+			}
+
+			if env.Value != nil {
+				envFields["temp_current"] = *env.Value
+			}
+
+			if env.Target != nil {
+				envFields["temp_target"] = *env.Target
+			}
+
+			add(influx.Point{
+				Measurement: measurementEnv,
+				Tags: map[string]string{
+					"device_id": deviceID,
+					"id":        strconv.FormatInt(env.ID, 10),
+					"name":      env.Name,
+				},
+				Fields: envFields,
+				Time:   res.Time,
+			})
+		}
+	}
+
+	return lines
+}
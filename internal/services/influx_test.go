@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+type fakeLineWriter struct {
+	failures int
+	writes   []string
+}
+
+func (f *fakeLineWriter) Write(_ context.Context, data []byte) error {
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("connection refused")
+	}
+
+	f.writes = append(f.writes, string(data))
+
+	return nil
+}
+
+func TestInfluxSink_flush(t *testing.T) {
+	cfg := NewDefaultInfluxSinkConfig()
+	cfg.BatchSize = 2
+	cfg.MaxRetries = 1
+	cfg.RetryBackoff = 0
+
+	w := &fakeLineWriter{failures: 1}
+	s := NewInfluxSink(cfg, w, nopwrap.NewNopWrapper())
+
+	// Одно устройство и одна комнатная env - две строки, то есть одна полная пачка
+	s.HandlePull(context.Background(), testPullResult())
+	s.flush(context.Background())
+
+	if len(w.writes) != 1 {
+		t.Fatalf("writes = %d, want 1", len(w.writes))
+	}
+
+	wantLines := []string{
+		"myheat_dev,city=Москва,id=10,name=Дом severity=32i,weather_temp=-5.5 1704067200000000000",
+		"myheat_env,device_id=10,id=1,name=Гостиная heat_demand=true,temp_current=21.25,temp_target=22 1704067200000000000",
+	}
+
+	if got := strings.TrimSpace(w.writes[0]); got != strings.Join(wantLines, "\n") {
+		t.Errorf("written data = %q, want %q", got, strings.Join(wantLines, "\n"))
+	}
+
+	if len(s.buffer) != 0 {
+		t.Errorf("buffer size = %d, want 0", len(s.buffer))
+	}
+}
+
+func TestInfluxSink_flushFailureKeepsBuffer(t *testing.T) {
+	cfg := NewDefaultInfluxSinkConfig()
+	cfg.BatchSize = 2
+	cfg.MaxBufferSize = 3
+	cfg.MaxRetries = 0
+
+	w := &fakeLineWriter{failures: 1}
+	s := NewInfluxSink(cfg, w, nopwrap.NewNopWrapper())
+
+	s.HandlePull(context.Background(), testPullResult())
+	s.flush(context.Background())
+
+	if len(s.buffer) != 2 {
+		t.Fatalf("buffer size = %d, want 2", len(s.buffer))
+	}
+
+	// Буфер ограничен MaxBufferSize, самые старые строки отбрасываются
+	s.HandlePull(context.Background(), testPullResult())
+
+	if len(s.buffer) != 3 {
+		t.Fatalf("buffer size = %d, want 3", len(s.buffer))
+	}
+
+	s.flush(context.Background())
+
+	if len(w.writes) != 2 || len(s.buffer) != 0 {
+		t.Errorf("writes = %d, buffer size = %d, want 2 and 0", len(w.writes), len(s.buffer))
+	}
+}
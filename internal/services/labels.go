@@ -0,0 +1,223 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// NameFormat как имя устройства или помещения из приложения MyHeat превращается в значение лейбла name
+type NameFormat string
+
+const (
+	// NameFormatRaw имя публикуется как есть
+	NameFormatRaw NameFormat = "raw"
+	// NameFormatTranslit кириллица заменяется латиницей: "Детская 2" - "Detskaya 2"
+	NameFormatTranslit NameFormat = "translit"
+	// NameFormatSlug транслитерация, нижний регистр и дефисы вместо пробелов и знаков: "Детская 2" - "detskaya-2"
+	NameFormatSlug NameFormat = "slug"
+)
+
+// reservedLabels лейблы, которые экспортер задает сам
+var reservedLabels = map[string]bool{"id": true, "name": true, "city": true, "tariff": true}
+
+// LabelsConfig настройка лейблов метрик устройств и помещений. Загружается из YAML-файла
+type LabelsConfig struct {
+	NameFormat NameFormat    `yaml:"name_format"`
+	Devices    []LabelsEntry `yaml:"devices"`
+	Envs       []LabelsEntry `yaml:"envs"`
+}
+
+// LabelsEntry лейблы устройства или помещения с заданным ID
+type LabelsEntry struct {
+	ID int64 `yaml:"id"`
+	// Alias постоянное значение лейбла name. С ним переименование в приложении не создает новые временные ряды.
+	// К алиасу NameFormat не применяется
+	Alias string `yaml:"alias"`
+	// Labels дополнительные лейблы, например, floor, zone, building. Устройства и помещения, для которых лейбл
+	// не задан, получают пустое значение
+	Labels map[string]string `yaml:"labels"`
+}
+
+func LoadLabelsConfig(path string) (LabelsConfig, error) {
+	cfg := LabelsConfig{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err = yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c LabelsConfig) Validate() error {
+	switch c.NameFormat {
+	case "", NameFormatRaw, NameFormatTranslit, NameFormatSlug:
+	default:
+		return fmt.Errorf("unknown name format %q, expected %q, %q or %q", c.NameFormat, NameFormatRaw, NameFormatTranslit, NameFormatSlug)
+	}
+
+	if err := validateLabelsEntries(c.Devices); err != nil {
+		return fmt.Errorf("devices: %w", err)
+	}
+
+	if err := validateLabelsEntries(c.Envs); err != nil {
+		return fmt.Errorf("envs: %w", err)
+	}
+
+	return nil
+}
+
+func validateLabelsEntries(entries []LabelsEntry) error {
+	seen := make(map[int64]bool, len(entries))
+
+	for _, e := range entries {
+		if seen[e.ID] {
+			return fmt.Errorf("duplicate id %d", e.ID)
+		}
+
+		seen[e.ID] = true
+
+		for name := range e.Labels {
+			if !model.LabelName(name).IsValid() || strings.HasPrefix(name, "__") {
+				return fmt.Errorf("id %d: invalid label name %s", e.ID, strconv.Quote(name))
+			}
+
+			if reservedLabels[name] {
+				return fmt.Errorf("id %d: label %s is set by exporter", e.ID, strconv.Quote(name))
+			}
+		}
+	}
+
+	return nil
+}
+
+func newLabelMapper(format NameFormat, entries []LabelsEntry) labelMapper {
+	m := labelMapper{
+		format:  format,
+		entries: make(map[int64]LabelsEntry, len(entries)),
+	}
+
+	names := make(map[string]bool)
+
+	for _, e := range entries {
+		m.entries[e.ID] = e
+
+		for name := range e.Labels {
+			names[name] = true
+		}
+	}
+
+	for name := range names {
+		m.extra = append(m.extra, name)
+	}
+
+	sort.Strings(m.extra)
+
+	return m
+}
+
+// labelMapper формирует лейблы метрик устройств или помещений по LabelsConfig
+type labelMapper struct {
+	format  NameFormat
+	entries map[int64]LabelsEntry
+	// extra имена дополнительных лейблов всех устройств или помещений
+	extra []string
+}
+
+// labelNames возвращает имена лейблов метрики: base и дополнительные
+func (l labelMapper) labelNames(base ...string) []string {
+	return append(base, l.extra...)
+}
+
+// labels возвращает лейблы id, name и дополнительные лейблы
+func (l labelMapper) labels(id int64, name string) map[string]string {
+	entry := l.entries[id]
+
+	labels := make(map[string]string, len(l.extra)+2)
+	for _, k := range l.extra {
+		labels[k] = entry.Labels[k]
+	}
+
+	labels["id"] = strconv.FormatInt(id, 10)
+	labels["name"] = entry.Alias
+
+	if entry.Alias == "" {
+		labels["name"] = formatName(l.format, name)
+	}
+
+	return labels
+}
+
+func formatName(format NameFormat, name string) string {
+	switch format {
+	case NameFormatTranslit:
+		return transliterate(name)
+	case NameFormatSlug:
+		return slugify(name)
+	default:
+		return name
+	}
+}
+
+var translitTable = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// transliterate заменяет русские буквы латинскими, сохраняя регистр первой буквы
+func transliterate(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		lat, ok := translitTable[unicode.ToLower(r)]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+
+		if unicode.IsUpper(r) && lat != "" {
+			lat = strings.ToUpper(lat[:1]) + lat[1:]
+		}
+
+		b.WriteString(lat)
+	}
+
+	return b.String()
+}
+
+// slugify транслитерирует имя, приводит его к нижнему регистру и заменяет остальные символы дефисами
+func slugify(s string) string {
+	var b strings.Builder
+
+	dash := false
+
+	for _, r := range strings.ToLower(transliterate(s)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+
+			b.WriteRune(r)
+			dash = false
+
+			continue
+		}
+
+		dash = true
+	}
+
+	return b.String()
+}
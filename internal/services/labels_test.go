@@ -0,0 +1,122 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLoadLabelsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name: "корректная конфигурация",
+			data: `
+name_format: slug
+devices:
+  - id: 10
+    labels:
+      building: main
+envs:
+  - id: 1
+    alias: living_room
+    labels:
+      floor: "1"
+      zone: day
+`,
+		},
+		{
+			name:    "неизвестный формат имени",
+			data:    "name_format: upper\n",
+			wantErr: true,
+		},
+		{
+			name:    "повторяющийся id",
+			data:    "envs:\n  - id: 1\n  - id: 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "некорректное имя лейбла",
+			data:    "envs:\n  - id: 1\n    labels:\n      этаж: \"1\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "зарезервированный лейбл",
+			data:    "devices:\n  - id: 10\n    labels:\n      city: Москва\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "labels.yml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadLabelsConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadLabelsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	tests := []struct {
+		name   string
+		format NameFormat
+		in     string
+		want   string
+	}{
+		{name: "без изменений", format: NameFormatRaw, in: "Детская 2", want: "Детская 2"},
+		{name: "транслитерация", format: NameFormatTranslit, in: "Детская 2", want: "Detskaya 2"},
+		{name: "транслитерация многобуквенных", format: NameFormatTranslit, in: "Щитовая, Ёлка", want: "Shchitovaya, Elka"},
+		{name: "слаг", format: NameFormatSlug, in: "  Детская №2 (мансарда) ", want: "detskaya-2-mansarda"},
+		{name: "слаг латиницы", format: NameFormatSlug, in: "Boiler Room", want: "boiler-room"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatName(tt.format, tt.in); got != tt.want {
+				t.Errorf("formatName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics_Labels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(nopwrap.NewNopWrapper(), NewTariffSelector(time.Now, nil), LabelsConfig{
+		NameFormat: NameFormatSlug,
+		Devices: []LabelsEntry{
+			{ID: 10, Labels: map[string]string{"building": "main"}},
+		},
+		Envs: []LabelsEntry{
+			{ID: 1, Alias: "living_room", Labels: map[string]string{"floor": "1", "zone": "day"}},
+		},
+	}, reg)
+
+	m.SetEnvironmentTempCurrent(1, "Гостиная (новая)", 21)
+	m.SetEnvironmentTempCurrent(3, "Спальня", 19)
+	m.SetDeviceWeatherTemp(10, "Дом", "Москва", -5)
+	m.SetDeviceSeverity(20, "Дача", 1, "")
+
+	body := exporterTestEnv{reg: reg}.scrape(t)
+
+	assertMetrics(t, body, []string{
+		`myheat_env_temp_current{floor="1",id="1",name="living_room",zone="day"} 21`,
+		`myheat_env_temp_current{floor="",id="3",name="spalnya",zone=""} 19`,
+		`myheat_dev_weather_temp{building="main",city="Москва",id="10",name="dom"} -5`,
+		`myheat_dev_severity{building="",id="20",name="dacha"} 1`,
+	}, []string{
+		"Гостиная",
+	})
+}
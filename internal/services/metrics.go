@@ -20,40 +20,67 @@ const (
 
 	metricNameDeviceWeatherTemp = "myheat_dev_weather_temp"
 	metricNameDeviceSeverity    = "myheat_dev_severity"
+	metricNameDeviceDataActual  = "myheat_dev_data_actual"
+
+	metricNameExporterPulls              = "myheat_exporter_pulls_total"
+	metricNameExporterPullErrors         = "myheat_exporter_pull_errors_total"
+	metricNameExporterLastSuccessfulPull = "myheat_exporter_last_successful_pull_timestamp_seconds"
 )
 
-func NewMetrics(logger wdlogger.Logger, ts *TariffSelector) *Metrics {
+// metricsNames метрики, которые регистрирует NewMetrics
+var metricsNames = []string{
+	metricNameEnvTempCurrent,
+	metricNameEnvTempTarget,
+	metricNameEnvHeatDemand,
+	metricNameEnvHeatDemandSeconds,
+	metricNameEnvHeatTariffSeconds,
+	metricNameDeviceWeatherTemp,
+	metricNameDeviceSeverity,
+	metricNameDeviceDataActual,
+	metricNameExporterPulls,
+	metricNameExporterPullErrors,
+	metricNameExporterLastSuccessfulPull,
+}
+
+// NewMetrics регистрирует метрики в reg. В приложении используется prometheus.DefaultRegisterer.
+// labels задает алиасы и дополнительные лейблы устройств и помещений, конфигурация должна быть проверена Validate
+func NewMetrics(logger wdlogger.Logger, ts *TariffSelector, labels LabelsConfig, reg prometheus.Registerer) *Metrics {
+	factory := promauto.With(reg)
+
+	envLabels := newLabelMapper(labels.NameFormat, labels.Envs)
+	devLabels := newLabelMapper(labels.NameFormat, labels.Devices)
+
 	// Environment current temperature
 	envTempCurrOpts := prometheus.GaugeOpts{
 		Name: metricNameEnvTempCurrent,
 		Help: "Температура помещения в данный момент",
 	}
-	envTempCurrLabels := []string{"id", "name"}
-	envTempCurrMetric := promauto.NewGaugeVec(envTempCurrOpts, envTempCurrLabels)
+	envTempCurrLabels := envLabels.labelNames("id", "name")
+	envTempCurrMetric := factory.NewGaugeVec(envTempCurrOpts, envTempCurrLabels)
 
 	// Environment target temperature
 	envTempTargetOpts := prometheus.GaugeOpts{
 		Name: metricNameEnvTempTarget,
 		Help: "Целевая температура помещения",
 	}
-	envTempTargetLabels := []string{"id", "name"}
-	envTempTargetMetric := promauto.NewGaugeVec(envTempTargetOpts, envTempTargetLabels)
+	envTempTargetLabels := envLabels.labelNames("id", "name")
+	envTempTargetMetric := factory.NewGaugeVec(envTempTargetOpts, envTempTargetLabels)
 
 	// Env heat demand
 	envHeatDemandOpts := prometheus.GaugeOpts{
 		Name: metricNameEnvHeatDemand,
 		Help: "Запрошен нагрев для достижения целевой температуры",
 	}
-	envHeatDemandLabels := []string{"id", "name"}
-	envHeatDemandMetric := promauto.NewGaugeVec(envHeatDemandOpts, envHeatDemandLabels)
+	envHeatDemandLabels := envLabels.labelNames("id", "name")
+	envHeatDemandMetric := factory.NewGaugeVec(envHeatDemandOpts, envHeatDemandLabels)
 
 	// Env heat demand seconds
 	envHeatDemandSecondsOpts := prometheus.CounterOpts{
 		Name: metricNameEnvHeatDemandSeconds,
 		Help: "Подсчитывает время, в течение которого запрошен нагрев",
 	}
-	envHeatDemandSecondsLabels := []string{"id", "name"}
-	envHeatDemandSecondsMetric := promauto.NewCounterVec(envHeatDemandSecondsOpts, envHeatDemandSecondsLabels)
+	envHeatDemandSecondsLabels := envLabels.labelNames("id", "name")
+	envHeatDemandSecondsMetric := factory.NewCounterVec(envHeatDemandSecondsOpts, envHeatDemandSecondsLabels)
 
 	// Env heat tariff seconds
 	envHeatTariffSecondsOpts := prometheus.CounterOpts{
@@ -61,27 +88,51 @@ func NewMetrics(logger wdlogger.Logger, ts *TariffSelector) *Metrics {
 		Help: "Подсчитывает время нагрева для разных тарифов",
 	}
 	envHeatTariffSecondsLabels := []string{"id", "tariff"}
-	envHeatTariffSecondsMetric := promauto.NewCounterVec(envHeatTariffSecondsOpts, envHeatTariffSecondsLabels)
+	envHeatTariffSecondsMetric := factory.NewCounterVec(envHeatTariffSecondsOpts, envHeatTariffSecondsLabels)
 
 	// Device weather temperature
 	deviceWeatherTempOpts := prometheus.GaugeOpts{
 		Name: metricNameDeviceWeatherTemp,
 		Help: "Температура на улице",
 	}
-	deviceWeatherTempLabels := []string{"id", "name", "city"}
-	deviceWeatherTempMetric := promauto.NewGaugeVec(deviceWeatherTempOpts, deviceWeatherTempLabels)
+	deviceWeatherTempLabels := devLabels.labelNames("id", "name", "city")
+	deviceWeatherTempMetric := factory.NewGaugeVec(deviceWeatherTempOpts, deviceWeatherTempLabels)
 
 	// Severity
 	deviceSeverityOpts := prometheus.GaugeOpts{
 		Name: metricNameDeviceSeverity,
 		Help: "Состояние устройства",
 	}
-	deviceSeverityLabels := []string{"id", "name"}
-	deviceSeverityMetric := promauto.NewGaugeVec(deviceSeverityOpts, deviceSeverityLabels)
+	deviceSeverityLabels := devLabels.labelNames("id", "name")
+	deviceSeverityMetric := factory.NewGaugeVec(deviceSeverityOpts, deviceSeverityLabels)
+
+	// Data actual
+	deviceDataActualOpts := prometheus.GaugeOpts{
+		Name: metricNameDeviceDataActual,
+		Help: "Данные устройства актуальны",
+	}
+	deviceDataActualLabels := devLabels.labelNames("id", "name")
+	deviceDataActualMetric := factory.NewGaugeVec(deviceDataActualOpts, deviceDataActualLabels)
+
+	// Exporter health
+	exporterPullsMetric := factory.NewCounter(prometheus.CounterOpts{
+		Name: metricNameExporterPulls,
+		Help: "Количество опросов MyHeat API",
+	})
+	exporterPullErrorsMetric := factory.NewCounter(prometheus.CounterOpts{
+		Name: metricNameExporterPullErrors,
+		Help: "Количество неудачных опросов MyHeat API",
+	})
+	exporterLastSuccessfulPullMetric := factory.NewGauge(prometheus.GaugeOpts{
+		Name: metricNameExporterLastSuccessfulPull,
+		Help: "Время последнего успешного опроса MyHeat API",
+	})
 
 	return &Metrics{
 		logger:         logger,
 		tariffSelector: ts,
+		envLabels:      envLabels,
+		devLabels:      devLabels,
 
 		envTempCurrMetric:          envTempCurrMetric,
 		envTempTargetMetric:        envTempTargetMetric,
@@ -91,12 +142,19 @@ func NewMetrics(logger wdlogger.Logger, ts *TariffSelector) *Metrics {
 		envHeatDemandSecondsState:  make(map[int64]envHeatDemandState),
 		deviceWeatherTempMetric:    deviceWeatherTempMetric,
 		deviceSeverityMetric:       deviceSeverityMetric,
+		deviceDataActualMetric:     deviceDataActualMetric,
+
+		exporterPullsMetric:              exporterPullsMetric,
+		exporterPullErrorsMetric:         exporterPullErrorsMetric,
+		exporterLastSuccessfulPullMetric: exporterLastSuccessfulPullMetric,
 	}
 }
 
 type Metrics struct {
 	logger         wdlogger.Logger
 	tariffSelector *TariffSelector
+	envLabels      labelMapper
+	devLabels      labelMapper
 
 	envTempCurrMetric          *prometheus.GaugeVec
 	envTempTargetMetric        *prometheus.GaugeVec
@@ -106,6 +164,11 @@ type Metrics struct {
 
 	deviceWeatherTempMetric *prometheus.GaugeVec
 	deviceSeverityMetric    *prometheus.GaugeVec
+	deviceDataActualMetric  *prometheus.GaugeVec
+
+	exporterPullsMetric              prometheus.Counter
+	exporterPullErrorsMetric         prometheus.Counter
+	exporterLastSuccessfulPullMetric prometheus.Gauge
 
 	envHeatDemandSecondsStateMu sync.RWMutex
 	envHeatDemandSecondsState   map[int64]envHeatDemandState
@@ -141,14 +204,6 @@ func (m *Metrics) Run(ctx context.Context) {
 	}
 }
 
-// Дефолтные лейблы для большинства метрик
-func defaultLabels(id int64, name string) map[string]string {
-	return map[string]string{
-		"id":   strconv.FormatInt(id, 10),
-		"name": name,
-	}
-}
-
 func copyLabels(m map[string]string) map[string]string {
 	out := make(map[string]string, len(m))
 
@@ -181,7 +236,7 @@ func (m *Metrics) SetEnvironmentTempCurrent(id int64, name string, value float64
 		wdlogger.NewFloat64Field("value", value),
 	)
 
-	labels := defaultLabels(id, name)
+	labels := m.envLabels.labels(id, name)
 	m.envTempCurrMetric.With(labels).Set(value)
 }
 
@@ -194,7 +249,7 @@ func (m *Metrics) SetEnvironmentTempTarget(id int64, name string, value float64)
 		wdlogger.NewFloat64Field("value", value),
 	)
 
-	labels := defaultLabels(id, name)
+	labels := m.envLabels.labels(id, name)
 	m.envTempTargetMetric.With(labels).Set(value)
 }
 
@@ -214,7 +269,7 @@ func (m *Metrics) SetEnvironmentHeatDemand(id int64, name string, value bool) {
 		wdlogger.NewBoolField("value", value),
 	)
 
-	labels := defaultLabels(id, name)
+	labels := m.envLabels.labels(id, name)
 	m.envHeatDemandMetric.With(labels).Set(boolToFloat64(value))
 }
 
@@ -228,14 +283,38 @@ func (m *Metrics) SetDeviceWeatherTemp(id int64, name string, city string, value
 		wdlogger.NewFloat64Field("value", value),
 	)
 
-	labels := map[string]string{
-		"id":   strconv.FormatInt(id, 10),
-		"name": name,
-		"city": city,
-	}
+	labels := m.devLabels.labels(id, name)
+	labels["city"] = city
 	m.deviceWeatherTempMetric.With(labels).Set(value)
 }
 
+// DeleteDeviceWeatherTemp удаляет уличную температуру устройства, если она неизвестна, чтобы не публиковать
+// вместо нее старое значение
+func (m *Metrics) DeleteDeviceWeatherTemp(id int64, name string, city string) {
+	labels := m.devLabels.labels(id, name)
+	labels["city"] = city
+	m.deviceWeatherTempMetric.Delete(labels)
+}
+
+// DeleteEnvironmentTempCurrent удаляет температуру помещения, если датчик ее не передал
+func (m *Metrics) DeleteEnvironmentTempCurrent(id int64, name string) {
+	m.envTempCurrMetric.Delete(m.envLabels.labels(id, name))
+}
+
+// DeleteEnvironmentTempTarget удаляет целевую температуру помещения, если MyHeat ее не передал
+func (m *Metrics) DeleteEnvironmentTempTarget(id int64, name string) {
+	m.envTempTargetMetric.Delete(m.envLabels.labels(id, name))
+}
+
+// DeleteEnvironmentReadings удаляет показания помещения, например, пока данные устройства неактуальны
+func (m *Metrics) DeleteEnvironmentReadings(id int64, name string) {
+	labels := m.envLabels.labels(id, name)
+
+	m.envTempCurrMetric.Delete(labels)
+	m.envTempTargetMetric.Delete(labels)
+	m.envHeatDemandMetric.Delete(labels)
+}
+
 func (m *Metrics) SetDeviceSeverity(id int64, name string, value int64, desc string) {
 	m.logger.Info(
 		"set",
@@ -246,12 +325,63 @@ func (m *Metrics) SetDeviceSeverity(id int64, name string, value int64, desc str
 		wdlogger.NewStringField("desc", desc),
 	)
 
-	labels := defaultLabels(id, name)
+	labels := m.devLabels.labels(id, name)
 
-	m.deviceSeverityMetric.Reset()
 	m.deviceSeverityMetric.With(labels).Set(float64(value))
 }
 
+func (m *Metrics) SetDeviceDataActual(id int64, name string, value bool) {
+	m.logger.Info(
+		"set",
+		wdlogger.NewStringField("metric_name", metricNameDeviceDataActual),
+		wdlogger.NewInt64Field("id", id),
+		wdlogger.NewStringField("name", name),
+		wdlogger.NewBoolField("value", value),
+	)
+
+	m.deviceDataActualMetric.With(m.devLabels.labels(id, name)).Set(boolToFloat64(value))
+}
+
+// ObservePull учитывает результат опроса MyHeat API
+func (m *Metrics) ObservePull(t time.Time, err error) {
+	m.exporterPullsMetric.Inc()
+
+	if err != nil {
+		m.exporterPullErrorsMetric.Inc()
+		return
+	}
+
+	m.exporterLastSuccessfulPullMetric.Set(float64(t.Unix()))
+}
+
+// AddEnvHeatDemandSeconds увеличивает счетчик времени нагрева на seconds. Используется там, где
+// время нагрева подсчитывается не в Run, например, при разовых запусках
+func (m *Metrics) AddEnvHeatDemandSeconds(id int64, name string, seconds float64) {
+	m.logger.Info(
+		"add",
+		wdlogger.NewStringField("metric_name", metricNameEnvHeatDemandSeconds),
+		wdlogger.NewInt64Field("id", id),
+		wdlogger.NewStringField("name", name),
+		wdlogger.NewFloat64Field("value", seconds),
+	)
+
+	m.envHeatDemandSecondsMetric.With(m.envLabels.labels(id, name)).Add(seconds)
+}
+
+// AddEnvHeatTariffSeconds увеличивает счетчик времени нагрева в рамках тарифа на seconds
+func (m *Metrics) AddEnvHeatTariffSeconds(id int64, tariff TariffType, seconds float64) {
+	m.logger.Info(
+		"add",
+		wdlogger.NewStringField("metric_name", metricNameEnvHeatTariffSeconds),
+		wdlogger.NewInt64Field("id", id),
+		wdlogger.NewStringField("tariff", tariff.String()),
+		wdlogger.NewFloat64Field("value", seconds),
+	)
+
+	labels := map[string]string{"id": strconv.FormatInt(id, 10), "tariff": tariff.String()}
+	m.envHeatTariffSecondsMetric.With(labels).Add(seconds)
+}
+
 type envHeatDemandState struct {
 	labels map[string]string
 	value  bool
@@ -273,7 +403,7 @@ func (m *Metrics) CountEnvHeatDemandSeconds(id int64, name string, value bool) {
 	state, ok := m.envHeatDemandSecondsState[id]
 	if !ok {
 		state = envHeatDemandState{
-			labels: defaultLabels(id, name),
+			labels: m.envLabels.labels(id, name),
 		}
 	}
 
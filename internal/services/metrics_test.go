@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics_SetDeviceSeverity(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(nopwrap.NewNopWrapper(), NewTariffSelector(time.Now, nil), LabelsConfig{}, reg)
+
+	// Состояние одного устройства не должно удалять состояние других
+	m.SetDeviceSeverity(10, "Дом", 1, "")
+	m.SetDeviceSeverity(20, "Дача", 32, "Низкий баланс SIM-карты")
+	m.SetDeviceSeverity(10, "Дом", 32, "Низкий баланс SIM-карты")
+
+	assertMetrics(t, exporterTestEnv{reg: reg}.scrape(t), []string{
+		`myheat_dev_severity{id="10",name="Дом"} 32`,
+		`myheat_dev_severity{id="20",name="Дача"} 32`,
+	}, nil)
+}
@@ -0,0 +1,499 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTClientID        = "myheat-exporter"
+	defaultMQTTTopicPrefix     = "myheat"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTTimeout         = time.Second * 10
+
+	defaultMQTTTargetMin       = 5
+	defaultMQTTTargetMax       = 35
+	defaultMQTTTargetStep      = 0.5
+	defaultMQTTCommandInterval = time.Second * 10
+
+	mqttPayloadOnline  = "online"
+	mqttPayloadOffline = "offline"
+	mqttPayloadOn      = "ON"
+	mqttPayloadOff     = "OFF"
+
+	mqttQoS = 1
+)
+
+func NewDefaultMQTTConfig() MQTTConfig {
+	return MQTTConfig{
+		ClientID:        defaultMQTTClientID,
+		TopicPrefix:     defaultMQTTTopicPrefix,
+		DiscoveryPrefix: defaultMQTTDiscoveryPrefix,
+		Timeout:         defaultMQTTTimeout,
+		TargetMin:       defaultMQTTTargetMin,
+		TargetMax:       defaultMQTTTargetMax,
+		TargetStep:      defaultMQTTTargetStep,
+		CommandInterval: defaultMQTTCommandInterval,
+	}
+}
+
+type MQTTConfig struct {
+	// BrokerURL адрес брокера, например: tcp://localhost:1883, ssl://broker:8883, ws://broker:9001
+	BrokerURL string
+	Username  string
+	Password  string
+	ClientID  string
+	// TopicPrefix префикс топиков с состоянием устройств
+	TopicPrefix string
+	// DiscoveryPrefix префикс топиков Home Assistant MQTT Discovery
+	DiscoveryPrefix string
+	Timeout         time.Duration
+
+	// CommandsEnabled включает подписку на топики <prefix>/<device>/<env>/target/set для изменения целевой температуры
+	CommandsEnabled bool
+	TargetMin       float64
+	TargetMax       float64
+	TargetStep      float64
+	// CommandInterval минимальный интервал между командами для одного помещения
+	CommandInterval time.Duration
+}
+
+func (c MQTTConfig) Validate() error {
+	if c.BrokerURL == "" {
+		return errors.New("broker url cannot be empty")
+	}
+
+	if c.ClientID == "" {
+		return errors.New("client id cannot be empty")
+	}
+
+	if c.TopicPrefix == "" {
+		return errors.New("topic prefix cannot be empty")
+	}
+
+	if c.DiscoveryPrefix == "" {
+		return errors.New("discovery prefix cannot be empty")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive number")
+	}
+
+	if c.CommandsEnabled {
+		if c.TargetMin >= c.TargetMax {
+			return errors.New("target min must be less than target max")
+		}
+
+		if c.TargetStep <= 0 {
+			return errors.New("target step must be positive number")
+		}
+
+		if c.CommandInterval < 0 {
+			return errors.New("command interval cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+// EnvGoalSetter изменяет целевую температуру помещения
+type EnvGoalSetter interface {
+	SetEnvGoal(ctx context.Context, deviceID, envID int64, goal float64) error
+}
+
+// mqttClient подмножество методов mqtt.Client, которое используется сервисом
+type mqttClient interface {
+	Connect() mqtt.Token
+	Disconnect(quiesce uint)
+	IsConnectionOpen() bool
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+	Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token
+}
+
+// NewMQTTPublisher создает сервис публикации. goalSetter используется только при включенных командах
+func NewMQTTPublisher(cfg MQTTConfig, goalSetter EnvGoalSetter, l wdlogger.Logger) *MQTTPublisher {
+	p := &MQTTPublisher{
+		cfg:          cfg,
+		logger:       l,
+		goalSetter:   goalSetter,
+		timeNowFunc:  time.Now,
+		discovered:   make(map[string]struct{}),
+		lastCommands: make(map[string]time.Time),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.BrokerURL).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		// Команды обрабатываются с запросом к MyHeat API, поэтому не блокируем ими обработку остальных сообщений
+		SetOrderMatters(false).
+		SetWill(p.availabilityTopic(), mqttPayloadOffline, mqttQoS, true).
+		SetOnConnectHandler(func(mqtt.Client) { p.onConnect() }).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			l.Warn("mqtt connection lost", wdlogger.NewErrorField("error", err))
+		})
+
+	p.client = mqtt.NewClient(opts)
+
+	return p
+}
+
+// MQTTPublisher публикует состояние устройств в MQTT и конфигурацию для Home Assistant MQTT Discovery
+type MQTTPublisher struct {
+	cfg         MQTTConfig
+	logger      wdlogger.Logger
+	client      mqttClient
+	goalSetter  EnvGoalSetter
+	timeNowFunc func() time.Time
+
+	// discovered топики конфигураций discovery, которые уже опубликованы в текущем соединении
+	discoveredMu sync.Mutex
+	discovered   map[string]struct{}
+
+	// lastCommands время последней принятой команды для каждого помещения, используется для ограничения частоты
+	lastCommandsMu sync.Mutex
+	lastCommands   map[string]time.Time
+}
+
+func (p *MQTTPublisher) Run(ctx context.Context) {
+	p.logger.Info("mqtt publisher started", wdlogger.NewStringField("broker", p.cfg.BrokerURL))
+
+	// При SetConnectRetry(true) токен завершится только после успешного подключения, поэтому не ждем его
+	p.client.Connect()
+
+	<-ctx.Done()
+
+	p.logger.Info("received shutdown signal, disconnecting from mqtt broker")
+
+	if p.client.IsConnectionOpen() {
+		p.publish(p.availabilityTopic(), true, mqttPayloadOffline)
+	}
+
+	p.client.Disconnect(uint(p.cfg.Timeout.Milliseconds()))
+}
+
+func (p *MQTTPublisher) onConnect() {
+	p.logger.Info("connected to mqtt broker")
+
+	// После переподключения брокер мог потерять retained-сообщения, поэтому публикуем discovery заново
+	p.discoveredMu.Lock()
+	p.discovered = make(map[string]struct{})
+	p.discoveredMu.Unlock()
+
+	p.publish(p.availabilityTopic(), true, mqttPayloadOnline)
+
+	if p.cfg.CommandsEnabled {
+		topic := p.cfg.TopicPrefix + "/+/+/target/set"
+		token := p.client.Subscribe(topic, mqttQoS, func(_ mqtt.Client, msg mqtt.Message) {
+			p.handleTargetSet(msg.Topic(), msg.Payload())
+		})
+
+		if !token.WaitTimeout(p.cfg.Timeout) {
+			p.logger.Error("mqtt subscribe timeout", wdlogger.NewStringField("topic", topic))
+		} else if err := token.Error(); err != nil {
+			p.logger.Error("mqtt subscribe error", wdlogger.NewStringField("topic", topic), wdlogger.NewErrorField("error", err))
+		}
+	}
+}
+
+type mqttCommandResult struct {
+	Status string   `json:"status"`
+	Value  *float64 `json:"value,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// handleTargetSet обрабатывает команду изменения целевой температуры и публикует результат в <topic>/result
+func (p *MQTTPublisher) handleTargetSet(topic string, payload []byte) {
+	resultTopic := topic + "/result"
+
+	deviceID, envID, goal, err := p.parseTargetSet(topic, payload)
+
+	var release func()
+	if err == nil {
+		release, err = p.acquireCommandSlot(deviceID, envID)
+	}
+
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		err = p.goalSetter.SetEnvGoal(ctx, deviceID, envID, goal)
+		cancel()
+
+		// Неудачная команда не занимает интервал, ее можно сразу повторить
+		if err != nil {
+			release()
+		}
+	}
+
+	result := mqttCommandResult{Status: "ok", Value: &goal}
+
+	if err != nil {
+		p.logger.Warn("mqtt command rejected", wdlogger.NewStringField("topic", topic), wdlogger.NewErrorField("error", err))
+		result = mqttCommandResult{Status: "error", Error: err.Error()}
+	} else {
+		p.logger.Info(
+			"mqtt command applied",
+			wdlogger.NewInt64Field("device_id", deviceID),
+			wdlogger.NewInt64Field("env_id", envID),
+			wdlogger.NewFloat64Field("goal", goal),
+		)
+
+		// Новое значение придет со следующим опросом, но Home Assistant показываем его сразу
+		p.publish(p.envTopic(deviceID, envID, "target"), true, formatFloat(goal))
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		p.logger.Error("marshaling command result", wdlogger.NewErrorField("error", err))
+		return
+	}
+
+	p.publish(resultTopic, false, data)
+}
+
+func (p *MQTTPublisher) parseTargetSet(topic string, payload []byte) (deviceID, envID int64, goal float64, err error) {
+	// <prefix>/<device>/<env>/target/set
+	parts := strings.Split(strings.TrimPrefix(topic, p.cfg.TopicPrefix+"/"), "/")
+	if len(parts) != 4 {
+		return 0, 0, 0, fmt.Errorf("unexpected topic %q", topic)
+	}
+
+	deviceID, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parsing device id: %w", err)
+	}
+
+	envID, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parsing env id: %w", err)
+	}
+
+	goal, err = strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parsing target temperature: %w", err)
+	}
+
+	if goal < p.cfg.TargetMin || goal > p.cfg.TargetMax {
+		return 0, 0, 0, fmt.Errorf("target temperature %s is out of range [%s, %s]",
+			formatFloat(goal), formatFloat(p.cfg.TargetMin), formatFloat(p.cfg.TargetMax))
+	}
+
+	steps := (goal - p.cfg.TargetMin) / p.cfg.TargetStep
+	if math.Abs(steps-math.Round(steps)) > 1e-9 {
+		return 0, 0, 0, fmt.Errorf("target temperature %s does not match step %s", formatFloat(goal), formatFloat(p.cfg.TargetStep))
+	}
+
+	return deviceID, envID, goal, nil
+}
+
+// acquireCommandSlot проверяет, что с предыдущей команды для помещения прошло не меньше CommandInterval,
+// и занимает интервал. release возвращает время предыдущей команды, если новую выполнить не удалось
+func (p *MQTTPublisher) acquireCommandSlot(deviceID, envID int64) (release func(), err error) {
+	key := fmt.Sprintf("%d/%d", deviceID, envID)
+	now := p.timeNowFunc()
+
+	p.lastCommandsMu.Lock()
+	defer p.lastCommandsMu.Unlock()
+
+	last, ok := p.lastCommands[key]
+	if ok && now.Sub(last) < p.cfg.CommandInterval {
+		return nil, fmt.Errorf("too many commands, retry in %s", (p.cfg.CommandInterval - now.Sub(last)).Round(time.Second))
+	}
+
+	p.lastCommands[key] = now
+
+	release = func() {
+		p.lastCommandsMu.Lock()
+		defer p.lastCommandsMu.Unlock()
+
+		// Интервал мог занять другой вызов
+		if !p.lastCommands[key].Equal(now) {
+			return
+		}
+
+		if ok {
+			p.lastCommands[key] = last
+		} else {
+			delete(p.lastCommands, key)
+		}
+	}
+
+	return release, nil
+}
+
+func (p *MQTTPublisher) HandlePull(_ context.Context, res PullResult) {
+	if !p.client.IsConnectionOpen() {
+		p.logger.Warn("mqtt connection is not open, skipping publish")
+		return
+	}
+
+	for _, dev := range res.Devices {
+		p.publishDevice(dev)
+	}
+}
+
+func (p *MQTTPublisher) publishDevice(dev DeviceSnapshot) {
+	deviceID := dev.Device.ID
+
+	p.publishDiscovery(dev.Device, "sensor", "weather_temp", "Weather temperature", p.deviceTopic(deviceID, "weather_temp"), temperatureDiscovery)
+	p.publishDiscovery(dev.Device, "sensor", "severity", "Severity", p.deviceTopic(deviceID, "severity"), nil)
+
+	if dev.Info.WeatherTemp != nil {
+		p.publish(p.deviceTopic(deviceID, "weather_temp"), true, formatFloat(*dev.Info.WeatherTemp))
+	}
+
+	p.publish(p.deviceTopic(deviceID, "severity"), true, strconv.FormatInt(dev.Device.Severity, 10))
+
+	for _, env := range dev.Info.Envs {
+		if env.Type != domain.EnvTypeRoomTemperature {
+			continue
+		}
+
+		p.publishEnv(dev.Device, env)
+	}
+}
+
+func (p *MQTTPublisher) publishEnv(device domain.Device, env domain.Env) {
+	entityPrefix := "env_" + strconv.FormatInt(env.ID, 10) + "_"
+
+	currentTopic := p.envTopic(device.ID, env.ID, "current")
+	targetTopic := p.envTopic(device.ID, env.ID, "target")
+	demandTopic := p.envTopic(device.ID, env.ID, "demand")
+
+	p.publishDiscovery(device, "sensor", entityPrefix+"current", env.Name+" current temperature", currentTopic, temperatureDiscovery)
+	p.publishDiscovery(device, "sensor", entityPrefix+"target", env.Name+" target temperature", targetTopic, temperatureDiscovery)
+	p.publishDiscovery(device, "binary_sensor", entityPrefix+"demand", env.Name+" heat demand", demandTopic, demandDiscovery)
+
+	if p.cfg.CommandsEnabled {
+		p.publishDiscovery(device, "climate", entityPrefix+"climate", env.Name, "", func(cfg map[string]interface{}) {
+			// У climate нет единого state_topic, состояние собирается из нескольких топиков
+			delete(cfg, "state_topic")
+
+			cfg["modes"] = []string{"heat"}
+			cfg["current_temperature_topic"] = currentTopic
+			cfg["temperature_state_topic"] = targetTopic
+			cfg["temperature_command_topic"] = targetTopic + "/set"
+			cfg["action_topic"] = demandTopic
+			cfg["action_template"] = "{{ 'heating' if value == '" + mqttPayloadOn + "' else 'idle' }}"
+			cfg["min_temp"] = p.cfg.TargetMin
+			cfg["max_temp"] = p.cfg.TargetMax
+			cfg["temp_step"] = p.cfg.TargetStep
+			cfg["temperature_unit"] = "C"
+		})
+	}
+
+	if env.Value != nil {
+		p.publish(currentTopic, true, formatFloat(*env.Value))
+	}
+
+	if env.Target != nil {
+		p.publish(targetTopic, true, formatFloat(*env.Target))
+	}
+
+	p.publish(demandTopic, true, boolToMQTTPayload(env.Demand))
+}
+
+// publishDiscovery публикует конфигурацию сущности Home Assistant, если она еще не публиковалась в текущем соединении
+func (p *MQTTPublisher) publishDiscovery(
+	device domain.Device,
+	component, objectID, name, stateTopic string,
+	customize func(cfg map[string]interface{}),
+) {
+	nodeID := "myheat_" + strconv.FormatInt(device.ID, 10)
+	topic := strings.Join([]string{p.cfg.DiscoveryPrefix, component, nodeID, objectID, "config"}, "/")
+
+	p.discoveredMu.Lock()
+	_, ok := p.discovered[topic]
+	p.discovered[topic] = struct{}{}
+	p.discoveredMu.Unlock()
+
+	if ok {
+		return
+	}
+
+	cfg := map[string]interface{}{
+		"name":               name,
+		"unique_id":          nodeID + "_" + objectID,
+		"object_id":          nodeID + "_" + objectID,
+		"state_topic":        stateTopic,
+		"availability_topic": p.availabilityTopic(),
+		"device": map[string]interface{}{
+			"identifiers":  []string{nodeID},
+			"name":         device.Name,
+			"manufacturer": "MyHeat",
+		},
+	}
+
+	if customize != nil {
+		customize(cfg)
+	}
+
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		p.logger.Error("marshaling discovery config", wdlogger.NewErrorField("error", err))
+		return
+	}
+
+	p.publish(topic, true, payload)
+}
+
+func (p *MQTTPublisher) publish(topic string, retained bool, payload interface{}) {
+	token := p.client.Publish(topic, mqttQoS, retained, payload)
+
+	if !token.WaitTimeout(p.cfg.Timeout) {
+		p.logger.Error("mqtt publish timeout", wdlogger.NewStringField("topic", topic))
+		return
+	}
+
+	if err := token.Error(); err != nil {
+		p.logger.Error("mqtt publish error", wdlogger.NewStringField("topic", topic), wdlogger.NewErrorField("error", err))
+	}
+}
+
+func (p *MQTTPublisher) availabilityTopic() string {
+	return p.cfg.TopicPrefix + "/status"
+}
+
+func (p *MQTTPublisher) deviceTopic(deviceID int64, name string) string {
+	return fmt.Sprintf("%s/%d/%s", p.cfg.TopicPrefix, deviceID, name)
+}
+
+func (p *MQTTPublisher) envTopic(deviceID, envID int64, name string) string {
+	return fmt.Sprintf("%s/%d/%d/%s", p.cfg.TopicPrefix, deviceID, envID, name)
+}
+
+func temperatureDiscovery(cfg map[string]interface{}) {
+	cfg["device_class"] = "temperature"
+	cfg["state_class"] = "measurement"
+	cfg["unit_of_measurement"] = "°C"
+}
+
+func demandDiscovery(cfg map[string]interface{}) {
+	cfg["device_class"] = "heat"
+	cfg["payload_on"] = mqttPayloadOn
+	cfg["payload_off"] = mqttPayloadOff
+}
+
+func boolToMQTTPayload(v bool) string {
+	if v {
+		return mqttPayloadOn
+	}
+	return mqttPayloadOff
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
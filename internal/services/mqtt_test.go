@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+type fakeMQTTToken struct{}
+
+func (fakeMQTTToken) Wait() bool                     { return true }
+func (fakeMQTTToken) WaitTimeout(time.Duration) bool { return true }
+func (fakeMQTTToken) Done() <-chan struct{}          { ch := make(chan struct{}); close(ch); return ch }
+func (fakeMQTTToken) Error() error                   { return nil }
+
+type fakeMQTTMessage struct {
+	payload  string
+	retained bool
+}
+
+type fakeMQTTClient struct {
+	mu        sync.Mutex
+	published map[string]fakeMQTTMessage
+}
+
+func newFakeMQTTClient() *fakeMQTTClient {
+	return &fakeMQTTClient{published: make(map[string]fakeMQTTMessage)}
+}
+
+func (f *fakeMQTTClient) Connect() mqtt.Token    { return fakeMQTTToken{} }
+func (f *fakeMQTTClient) Disconnect(uint)        {}
+func (f *fakeMQTTClient) IsConnectionOpen() bool { return true }
+
+func (f *fakeMQTTClient) Subscribe(string, byte, mqtt.MessageHandler) mqtt.Token {
+	return fakeMQTTToken{}
+}
+
+func (f *fakeMQTTClient) Publish(topic string, _ byte, retained bool, payload interface{}) mqtt.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var s string
+
+	switch v := payload.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	}
+
+	f.published[topic] = fakeMQTTMessage{payload: s, retained: retained}
+
+	return fakeMQTTToken{}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func testPullResult() PullResult {
+	return PullResult{
+		Time: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Devices: []DeviceSnapshot{
+			{
+				Device: domain.Device{ID: 10, Name: "Дом", City: "Москва", Severity: 32},
+				Info: domain.DeviceInfo{
+					DataActual:  true,
+					WeatherTemp: floatPtr(-5.5),
+					Envs: []domain.Env{
+						{ID: 1, Name: "Гостиная", Type: domain.EnvTypeRoomTemperature, Value: floatPtr(21.25), Target: floatPtr(22), Demand: true},
+						{ID: 2, Name: "Котел", Type: "boiler_temperature", Value: floatPtr(60)},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMQTTPublisher_HandlePull(t *testing.T) {
+	client := newFakeMQTTClient()
+
+	p := NewMQTTPublisher(NewDefaultMQTTConfig(), nil, nopwrap.NewNopWrapper())
+	p.client = client
+
+	p.HandlePull(context.Background(), testPullResult())
+
+	wantState := map[string]string{
+		"myheat/10/weather_temp": "-5.5",
+		"myheat/10/severity":     "32",
+		"myheat/10/1/current":    "21.25",
+		"myheat/10/1/target":     "22",
+		"myheat/10/1/demand":     "ON",
+	}
+
+	for topic, want := range wantState {
+		got, ok := client.published[topic]
+		if !ok {
+			t.Errorf("topic %q was not published", topic)
+			continue
+		}
+
+		if got.payload != want {
+			t.Errorf("topic %q payload = %q, want %q", topic, got.payload, want)
+		}
+
+		if !got.retained {
+			t.Errorf("topic %q must be retained", topic)
+		}
+	}
+
+	if _, ok := client.published["myheat/10/2/current"]; ok {
+		t.Errorf("non room env must not be published")
+	}
+
+	discovery, ok := client.published["homeassistant/sensor/myheat_10/env_1_current/config"]
+	if !ok {
+		t.Fatalf("discovery config was not published")
+	}
+
+	cfg := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(discovery.payload), &cfg); err != nil {
+		t.Fatalf("unmarshaling discovery config: %v", err)
+	}
+
+	if cfg["state_topic"] != "myheat/10/1/current" {
+		t.Errorf("state_topic = %v, want %v", cfg["state_topic"], "myheat/10/1/current")
+	}
+
+	if cfg["unique_id"] != "myheat_10_env_1_current" {
+		t.Errorf("unique_id = %v, want %v", cfg["unique_id"], "myheat_10_env_1_current")
+	}
+
+	if _, ok := client.published["homeassistant/binary_sensor/myheat_10/env_1_demand/config"]; !ok {
+		t.Errorf("demand discovery config was not published")
+	}
+}
+
+type fakeEnvGoalSetter struct {
+	calls int
+	goal  float64
+	err   error
+}
+
+func (f *fakeEnvGoalSetter) SetEnvGoal(_ context.Context, _, _ int64, goal float64) error {
+	f.calls++
+	f.goal = goal
+
+	return f.err
+}
+
+func TestMQTTPublisher_handleTargetSet(t *testing.T) {
+	tests := []struct {
+		name       string
+		topic      string
+		payload    string
+		setterErr  error
+		wantStatus string
+		wantCalls  int
+	}{
+		{
+			name:       "корректная команда",
+			topic:      "myheat/10/1/target/set",
+			payload:    "22.5",
+			wantStatus: "ok",
+			wantCalls:  1,
+		},
+		{
+			name:       "выход за диапазон",
+			topic:      "myheat/10/1/target/set",
+			payload:    "40",
+			wantStatus: "error",
+		},
+		{
+			name:       "значение не кратно шагу",
+			topic:      "myheat/10/1/target/set",
+			payload:    "22.3",
+			wantStatus: "error",
+		},
+		{
+			name:       "не число",
+			topic:      "myheat/10/1/target/set",
+			payload:    "warm",
+			wantStatus: "error",
+		},
+		{
+			name:       "некорректный топик",
+			topic:      "myheat/abc/1/target/set",
+			payload:    "22",
+			wantStatus: "error",
+		},
+		{
+			name:       "ошибка API",
+			topic:      "myheat/10/1/target/set",
+			payload:    "22",
+			setterErr:  errors.New("server returned error"),
+			wantStatus: "error",
+			wantCalls:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewDefaultMQTTConfig()
+			cfg.CommandsEnabled = true
+
+			client := newFakeMQTTClient()
+			setter := &fakeEnvGoalSetter{err: tt.setterErr}
+
+			p := NewMQTTPublisher(cfg, setter, nopwrap.NewNopWrapper())
+			p.client = client
+
+			p.handleTargetSet(tt.topic, []byte(tt.payload))
+
+			if setter.calls != tt.wantCalls {
+				t.Errorf("SetEnvGoal calls = %d, want %d", setter.calls, tt.wantCalls)
+			}
+
+			result := mqttCommandResult{}
+			if err := json.Unmarshal([]byte(client.published[tt.topic+"/result"].payload), &result); err != nil {
+				t.Fatalf("unmarshaling result: %v", err)
+			}
+
+			if result.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q (error: %s)", result.Status, tt.wantStatus, result.Error)
+			}
+		})
+	}
+}
+
+func TestMQTTPublisher_handleTargetSet_RateLimit(t *testing.T) {
+	cfg := NewDefaultMQTTConfig()
+	cfg.CommandsEnabled = true
+	cfg.CommandInterval = time.Minute
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	setter := &fakeEnvGoalSetter{}
+	p := NewMQTTPublisher(cfg, setter, nopwrap.NewNopWrapper())
+	p.client = newFakeMQTTClient()
+	p.timeNowFunc = func() time.Time { return now }
+
+	p.handleTargetSet("myheat/10/1/target/set", []byte("21"))
+	p.handleTargetSet("myheat/10/1/target/set", []byte("22"))
+
+	if setter.calls != 1 {
+		t.Fatalf("SetEnvGoal calls = %d, want 1", setter.calls)
+	}
+
+	// Другое помещение не ограничивается
+	p.handleTargetSet("myheat/10/2/target/set", []byte("22"))
+
+	now = now.Add(time.Minute)
+	p.handleTargetSet("myheat/10/1/target/set", []byte("23"))
+
+	if setter.calls != 3 {
+		t.Fatalf("SetEnvGoal calls = %d, want 3", setter.calls)
+	}
+
+	if setter.goal != 23 {
+		t.Errorf("goal = %v, want 23", setter.goal)
+	}
+
+	// Неудачная команда не занимает интервал, но и не сбрасывает интервал предыдущей успешной
+	setter.err = errors.New("server returned error")
+	now = now.Add(time.Minute)
+	p.handleTargetSet("myheat/10/2/target/set", []byte("21"))
+
+	setter.err = nil
+	p.handleTargetSet("myheat/10/2/target/set", []byte("21"))
+
+	if setter.calls != 5 {
+		t.Fatalf("SetEnvGoal calls after failed command = %d, want 5", setter.calls)
+	}
+
+	setter.err = errors.New("server returned error")
+	p.handleTargetSet("myheat/10/2/target/set", []byte("20"))
+	p.handleTargetSet("myheat/10/2/target/set", []byte("20"))
+
+	if setter.calls != 5 {
+		t.Fatalf("SetEnvGoal calls within interval = %d, want 5", setter.calls)
+	}
+}
+
+// TestMQTTPublisher_Broker проверяет публикацию через настоящий брокер, например, локальный Mosquitto:
+// MYHEAT_TEST_MQTT_BROKER=tcp://localhost:1883 go test ./...
+func TestMQTTPublisher_Broker(t *testing.T) {
+	brokerURL := os.Getenv("MYHEAT_TEST_MQTT_BROKER")
+	if brokerURL == "" {
+		t.Skip("MYHEAT_TEST_MQTT_BROKER is not set")
+	}
+
+	cfg := NewDefaultMQTTConfig()
+	cfg.BrokerURL = brokerURL
+	cfg.TopicPrefix = "myheat-test"
+	cfg.ClientID = "myheat-exporter-test"
+
+	received := make(chan string, 1)
+
+	subOpts := mqtt.NewClientOptions().AddBroker(brokerURL).SetClientID("myheat-exporter-test-sub")
+	sub := mqtt.NewClient(subOpts)
+
+	if token := sub.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("connecting subscriber: %v", token.Error())
+	}
+	defer sub.Disconnect(0)
+
+	token := sub.Subscribe("myheat-test/10/1/current", mqttQoS, func(_ mqtt.Client, msg mqtt.Message) {
+		received <- string(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		t.Fatalf("subscribing: %v", token.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := NewMQTTPublisher(cfg, nil, nopwrap.NewNopWrapper())
+	go p.Run(ctx)
+
+	deadline := time.Now().Add(cfg.Timeout)
+	for !p.client.IsConnectionOpen() {
+		if time.Now().After(deadline) {
+			t.Fatalf("publisher did not connect to broker")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+
+	p.HandlePull(ctx, testPullResult())
+
+	select {
+	case got := <-received:
+		if got != "21.25" {
+			t.Errorf("payload = %q, want %q", got, "21.25")
+		}
+	case <-time.After(cfg.Timeout):
+		t.Fatalf("message was not received")
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	defaultPushgatewayJob = "myheat-exporter"
+	defaultOneShotMaxGap  = time.Hour
+)
+
+func NewDefaultOneShotConfig() OneShotConfig {
+	return OneShotConfig{
+		Job:       defaultPushgatewayJob,
+		MaxGap:    defaultOneShotMaxGap,
+		StaleData: StaleDataMark,
+	}
+}
+
+type OneShotConfig struct {
+	PushgatewayURL string
+	Username       string
+	Password       string
+	Job            string
+	// Account добавляется в ключ группировки, чтобы данные разных аккаунтов MyHeat не перезаписывали друг друга
+	Account string
+	// StateFile файл, в котором между запусками хранится состояние нагрева и накопленные счетчики.
+	// Если не задан, счетчики времени нагрева не отправляются
+	StateFile string
+	// MaxGap если с предыдущего запуска прошло больше, время между запусками не учитывается в счетчиках нагрева
+	MaxGap time.Duration
+	// StaleData что делать с показаниями устройства, данные которого неактуальны
+	StaleData StaleDataPolicy
+	// Labels алиасы и дополнительные лейблы устройств и помещений
+	Labels LabelsConfig
+}
+
+func (c OneShotConfig) Validate() error {
+	if c.PushgatewayURL == "" {
+		return errors.New("pushgateway url cannot be empty")
+	}
+
+	if c.Job == "" {
+		return errors.New("job cannot be empty")
+	}
+
+	if c.Account == "" {
+		return errors.New("account cannot be empty")
+	}
+
+	if c.MaxGap <= 0 {
+		return errors.New("max gap must be positive number")
+	}
+
+	if _, err := ParseStaleDataPolicy(string(c.StaleData)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func NewOneShot(cfg OneShotConfig, ts *TariffSelector, l wdlogger.Logger) *OneShot {
+	o := &OneShot{
+		cfg:            cfg,
+		logger:         l,
+		tariffSelector: ts,
+	}
+
+	o.pushFunc = o.pushToGateway
+
+	return o
+}
+
+// OneShot отправляет результат разового опроса MyHeat API в Prometheus Pushgateway, отдельной группой на каждое устройство.
+// Metrics.Run в этом режиме не работает, поэтому счетчики времени нагрева вычисляются по интервалу между запусками
+type OneShot struct {
+	cfg            OneShotConfig
+	logger         wdlogger.Logger
+	tariffSelector *TariffSelector
+	result         *PullResult
+
+	pushFunc func(ctx context.Context, g prometheus.Gatherer, grouping map[string]string) error
+}
+
+// oneShotState состояние, которое сохраняется между запусками
+type oneShotState struct {
+	Time time.Time                  `json:"time"`
+	Envs map[string]oneShotEnvState `json:"envs"`
+}
+
+type oneShotEnvState struct {
+	Demand        bool               `json:"demand"`
+	DemandSeconds float64            `json:"demandSeconds"`
+	TariffSeconds map[string]float64 `json:"tariffSeconds"`
+}
+
+func (o *OneShot) HandlePull(_ context.Context, res PullResult) {
+	o.result = &res
+}
+
+// Push отправляет данные последнего опроса. Должен вызываться после Exporter.Pull
+func (o *OneShot) Push(ctx context.Context) error {
+	if o.result == nil {
+		return errors.New("no data was pulled")
+	}
+
+	var state *oneShotState
+
+	if o.cfg.StateFile != "" {
+		prev, err := o.loadState()
+		if err != nil {
+			return fmt.Errorf("loading state: %w", err)
+		}
+
+		state = o.nextState(prev, *o.result)
+	}
+
+	var errs []error
+
+	for _, dev := range o.result.Devices {
+		if err := o.pushDevice(ctx, dev, state); err != nil {
+			errs = append(errs, fmt.Errorf("pushing device %d: %w", dev.Device.ID, err))
+		}
+	}
+
+	// Состояние сохраняется даже при ошибке отправки: счетчики в нем уже учитывают прошедшее время
+	// и будут отправлены при следующем запуске
+	if state != nil {
+		if err := o.saveState(*state); err != nil {
+			errs = append(errs, fmt.Errorf("saving state: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (o *OneShot) pushDevice(ctx context.Context, dev DeviceSnapshot, state *oneShotState) error {
+	reg := prometheus.NewRegistry()
+	m := NewMetrics(o.logger, o.tariffSelector, o.cfg.Labels, reg)
+
+	setDeviceMetrics(m, dev, o.cfg.StaleData)
+
+	if state != nil {
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			envState := state.Envs[strconv.FormatInt(env.ID, 10)]
+
+			m.AddEnvHeatDemandSeconds(env.ID, env.Name, envState.DemandSeconds)
+
+			for tariffRaw, seconds := range envState.TariffSeconds {
+				tariff, err := strconv.ParseInt(tariffRaw, 10, 64)
+				if err != nil {
+					return fmt.Errorf("parsing tariff: %w", err)
+				}
+
+				m.AddEnvHeatTariffSeconds(env.ID, TariffType(tariff), seconds)
+			}
+		}
+	}
+
+	grouping := map[string]string{
+		"account": o.cfg.Account,
+		"device":  strconv.FormatInt(dev.Device.ID, 10),
+	}
+
+	return o.pushFunc(ctx, reg, grouping)
+}
+
+func (o *OneShot) pushToGateway(ctx context.Context, g prometheus.Gatherer, grouping map[string]string) error {
+	pusher := push.New(o.cfg.PushgatewayURL, o.cfg.Job).Gatherer(g)
+
+	for name, value := range grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	if o.cfg.Username != "" {
+		pusher = pusher.BasicAuth(o.cfg.Username, o.cfg.Password)
+	}
+
+	return pusher.PushContext(ctx)
+}
+
+// nextState добавляет к накопленным счетчикам время между предыдущим и текущим запуском для помещений,
+// в которых на момент предыдущего запуска был запрошен нагрев и данные были актуальны
+func (o *OneShot) nextState(prev oneShotState, res PullResult) *oneShotState {
+	next := &oneShotState{
+		Time: res.Time,
+		Envs: make(map[string]oneShotEnvState),
+	}
+
+	elapsed := res.Time.Sub(prev.Time)
+	countElapsed := !prev.Time.IsZero() && elapsed > 0 && elapsed <= o.cfg.MaxGap
+
+	if !prev.Time.IsZero() && !countElapsed {
+		o.logger.Warn("interval since previous run is not counted", wdlogger.NewStringField("elapsed", elapsed.String()))
+	}
+
+	for _, dev := range res.Devices {
+		for _, env := range dev.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			id := strconv.FormatInt(env.ID, 10)
+
+			envState := prev.Envs[id]
+
+			// В файле состояния tariffSeconds может отсутствовать или быть null
+			if envState.TariffSeconds == nil {
+				envState.TariffSeconds = make(map[string]float64)
+			}
+
+			if countElapsed && envState.Demand {
+				envState.DemandSeconds += elapsed.Seconds()
+
+				for tariff, seconds := range o.tariffSelector.Split(prev.Time, res.Time) {
+					envState.TariffSeconds[tariff.String()] += seconds
+				}
+			}
+
+			// Пока данные неактуальны, время нагрева не считается
+			envState.Demand = env.Demand && dev.Info.DataActual
+			next.Envs[id] = envState
+		}
+	}
+
+	// Помещения, которых нет в этом опросе (не удалось получить данные устройства или помещение отфильтровано),
+	// сохраняют накопленные счетчики, иначе в Pushgateway они начнутся с нуля. Состояние нагрева неизвестно,
+	// поэтому время до следующего запуска не считается
+	for id, envState := range prev.Envs {
+		if _, ok := next.Envs[id]; ok {
+			continue
+		}
+
+		envState.Demand = false
+		next.Envs[id] = envState
+	}
+
+	return next
+}
+
+func (o *OneShot) loadState() (oneShotState, error) {
+	state := oneShotState{}
+
+	data, err := os.ReadFile(o.cfg.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	if err = json.Unmarshal(data, &state); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+func (o *OneShot) saveState(state oneShotState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы прерванный запуск не испортил состояние
+	tmp := o.cfg.StateFile + ".tmp"
+
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, o.cfg.StateFile)
+}
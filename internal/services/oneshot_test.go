@@ -0,0 +1,192 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestOneShot_Push(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies = map[string]string{}
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		// Pushgateway не зависит от порядка меток группировки в URL, а клиент перебирает их в случайном порядке
+		path := strings.Replace(r.URL.Path, "/device/10/account/user", "/account/user/device/10", 1)
+
+		mu.Lock()
+		bodies[r.Method+" "+path] = string(body)
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cfg := NewDefaultOneShotConfig()
+	cfg.PushgatewayURL = srv.URL
+	cfg.Account = "user"
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	// Ночной тариф с 22 до 7
+	ts := NewTariffSelector(time.Now, []Tariff{NewNightTariff(22, 7)})
+
+	// Первый запуск: счетчики еще не накоплены, запоминается состояние нагрева
+	first := testPullResult()
+	first.Time = time.Date(2024, time.January, 1, 21, 50, 0, 0, time.UTC)
+
+	o := NewOneShot(cfg, ts, nopwrap.NewNopWrapper())
+	o.HandlePull(context.Background(), first)
+
+	if err := o.Push(context.Background()); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	body, ok := bodies["PUT /metrics/job/myheat-exporter/account/user/device/10"]
+	if !ok {
+		t.Fatalf("push with expected grouping key was not received, got: %v", bodies)
+	}
+
+	if !strings.Contains(body, "myheat_env_temp_current") {
+		t.Errorf("pushed body does not contain env metrics")
+	}
+
+	// Второй запуск через 20 минут: нагрев был запрошен, 10 минут по дневному тарифу и 10 по ночному
+	second := testPullResult()
+	second.Time = first.Time.Add(20 * time.Minute)
+
+	o = NewOneShot(cfg, ts, nopwrap.NewNopWrapper())
+	o.HandlePull(context.Background(), second)
+
+	state, err := o.loadState()
+	if err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+
+	next := o.nextState(state, second)
+	env := next.Envs["1"]
+
+	if env.DemandSeconds != 1200 {
+		t.Errorf("DemandSeconds = %v, want 1200", env.DemandSeconds)
+	}
+
+	if env.TariffSeconds[TariffOne.String()] != 600 || env.TariffSeconds[TariffTwo.String()] != 600 {
+		t.Errorf("TariffSeconds = %v, want 600 for each tariff", env.TariffSeconds)
+	}
+
+	if err = o.Push(context.Background()); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	body = bodies["PUT /metrics/job/myheat-exporter/account/user/device/10"]
+	if !strings.Contains(body, "myheat_env_heat_demand_seconds_total") {
+		t.Errorf("pushed body does not contain heat demand counter")
+	}
+
+	// Пока данные неактуальны, время нагрева не считается
+	stale := testPullResult()
+	stale.Time = second.Time.Add(10 * time.Minute)
+	stale.Devices[0].Info.DataActual = false
+
+	staleState := o.nextState(*next, stale)
+
+	afterStale := testPullResult()
+	afterStale.Time = stale.Time.Add(10 * time.Minute)
+
+	if got := o.nextState(*staleState, afterStale).Envs["1"].DemandSeconds; got != 1800 {
+		t.Errorf("DemandSeconds after stale data = %v, want 1800", got)
+	}
+
+	// Слишком большой перерыв между запусками не учитывается
+	third := testPullResult()
+	third.Time = second.Time.Add(cfg.MaxGap + time.Minute)
+
+	state, _ = o.loadState()
+	if got := o.nextState(state, third).Envs["1"].DemandSeconds; got != 1200 {
+		t.Errorf("DemandSeconds after gap = %v, want 1200", got)
+	}
+}
+
+func TestOneShot_nextState_NullTariffSeconds(t *testing.T) {
+	cfg := NewDefaultOneShotConfig()
+	cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	res := testPullResult()
+	prevTime := res.Time.Add(-10 * time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		state string
+	}{
+		{name: "tariffSeconds равен null", state: `{"time":"` + prevTime + `","envs":{"1":{"demand":true,"tariffSeconds":null}}}`},
+		{name: "tariffSeconds отсутствует", state: `{"time":"` + prevTime + `","envs":{"1":{"demand":true}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(cfg.StateFile, []byte(tt.state), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			o := NewOneShot(cfg, NewTariffSelector(time.Now, nil), nopwrap.NewNopWrapper())
+
+			state, err := o.loadState()
+			if err != nil {
+				t.Fatalf("loadState() error = %v", err)
+			}
+
+			env := o.nextState(state, res).Envs["1"]
+
+			if env.DemandSeconds != 600 || env.TariffSeconds[TariffOne.String()] != 600 {
+				t.Errorf("env state = %+v, want 600 seconds of tariff 1", env)
+			}
+		})
+	}
+}
+
+func TestOneShot_nextState_MissingDevice(t *testing.T) {
+	o := NewOneShot(NewDefaultOneShotConfig(), NewTariffSelector(time.Now, nil), nopwrap.NewNopWrapper())
+
+	first := testPullResult()
+
+	second := testPullResult()
+	second.Time = first.Time.Add(10 * time.Minute)
+
+	// Данные устройства не удалось получить
+	missing := testPullResult()
+	missing.Time = second.Time.Add(10 * time.Minute)
+	missing.Devices = nil
+
+	back := testPullResult()
+	back.Time = missing.Time.Add(10 * time.Minute)
+
+	state := o.nextState(oneShotState{}, first)
+	state = o.nextState(*state, second)
+	state = o.nextState(*state, missing)
+
+	env, ok := state.Envs["1"]
+	if !ok {
+		t.Fatalf("env state was lost: %+v", state.Envs)
+	}
+
+	if env.DemandSeconds != 600 || env.TariffSeconds[TariffOne.String()] != 600 {
+		t.Errorf("env state = %+v, want 600 seconds of tariff 1", env)
+	}
+
+	// Пока устройства не было в опросе, время нагрева не считается
+	if got := o.nextState(*state, back).Envs["1"].DemandSeconds; got != 600 {
+		t.Errorf("DemandSeconds after missing pull = %v, want 600", got)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultRulesJob                 = "myheat"
+	defaultRulesExporterDownFor     = time.Minute * 5
+	defaultRulesPullFailingFor      = time.Minute * 10
+	defaultRulesRoomBelowTarget     = 2
+	defaultRulesRoomBelowTargetFor  = time.Minute * 30
+	defaultRulesLowBalanceFor       = time.Duration(0)
+	defaultRulesDataNotActualFor    = time.Minute * 15
+	prometheusRulesGroupName        = "myheat-exporter"
+	prometheusRulesSeverityCritical = "critical"
+	prometheusRulesSeverityWarning  = "warning"
+)
+
+func NewDefaultPrometheusRulesConfig() PrometheusRulesConfig {
+	return PrometheusRulesConfig{
+		Job:                defaultRulesJob,
+		ExporterDownFor:    defaultRulesExporterDownFor,
+		PullFailingFor:     defaultRulesPullFailingFor,
+		RoomBelowTarget:    defaultRulesRoomBelowTarget,
+		RoomBelowTargetFor: defaultRulesRoomBelowTargetFor,
+		LowBalanceFor:      defaultRulesLowBalanceFor,
+		DataNotActualFor:   defaultRulesDataNotActualFor,
+	}
+}
+
+// PrometheusRulesConfig пороги для правил оповещений Prometheus
+type PrometheusRulesConfig struct {
+	// Job имя задания Prometheus, которое собирает метрики экспортера
+	Job             string
+	ExporterDownFor time.Duration
+	// PullFailingFor сколько времени опросы MyHeat API могут завершаться ошибкой
+	PullFailingFor time.Duration
+	// RoomBelowTarget на сколько градусов температура помещения может быть ниже целевой
+	RoomBelowTarget    float64
+	RoomBelowTargetFor time.Duration
+	LowBalanceFor      time.Duration
+	DataNotActualFor   time.Duration
+}
+
+func (c PrometheusRulesConfig) Validate() error {
+	if c.Job == "" {
+		return errors.New("job cannot be empty")
+	}
+
+	if c.PullFailingFor <= 0 {
+		return errors.New("pull failing for must be positive number")
+	}
+
+	if c.RoomBelowTarget <= 0 {
+		return errors.New("room below target must be positive number")
+	}
+
+	for _, d := range []time.Duration{c.ExporterDownFor, c.RoomBelowTargetFor, c.LowBalanceFor, c.DataNotActualFor} {
+		if d < 0 {
+			return errors.New("durations cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+type prometheusRuleGroups struct {
+	Groups []prometheusRuleGroup `yaml:"groups"`
+}
+
+type prometheusRuleGroup struct {
+	Name  string           `yaml:"name"`
+	Rules []prometheusRule `yaml:"rules"`
+}
+
+type prometheusRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// GeneratePrometheusRules формирует файл правил оповещений Prometheus для метрик экспортера
+func GeneratePrometheusRules(cfg PrometheusRulesConfig) ([]byte, error) {
+	rules := []prometheusRule{
+		{
+			Alert:  "MyHeatExporterDown",
+			Expr:   fmt.Sprintf(`up{job=%q} == 0`, cfg.Job),
+			For:    formatRuleDuration(cfg.ExporterDownFor),
+			Labels: map[string]string{"severity": prometheusRulesSeverityCritical},
+			Annotations: map[string]string{
+				"summary": "Экспортер MyHeat недоступен",
+			},
+		},
+		{
+			Alert: "MyHeatPullFailing",
+			// До первого успешного опроса метрика равна нулю, поэтому правило срабатывает и тогда, когда опрос не удался ни разу
+			Expr:   fmt.Sprintf(`time() - %s{job=%q} > %s`, metricNameExporterLastSuccessfulPull, cfg.Job, formatFloat(cfg.PullFailingFor.Seconds())),
+			Labels: map[string]string{"severity": prometheusRulesSeverityCritical},
+			Annotations: map[string]string{
+				"summary":     "Опрос MyHeat API не удается",
+				"description": fmt.Sprintf("Последний успешный опрос MyHeat API был больше %s назад", formatRuleDuration(cfg.PullFailingFor)),
+			},
+		},
+		{
+			Alert:  "MyHeatRoomBelowTarget",
+			Expr:   fmt.Sprintf(`%s - %s > %s`, metricNameEnvTempTarget, metricNameEnvTempCurrent, formatFloat(cfg.RoomBelowTarget)),
+			For:    formatRuleDuration(cfg.RoomBelowTargetFor),
+			Labels: map[string]string{"severity": prometheusRulesSeverityWarning},
+			Annotations: map[string]string{
+				"summary":     "Помещение {{ $labels.name }} не прогревается",
+				"description": fmt.Sprintf("Температура ниже целевой больше чем на %s °C", formatFloat(cfg.RoomBelowTarget)),
+			},
+		},
+		{
+			Alert:  "MyHeatLowBalance",
+			Expr:   fmt.Sprintf(`%s == %d`, metricNameDeviceSeverity, domain.DevSeverityLowBalance),
+			For:    formatRuleDuration(cfg.LowBalanceFor),
+			Labels: map[string]string{"severity": prometheusRulesSeverityWarning},
+			Annotations: map[string]string{
+				"summary": "Низкий баланс SIM-карты контроллера {{ $labels.name }}",
+			},
+		},
+		{
+			Alert:  "MyHeatDataNotActual",
+			Expr:   fmt.Sprintf(`%s == 0`, metricNameDeviceDataActual),
+			For:    formatRuleDuration(cfg.DataNotActualFor),
+			Labels: map[string]string{"severity": prometheusRulesSeverityCritical},
+			Annotations: map[string]string{
+				"summary": "Данные контроллера {{ $labels.name }} неактуальны, возможно он не в сети",
+			},
+		},
+	}
+
+	return yaml.Marshal(prometheusRuleGroups{
+		Groups: []prometheusRuleGroup{{Name: prometheusRulesGroupName, Rules: rules}},
+	})
+}
+
+// formatRuleDuration форматирует длительность в формате Prometheus. Нулевая длительность опускается
+func formatRuleDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	return model.Duration(d).String()
+}
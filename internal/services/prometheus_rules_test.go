@@ -0,0 +1,46 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestGeneratePrometheusRules(t *testing.T) {
+	registered := MetricNames()
+
+	cfg := NewDefaultPrometheusRulesConfig()
+	cfg.RoomBelowTarget = 1.5
+
+	data, err := GeneratePrometheusRules(cfg)
+	if err != nil {
+		t.Fatalf("GeneratePrometheusRules() error = %v", err)
+	}
+
+	groups := prometheusRuleGroups{}
+	if err = yaml.UnmarshalStrict(data, &groups); err != nil {
+		t.Fatalf("generated rules are not valid yaml: %v", err)
+	}
+
+	rules := groups.Groups[0].Rules
+	if len(rules) != 5 {
+		t.Fatalf("rules = %d, want 5", len(rules))
+	}
+
+	// Все метрики экспортера, на которые ссылаются правила, должны быть зарегистрированы
+	metricRe := regexp.MustCompile(`myheat_[a-z_]+`)
+
+	for _, r := range rules {
+		for _, name := range metricRe.FindAllString(r.Expr, -1) {
+			if !registered[name] {
+				t.Errorf("rule %s uses unknown metric %s", r.Alert, name)
+			}
+		}
+	}
+
+	if !strings.Contains(rules[2].Expr, "> 1.5") || rules[2].For != "30m" {
+		t.Errorf("room below target rule does not use config: %+v", rules[2])
+	}
+}
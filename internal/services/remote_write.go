@@ -0,0 +1,377 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/remotewrite"
+	"github.com/denistv/wdlogger"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	defaultRemoteWriteInterval    = time.Second * 30
+	defaultRemoteWriteMaxSegments = 10000
+	defaultRemoteWriteTimeout     = time.Second * 30
+	defaultRemoteWriteJob         = "myheat-exporter"
+
+	walSegmentExt = ".rw"
+)
+
+func NewDefaultRemoteWriterConfig() RemoteWriterConfig {
+	return RemoteWriterConfig{
+		Interval:    defaultRemoteWriteInterval,
+		MaxSegments: defaultRemoteWriteMaxSegments,
+		Timeout:     defaultRemoteWriteTimeout,
+		Job:         defaultRemoteWriteJob,
+	}
+}
+
+type RemoteWriterConfig struct {
+	Interval time.Duration
+	// WALDir каталог, где хранятся неотправленные данные. Если не задан, данные хранятся только в памяти
+	WALDir string
+	// MaxSegments максимальное число неотправленных запросов. При переполнении самые старые отбрасываются
+	MaxSegments int
+	Timeout     time.Duration
+	// Job и Instance добавляются к каждому ряду как метки job и instance, аналогично тому, как это делает Prometheus при сборе
+	Job      string
+	Instance string
+	// ExternalLabels дополнительные метки для всех рядов
+	ExternalLabels map[string]string
+}
+
+func (c RemoteWriterConfig) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("interval must be positive number")
+	}
+
+	if c.MaxSegments <= 0 {
+		return errors.New("max segments must be positive number")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive number")
+	}
+
+	return nil
+}
+
+// RemoteWriteSender отправляет подготовленный remotewrite.Encode запрос
+type RemoteWriteSender interface {
+	Send(ctx context.Context, data []byte) error
+}
+
+func NewRemoteWriter(cfg RemoteWriterConfig, g prometheus.Gatherer, sender RemoteWriteSender, l wdlogger.Logger) *RemoteWriter {
+	return &RemoteWriter{
+		cfg:         cfg,
+		logger:      l,
+		gatherer:    g,
+		sender:      sender,
+		timeNowFunc: time.Now,
+	}
+}
+
+// RemoteWriter периодически собирает метрики из реестра и отправляет их по протоколу remote_write.
+// Пока эндпоинт недоступен, запросы копятся в очереди и отправляются по порядку после восстановления связи
+type RemoteWriter struct {
+	cfg         RemoteWriterConfig
+	logger      wdlogger.Logger
+	gatherer    prometheus.Gatherer
+	sender      RemoteWriteSender
+	timeNowFunc func() time.Time
+
+	queueMu sync.Mutex
+	queue   []walSegment
+}
+
+// walSegment неотправленный запрос. path пустой, если WAL на диске не используется
+type walSegment struct {
+	path string
+	data []byte
+}
+
+func (w *RemoteWriter) Run(ctx context.Context) {
+	w.logger.Info("remote writer started")
+
+	if err := w.loadWAL(); err != nil {
+		w.logger.Error("loading remote write wal", wdlogger.NewErrorField("error", err))
+	}
+
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.logger.Info("received shutdown signal, exiting")
+			return
+		case <-ticker.C:
+			if err := w.collect(); err != nil {
+				w.logger.Error("collecting metrics for remote write", wdlogger.NewErrorField("error", err))
+			}
+
+			w.send(ctx)
+		}
+	}
+}
+
+// collect собирает текущие значения метрик и ставит запрос в очередь
+func (w *RemoteWriter) collect() error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gathering metrics: %w", err)
+	}
+
+	series := metricFamiliesToSeries(mfs, w.timeNowFunc().UnixMilli(), w.extraLabels())
+	if len(series) == 0 {
+		return nil
+	}
+
+	return w.enqueue(remotewrite.Encode(series))
+}
+
+// send отправляет очередь по порядку, пока не встретит ошибку, которую имеет смысл повторить позже
+func (w *RemoteWriter) send(ctx context.Context) {
+	for {
+		w.queueMu.Lock()
+		if len(w.queue) == 0 {
+			w.queueMu.Unlock()
+			return
+		}
+		segment := w.queue[0]
+		w.queueMu.Unlock()
+
+		sendCtx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+		err := w.sender.Send(sendCtx, segment.data)
+		cancel()
+
+		if err != nil {
+			rwErr := &remotewrite.Error{}
+			if !errors.As(err, &rwErr) || rwErr.Recoverable {
+				w.logger.Warn("remote write failed, will retry later", wdlogger.NewErrorField("error", err), wdlogger.NewIntField("queued", w.queueLen()))
+				return
+			}
+
+			w.logger.Error("remote write rejected, dropping data", wdlogger.NewErrorField("error", err))
+		}
+
+		w.dequeue()
+	}
+}
+
+func (w *RemoteWriter) enqueue(data []byte) error {
+	segment := walSegment{data: data}
+
+	if w.cfg.WALDir != "" {
+		segment.path = filepath.Join(w.cfg.WALDir, fmt.Sprintf("%020d%s", w.timeNowFunc().UnixNano(), walSegmentExt))
+
+		if err := os.WriteFile(segment.path, data, 0o644); err != nil {
+			return fmt.Errorf("writing wal segment: %w", err)
+		}
+	}
+
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	w.queue = append(w.queue, segment)
+
+	if dropped := len(w.queue) - w.cfg.MaxSegments; dropped > 0 {
+		w.logger.Warn("remote write queue overflow, dropping oldest data", wdlogger.NewIntField("dropped", dropped))
+
+		for _, s := range w.queue[:dropped] {
+			w.removeSegmentFile(s)
+		}
+
+		w.queue = w.queue[dropped:]
+	}
+
+	return nil
+}
+
+func (w *RemoteWriter) dequeue() {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	if len(w.queue) == 0 {
+		return
+	}
+
+	w.removeSegmentFile(w.queue[0])
+	w.queue = w.queue[1:]
+}
+
+func (w *RemoteWriter) queueLen() int {
+	w.queueMu.Lock()
+	defer w.queueMu.Unlock()
+
+	return len(w.queue)
+}
+
+func (w *RemoteWriter) removeSegmentFile(s walSegment) {
+	if s.path == "" {
+		return
+	}
+
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		w.logger.Error("removing wal segment", wdlogger.NewErrorField("error", err))
+	}
+}
+
+// loadWAL восстанавливает очередь из сегментов, которые не удалось отправить до перезапуска
+func (w *RemoteWriter) loadWAL() error {
+	if w.cfg.WALDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(w.cfg.WALDir, 0o755); err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(w.cfg.WALDir, "*"+walSegmentExt))
+	if err != nil {
+		return err
+	}
+
+	// Имена сегментов содержат время с ведущими нулями, поэтому лексикографический порядок совпадает с хронологическим
+	sort.Strings(paths)
+
+	segments := make([]walSegment, 0, len(paths))
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		segments = append(segments, walSegment{path: path, data: data})
+	}
+
+	w.queueMu.Lock()
+	w.queue = append(segments, w.queue...)
+	w.queueMu.Unlock()
+
+	if len(segments) > 0 {
+		w.logger.Info("remote write wal loaded", wdlogger.NewIntField("segments", len(segments)))
+	}
+
+	return nil
+}
+
+func (w *RemoteWriter) extraLabels() []remotewrite.Label {
+	labels := make([]remotewrite.Label, 0, len(w.cfg.ExternalLabels)+2)
+
+	if w.cfg.Job != "" {
+		labels = append(labels, remotewrite.Label{Name: "job", Value: w.cfg.Job})
+	}
+
+	if w.cfg.Instance != "" {
+		labels = append(labels, remotewrite.Label{Name: "instance", Value: w.cfg.Instance})
+	}
+
+	for name, value := range w.cfg.ExternalLabels {
+		labels = append(labels, remotewrite.Label{Name: name, Value: value})
+	}
+
+	return labels
+}
+
+// metricFamiliesToSeries преобразует собранные метрики во временные ряды. Гистограммы и summary
+// раскладываются на ряды _bucket/_sum/_count так же, как в формате exposition
+func metricFamiliesToSeries(mfs []*dto.MetricFamily, timestamp int64, extra []remotewrite.Label) []remotewrite.TimeSeries {
+	series := make([]remotewrite.TimeSeries, 0)
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+
+		for _, m := range mf.GetMetric() {
+			labels := make([]remotewrite.Label, 0, len(m.GetLabel())+len(extra)+2)
+			labels = append(labels, extra...)
+
+			for _, lp := range m.GetLabel() {
+				labels = append(labels, remotewrite.Label{Name: lp.GetName(), Value: lp.GetValue()})
+			}
+
+			add := func(suffix string, value float64, extraLabels ...remotewrite.Label) {
+				ls := make([]remotewrite.Label, 0, len(labels)+len(extraLabels)+1)
+				ls = append(ls, remotewrite.Label{Name: "__name__", Value: name + suffix})
+				ls = append(ls, labels...)
+				ls = append(ls, extraLabels...)
+
+				series = append(series, remotewrite.TimeSeries{
+					Labels:  ls,
+					Samples: []remotewrite.Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add("", m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), remotewrite.Label{Name: "quantile", Value: formatFloat(q.GetQuantile())})
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				hasInf := false
+				for _, b := range h.GetBucket() {
+					hasInf = hasInf || math.IsInf(b.GetUpperBound(), 1)
+					add("_bucket", float64(b.GetCumulativeCount()), remotewrite.Label{Name: "le", Value: formatBucketBound(b.GetUpperBound())})
+				}
+				if !hasInf {
+					add("_bucket", float64(h.GetSampleCount()), remotewrite.Label{Name: "le", Value: "+Inf"})
+				}
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+
+	return series
+}
+
+func formatBucketBound(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return formatFloat(v)
+}
+
+// ParseLabels разбирает строку вида "key1=value1,key2=value2"
+func ParseLabels(raw string) (map[string]string, error) {
+	labels := make(map[string]string)
+
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %s", strconv.Quote(pair))
+		}
+
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
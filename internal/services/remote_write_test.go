@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/remotewrite"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeRemoteWriteSender struct {
+	err   error
+	sent  int
+	calls int
+}
+
+func (f *fakeRemoteWriteSender) Send(context.Context, []byte) error {
+	f.calls++
+
+	if f.err != nil {
+		return f.err
+	}
+
+	f.sent++
+
+	return nil
+}
+
+func TestRemoteWriter_WAL(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "myheat_test"})
+	reg.MustRegister(gauge)
+
+	cfg := NewDefaultRemoteWriterConfig()
+	cfg.WALDir = t.TempDir()
+
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	sender := &fakeRemoteWriteSender{err: &remotewrite.Error{Recoverable: true}}
+	w := NewRemoteWriter(cfg, reg, sender, nopwrap.NewNopWrapper())
+	w.timeNowFunc = func() time.Time { now = now.Add(time.Second); return now }
+
+	if err := w.loadWAL(); err != nil {
+		t.Fatalf("loadWAL() error = %v", err)
+	}
+
+	// Эндпоинт недоступен: данные копятся в WAL
+	for i := 0; i < 3; i++ {
+		if err := w.collect(); err != nil {
+			t.Fatalf("collect() error = %v", err)
+		}
+		w.send(context.Background())
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(cfg.WALDir, "*"+walSegmentExt))
+	if len(segments) != 3 {
+		t.Fatalf("wal segments = %d, want 3", len(segments))
+	}
+
+	// После перезапуска очередь восстанавливается из WAL и отправляется, когда эндпоинт снова доступен
+	sender = &fakeRemoteWriteSender{}
+	w = NewRemoteWriter(cfg, reg, sender, nopwrap.NewNopWrapper())
+
+	if err := w.loadWAL(); err != nil {
+		t.Fatalf("loadWAL() error = %v", err)
+	}
+
+	w.send(context.Background())
+
+	if sender.sent != 3 {
+		t.Errorf("sent = %d, want 3", sender.sent)
+	}
+
+	entries, _ := os.ReadDir(cfg.WALDir)
+	if len(entries) != 0 {
+		t.Errorf("wal entries = %d, want 0", len(entries))
+	}
+}
+
+func TestRemoteWriter_DropsNonRecoverable(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "myheat_test_total"}))
+
+	cfg := NewDefaultRemoteWriterConfig()
+	cfg.MaxSegments = 2
+
+	sender := &fakeRemoteWriteSender{err: &remotewrite.Error{StatusCode: 400}}
+	w := NewRemoteWriter(cfg, reg, sender, nopwrap.NewNopWrapper())
+
+	for i := 0; i < 3; i++ {
+		if err := w.collect(); err != nil {
+			t.Fatalf("collect() error = %v", err)
+		}
+	}
+
+	if w.queueLen() != 2 {
+		t.Fatalf("queue length = %d, want 2", w.queueLen())
+	}
+
+	w.send(context.Background())
+
+	if w.queueLen() != 0 || sender.calls != 2 {
+		t.Errorf("queue length = %d, calls = %d, want 0 and 2", w.queueLen(), sender.calls)
+	}
+}
+
+func TestMetricFamiliesToSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "myheat_test_seconds", Buckets: []float64{1, 10}})
+	h.Observe(5)
+	reg.MustRegister(h)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	series := metricFamiliesToSeries(mfs, 1000, []remotewrite.Label{{Name: "job", Value: "myheat-exporter"}})
+
+	// Две границы, +Inf, _sum и _count
+	if len(series) != 5 {
+		t.Fatalf("series = %d, want 5", len(series))
+	}
+
+	names := map[string]int{}
+	for _, s := range series {
+		for _, l := range s.Labels {
+			if l.Name == "__name__" {
+				names[l.Value]++
+			}
+		}
+	}
+
+	if names["myheat_test_seconds_bucket"] != 3 || names["myheat_test_seconds_sum"] != 1 || names["myheat_test_seconds_count"] != 1 {
+		t.Errorf("unexpected series names %v", names)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/denistv/wdlogger"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+const (
+	defaultListenAddress   = ":3000"
+	defaultShutdownTimeout = time.Second * 10
+)
+
+func NewDefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ListenAddress:   defaultListenAddress,
+		ShutdownTimeout: defaultShutdownTimeout,
+	}
+}
+
+type ServerConfig struct {
+	ListenAddress string
+	// WebConfigFile путь к файлу в формате web-config exporter-toolkit (TLS, basic auth)
+	WebConfigFile string
+	// BearerToken если задан, запросы без заголовка "Authorization: Bearer <token>" отклоняются
+	BearerToken     string
+	ShutdownTimeout time.Duration
+}
+
+func (c ServerConfig) Validate() error {
+	if c.ListenAddress == "" {
+		return errors.New("listen address cannot be empty")
+	}
+
+	if c.ShutdownTimeout <= 0 {
+		return errors.New("shutdown timeout must be positive number")
+	}
+
+	if c.WebConfigFile != "" {
+		if err := web.Validate(c.WebConfigFile); err != nil {
+			return fmt.Errorf("validating web config file: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func NewServer(cfg ServerConfig, l wdlogger.Logger, handler http.Handler) *Server {
+	if cfg.BearerToken != "" {
+		handler = bearerAuthHandler(cfg.BearerToken, handler)
+	}
+
+	return &Server{
+		cfg:    cfg,
+		logger: l,
+		httpServer: &http.Server{
+			Handler: handler,
+		},
+	}
+}
+
+type Server struct {
+	cfg        ServerConfig
+	logger     wdlogger.Logger
+	httpServer *http.Server
+}
+
+// Run запускает HTTP-сервер и блокируется до отмены контекста, после чего корректно завершает работу сервера
+func (s *Server) Run(ctx context.Context) error {
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{s.cfg.ListenAddress},
+		WebSystemdSocket:   new(bool),
+		WebConfigFile:      &s.cfg.WebConfigFile,
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- web.ListenAndServe(s.httpServer, flags, kitLogger{logger: s.logger})
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+
+		return err
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("shutting down http server", wdlogger.NewStringField("timeout", s.cfg.ShutdownTimeout.String()))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutting down http server: %w", err)
+	}
+
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+func bearerAuthHandler(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+
+		if subtle.ConstantTimeCompare(got, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// kitLogger адаптер wdlogger.Logger к go-kit логгеру, который требует exporter-toolkit
+type kitLogger struct {
+	logger wdlogger.Logger
+}
+
+func (k kitLogger) Log(keyvals ...interface{}) error {
+	var msg, level string
+
+	fields := make([]wdlogger.Field, 0, len(keyvals)/2)
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := keyvals[i+1]
+
+		switch key {
+		case "msg":
+			msg = fmt.Sprint(value)
+		case "level":
+			level = fmt.Sprint(value)
+		default:
+			fields = append(fields, wdlogger.Field{Key: key, Value: value})
+		}
+	}
+
+	switch strings.ToLower(level) {
+	case "debug":
+		k.logger.Debug(msg, fields...)
+	case "warn":
+		k.logger.Warn(msg, fields...)
+	case "error":
+		k.logger.Error(msg, fields...)
+	default:
+		k.logger.Info(msg, fields...)
+	}
+
+	return nil
+}
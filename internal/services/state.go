@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeviceState последние полученные данные устройства
+type DeviceState struct {
+	DeviceSnapshot
+	// UpdatedAt время опроса, в котором данные устройства были получены
+	UpdatedAt time.Time
+}
+
+func NewStateStore() *StateStore {
+	return &StateStore{
+		devices: make(map[int64]DeviceState),
+	}
+}
+
+// StateStore хранит данные последнего опроса каждого устройства. Если данные устройства в очередном опросе
+// получить не удалось, сохраняются предыдущие, а UpdatedAt показывает, насколько они устарели
+type StateStore struct {
+	mu       sync.RWMutex
+	lastPull time.Time
+	devices  map[int64]DeviceState
+}
+
+func (s *StateStore) HandlePull(_ context.Context, res PullResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastPull = res.Time
+
+	for _, dev := range res.Devices {
+		s.devices[dev.Device.ID] = DeviceState{DeviceSnapshot: dev, UpdatedAt: res.Time}
+	}
+}
+
+// LastPull время последнего успешного опроса. Нулевое, если опросов еще не было
+func (s *StateStore) LastPull() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.lastPull
+}
+
+// Devices возвращает состояния всех известных устройств, упорядоченные по идентификатору
+func (s *StateStore) Devices() []DeviceState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]DeviceState, 0, len(s.devices))
+	for _, d := range s.devices {
+		out = append(out, d)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Device.ID < out[j].Device.ID
+	})
+
+	return out
+}
+
+func (s *StateStore) Device(id int64) (DeviceState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.devices[id]
+
+	return d, ok
+}
@@ -56,10 +56,28 @@ type TariffSelector struct {
 	tariffs     []Tariff
 }
 
+// TariffTypes возвращает все тарифы, которые может выбрать селектор, включая тариф по умолчанию
+func (t *TariffSelector) TariffTypes() []TariffType {
+	out := []TariffType{TariffOne}
+	seen := map[TariffType]bool{TariffOne: true}
+
+	for _, tariff := range t.tariffs {
+		if !seen[tariff.tariffType] {
+			seen[tariff.tariffType] = true
+			out = append(out, tariff.tariffType)
+		}
+	}
+
+	return out
+}
+
 // Select возвращает первый подходящий тариф. Если ни один из тарифов не выбрался, возвращается дефолтный
 func (t *TariffSelector) Select() TariffType {
-	now := t.timeNowFunc()
+	return t.SelectAt(t.timeNowFunc())
+}
 
+// SelectAt то же, что Select, но для заданного момента времени
+func (t *TariffSelector) SelectAt(now time.Time) TariffType {
 	for _, tariff := range t.tariffs {
 		intervalMatched := false
 		oneDay := (tariff.from - tariff.to) < 0
@@ -91,3 +109,24 @@ func (t *TariffSelector) Select() TariffType {
 
 	return TariffOne
 }
+
+// tariffSplitStep шаг, с которым интервал раскладывается по тарифам. Тарифы меняются на границе часа,
+// поэтому минутного шага достаточно
+const tariffSplitStep = time.Minute
+
+// Split раскладывает интервал [from, to) по тарифам и возвращает число секунд для каждого тарифа
+func (t *TariffSelector) Split(from, to time.Time) map[TariffType]float64 {
+	out := make(map[TariffType]float64)
+
+	for curr := from; curr.Before(to); {
+		next := curr.Truncate(tariffSplitStep).Add(tariffSplitStep)
+		if next.After(to) {
+			next = to
+		}
+
+		out[t.SelectAt(curr)] += next.Sub(curr).Seconds()
+		curr = next
+	}
+
+	return out
+}
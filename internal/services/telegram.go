@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/telegram"
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+	"github.com/denistv/wdlogger"
+)
+
+const (
+	defaultTelegramPollTimeout   = time.Second * 30
+	defaultTelegramRetryInterval = time.Second * 5
+	defaultTelegramSendTimeout   = time.Second * 10
+	defaultTelegramQueueSize     = 100
+
+	telegramHelpText = "Команды:\n/status - температура и нагрев в помещениях по данным последнего опроса"
+)
+
+func NewDefaultTelegramBotConfig() TelegramBotConfig {
+	return TelegramBotConfig{
+		PollTimeout:   defaultTelegramPollTimeout,
+		RetryInterval: defaultTelegramRetryInterval,
+		SendTimeout:   defaultTelegramSendTimeout,
+	}
+}
+
+type TelegramBotConfig struct {
+	// ChatIDs чаты, в которые отправляются оповещения. Команды принимаются только из этих чатов
+	ChatIDs       []int64
+	PollTimeout   time.Duration
+	RetryInterval time.Duration
+	SendTimeout   time.Duration
+}
+
+func (c TelegramBotConfig) Validate() error {
+	if len(c.ChatIDs) == 0 {
+		return errors.New("chat ids cannot be empty")
+	}
+
+	if c.PollTimeout < time.Second {
+		return errors.New("poll timeout must be at least one second")
+	}
+
+	if c.RetryInterval <= 0 {
+		return errors.New("retry interval must be positive number")
+	}
+
+	if c.SendTimeout <= 0 {
+		return errors.New("send timeout must be positive number")
+	}
+
+	return nil
+}
+
+// TelegramAPI методы Bot API, которые использует бот. Реализуется telegram.Client
+type TelegramAPI interface {
+	GetUpdates(ctx context.Context, offset int64, timeout int) ([]telegram.Update, error)
+	SendMessage(ctx context.Context, chatID int64, text string) error
+}
+
+func NewTelegramBot(cfg TelegramBotConfig, api TelegramAPI, states *StateStore, l wdlogger.Logger) *TelegramBot {
+	allowed := make(map[int64]bool, len(cfg.ChatIDs))
+	for _, id := range cfg.ChatIDs {
+		allowed[id] = true
+	}
+
+	return &TelegramBot{
+		cfg:     cfg,
+		logger:  l,
+		api:     api,
+		states:  states,
+		allowed: allowed,
+		queue:   make(chan Alert, defaultTelegramQueueSize),
+	}
+}
+
+// TelegramBot отправляет оповещения в Telegram и отвечает на команды только для чтения
+type TelegramBot struct {
+	cfg     TelegramBotConfig
+	logger  wdlogger.Logger
+	api     TelegramAPI
+	states  *StateStore
+	allowed map[int64]bool
+	queue   chan Alert
+}
+
+func (b *TelegramBot) Notify(a Alert) {
+	select {
+	case b.queue <- a:
+	default:
+		b.logger.Warn("telegram queue is full, dropping alert", wdlogger.NewStringField("key", a.Key))
+	}
+}
+
+func (b *TelegramBot) Run(ctx context.Context) {
+	b.logger.Info("telegram bot started")
+
+	go b.runAlerts(ctx)
+
+	var offset int64
+
+	for {
+		updates, err := b.api.GetUpdates(ctx, offset, int(b.cfg.PollTimeout.Seconds()))
+		if ctx.Err() != nil {
+			b.logger.Info("received shutdown signal, exiting")
+			return
+		}
+
+		if err != nil {
+			b.logger.Error("getting telegram updates", wdlogger.NewErrorField("error", err))
+
+			select {
+			case <-ctx.Done():
+				b.logger.Info("received shutdown signal, exiting")
+				return
+			case <-time.After(b.cfg.RetryInterval):
+			}
+
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+
+			if u.Message != nil {
+				b.handleMessage(ctx, *u.Message)
+			}
+		}
+	}
+}
+
+func (b *TelegramBot) runAlerts(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case a := <-b.queue:
+			for _, chatID := range b.cfg.ChatIDs {
+				b.send(ctx, chatID, a.Summary)
+			}
+		}
+	}
+}
+
+func (b *TelegramBot) handleMessage(ctx context.Context, msg telegram.Message) {
+	if !b.allowed[msg.Chat.ID] {
+		// Идентификатор чата нужен для настройки, поэтому он выводится в лог
+		b.logger.Warn("telegram message from unknown chat ignored", wdlogger.NewInt64Field("chat_id", msg.Chat.ID))
+		return
+	}
+
+	// В группах команда может быть адресована боту явно: /status@my_bot
+	command, _, _ := strings.Cut(strings.TrimSpace(msg.Text), " ")
+	command, _, _ = strings.Cut(command, "@")
+
+	switch command {
+	case "/status":
+		b.send(ctx, msg.Chat.ID, telegramStatusText(b.states))
+	case "/start", "/help":
+		b.send(ctx, msg.Chat.ID, telegramHelpText)
+	}
+}
+
+func (b *TelegramBot) send(ctx context.Context, chatID int64, text string) {
+	ctx, cancel := context.WithTimeout(ctx, b.cfg.SendTimeout)
+	defer cancel()
+
+	if err := b.api.SendMessage(ctx, chatID, text); err != nil {
+		b.logger.Error("sending telegram message", wdlogger.NewErrorField("error", err), wdlogger.NewInt64Field("chat_id", chatID))
+	}
+}
+
+// telegramStatusText текущая температура и нагрев в помещениях по данным последнего опроса
+func telegramStatusText(states *StateStore) string {
+	devices := states.Devices()
+	if len(devices) == 0 {
+		return "Данных пока нет"
+	}
+
+	sb := &strings.Builder{}
+	lastPull := states.LastPull()
+
+	for i, d := range devices {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+
+		fmt.Fprintf(sb, "%s, данные на %s", d.Device.Name, d.UpdatedAt.Format("02.01 15:04"))
+
+		if d.UpdatedAt.Before(lastPull) || !d.Info.DataActual {
+			sb.WriteString(" (устарели)")
+		}
+
+		sb.WriteString("\n")
+
+		for _, env := range d.Info.Envs {
+			if env.Type != domain.EnvTypeRoomTemperature {
+				continue
+			}
+
+			fmt.Fprintf(sb, "%s: %s °C, цель %s °C", env.Name, formatOptionalFloat(env.Value), formatOptionalFloat(env.Target))
+
+			if env.Demand {
+				sb.WriteString(", нагрев")
+			}
+
+			sb.WriteString("\n")
+		}
+	}
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// formatOptionalFloat форматирует показание, которого может не быть
+func formatOptionalFloat(v *float64) string {
+	if v == nil {
+		return "—"
+	}
+
+	return formatFloat(*v)
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/telegram"
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+// telegramStub заглушка Bot API: один раз отдает заданные обновления и запоминает отправленные сообщения
+type telegramStub struct {
+	mu       sync.Mutex
+	updates  []telegram.Update
+	messages chan map[string]interface{}
+}
+
+func (s *telegramStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := map[string]interface{}{}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	var result interface{} = true
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/bottest-token/getUpdates"):
+		s.mu.Lock()
+		result, s.updates = s.updates, nil
+		s.mu.Unlock()
+	case strings.HasSuffix(r.URL.Path, "/bottest-token/sendMessage"):
+		s.messages <- req
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func TestTelegramBot(t *testing.T) {
+	stub := &telegramStub{
+		updates: []telegram.Update{
+			{UpdateID: 1, Message: &telegram.Message{Chat: telegram.Chat{ID: 666}, Text: "/status"}},
+			{UpdateID: 2, Message: &telegram.Message{Chat: telegram.Chat{ID: 42}, Text: "/status@myheat_bot"}},
+		},
+		messages: make(chan map[string]interface{}, 10),
+	}
+
+	srv := httptest.NewServer(stub)
+	defer srv.Close()
+
+	client := telegram.NewClient(telegram.Config{BaseURL: srv.URL, Token: "test-token"})
+
+	// Данные контроллера неактуальны, это отмечается в статусе
+	res := testPullResult()
+	res.Devices[0].Info.DataActual = false
+
+	states := NewStateStore()
+	states.HandlePull(context.Background(), res)
+
+	cfg := NewDefaultTelegramBotConfig()
+	cfg.ChatIDs = []int64{42}
+	cfg.PollTimeout = time.Second
+
+	bot := NewTelegramBot(cfg, client, states, nopwrap.NewNopWrapper())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go bot.Run(ctx)
+
+	waitMessage := func() map[string]interface{} {
+		select {
+		case m := <-stub.messages:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not sent")
+			return nil
+		}
+	}
+
+	status := waitMessage()
+	if status["chat_id"] != 42.0 {
+		t.Errorf("status sent to chat %v, want 42", status["chat_id"])
+	}
+
+	want := "Дом, данные на 01.01 00:00 (устарели)\nГостиная: 21.25 °C, цель 22 °C, нагрев"
+	if status["text"] != want {
+		t.Errorf("status text = %q, want %q", status["text"], want)
+	}
+
+	bot.Notify(Alert{Key: "balance/10", Summary: "Дом: низкий баланс SIM-карты контроллера"})
+
+	alert := waitMessage()
+	if alert["text"] != "Дом: низкий баланс SIM-карты контроллера" {
+		t.Errorf("alert text = %q", alert["text"])
+	}
+}
+
+func TestTelegramClient_error(t *testing.T) {
+	srv := httptest.NewServer(&telegramStub{})
+	defer srv.Close()
+
+	client := telegram.NewClient(telegram.Config{BaseURL: srv.URL, Token: "wrong"})
+
+	err := client.SendMessage(context.Background(), 1, "test")
+
+	tgErr := &telegram.Error{}
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusNotFound {
+		t.Errorf("SendMessage() error = %v, want telegram api error 404", err)
+	}
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/denistv/wdlogger"
+)
+
+const (
+	defaultWebhookMaxRetries   = 3
+	defaultWebhookRetryBackoff = time.Second * 5
+	defaultWebhookTimeout      = time.Second * 10
+	defaultWebhookQueueSize    = 100
+)
+
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	// Template шаблон тела запроса в формате text/template, на вход получает Alert.
+	// Функция json кодирует значение в JSON. Если не задан, отправляется Alert в JSON
+	Template string `yaml:"template"`
+	// SendResolved отправлять ли оповещения о завершении
+	SendResolved bool `yaml:"send_resolved"`
+	MaxRetries   int  `yaml:"max_retries"`
+	// RetryBackoff пауза перед первым повтором, с каждой попыткой удваивается
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	Timeout      time.Duration `yaml:"timeout"`
+}
+
+// UnmarshalYAML заполняет значения по умолчанию для параметров, которые не заданы в файле
+func (c *WebhookConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain WebhookConfig
+
+	*c = WebhookConfig{
+		SendResolved: true,
+		MaxRetries:   defaultWebhookMaxRetries,
+		RetryBackoff: defaultWebhookRetryBackoff,
+		Timeout:      defaultWebhookTimeout,
+	}
+
+	return unmarshal((*plain)(c))
+}
+
+func (c WebhookConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("url cannot be empty")
+	}
+
+	if c.MaxRetries < 0 {
+		return errors.New("max retries cannot be negative")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be positive number")
+	}
+
+	if _, err := parseWebhookTemplate(c.Template); err != nil {
+		return fmt.Errorf("parsing template: %w", err)
+	}
+
+	return nil
+}
+
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+}
+
+// webhookError ошибка отправки. Ответы 4xx, кроме 429, повторять бессмысленно
+type webhookError struct {
+	statusCode int
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.statusCode)
+}
+
+func (e *webhookError) recoverable() bool {
+	return e.statusCode >= http.StatusInternalServerError || e.statusCode == http.StatusTooManyRequests
+}
+
+func NewWebhookNotifier(cfg WebhookConfig, l wdlogger.Logger) (*WebhookNotifier, error) {
+	tmpl, err := parseWebhookTemplate(cfg.Template)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template: %w", err)
+	}
+
+	return &WebhookNotifier{
+		cfg:        cfg,
+		logger:     l,
+		template:   tmpl,
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		queue:      make(chan Alert, defaultWebhookQueueSize),
+	}, nil
+}
+
+// WebhookNotifier отправляет оповещения POST-запросом на заданный URL. Отправка выполняется в Run, чтобы повторы
+// при недоступности получателя не задерживали опрос
+type WebhookNotifier struct {
+	cfg        WebhookConfig
+	logger     wdlogger.Logger
+	template   *template.Template
+	httpClient *http.Client
+	queue      chan Alert
+}
+
+func (n *WebhookNotifier) Notify(a Alert) {
+	if a.Status == AlertResolved && !n.cfg.SendResolved {
+		return
+	}
+
+	select {
+	case n.queue <- a:
+	default:
+		n.logger.Warn("webhook queue is full, dropping alert", wdlogger.NewStringField("key", a.Key))
+	}
+}
+
+func (n *WebhookNotifier) Run(ctx context.Context) {
+	n.logger.Info("webhook notifier started", wdlogger.NewStringField("url", n.cfg.URL))
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.logger.Info("received shutdown signal, exiting")
+			return
+		case a := <-n.queue:
+			if err := n.sendWithRetries(ctx, a); err != nil {
+				n.logger.Error("sending webhook", wdlogger.NewErrorField("error", err), wdlogger.NewStringField("key", a.Key))
+			}
+		}
+	}
+}
+
+func (n *WebhookNotifier) sendWithRetries(ctx context.Context, a Alert) error {
+	body, err := n.render(a)
+	if err != nil {
+		return fmt.Errorf("rendering body: %w", err)
+	}
+
+	backoff := n.cfg.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err = n.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		whErr := &webhookError{}
+		if errors.As(err, &whErr) && !whErr.recoverable() {
+			return err
+		}
+
+		if attempt >= n.cfg.MaxRetries {
+			return err
+		}
+
+		n.logger.Warn("retrying webhook", wdlogger.NewIntField("attempt", attempt+1), wdlogger.NewErrorField("error", err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (n *WebhookNotifier) render(a Alert) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(a)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := n.template.Execute(buf, a); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (n *WebhookNotifier) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, value := range n.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &webhookError{statusCode: resp.StatusCode}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denistv/wdlogger/wrappers/nopwrap"
+)
+
+func TestWebhookNotifier_sendWithRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "успех после повтора", statuses: []int{http.StatusInternalServerError, http.StatusOK}, wantRequests: 2},
+		{name: "4xx не повторяется", statuses: []int{http.StatusBadRequest}, wantErr: true, wantRequests: 1},
+		{name: "повторы закончились", statuses: []int{502, 502, 502}, wantErr: true, wantRequests: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				bodies []string
+			)
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				if r.Header.Get("X-Token") != "secret" {
+					t.Errorf("custom header was not sent")
+				}
+
+				w.WriteHeader(tt.statuses[len(bodies)])
+				bodies = append(bodies, string(body))
+			}))
+			defer srv.Close()
+
+			cfg := WebhookConfig{
+				URL:          srv.URL,
+				Headers:      map[string]string{"X-Token": "secret"},
+				Template:     `{"text": {{ json .Summary }}, "status": "{{ .Status }}"}`,
+				MaxRetries:   2,
+				RetryBackoff: time.Millisecond,
+				Timeout:      time.Second,
+			}
+
+			n, err := NewWebhookNotifier(cfg, nopwrap.NewNopWrapper())
+			if err != nil {
+				t.Fatalf("NewWebhookNotifier() error = %v", err)
+			}
+
+			err = n.sendWithRetries(context.Background(), Alert{Status: AlertFiring, Summary: `Дом: "холодно"`})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("sendWithRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(bodies) != tt.wantRequests {
+				t.Fatalf("requests = %d, want %d", len(bodies), tt.wantRequests)
+			}
+
+			if want := `{"text": "Дом: \"холодно\"", "status": "firing"}`; bodies[0] != want {
+				t.Errorf("body = %s, want %s", bodies[0], want)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	n, err := NewWebhookNotifier(WebhookConfig{URL: "http://localhost", Timeout: time.Second}, nopwrap.NewNopWrapper())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(Alert{Status: AlertResolved})
+
+	if len(n.queue) != 0 {
+		t.Errorf("resolved alert must be skipped when send_resolved is disabled")
+	}
+
+	n.Notify(Alert{Status: AlertFiring})
+
+	if len(n.queue) != 1 {
+		t.Errorf("firing alert was not queued")
+	}
+}
@@ -0,0 +1,321 @@
+// Package simulation простая тепловая модель дома, которая заменяет MyHeat API в качестве источника данных.
+//
+// Помещения теряют тепло пропорционально разнице с уличной температурой и нагреваются, пока котел работает.
+// Запрос нагрева включается, когда температура опускается ниже целевой на величину гистерезиса, и выключается,
+// когда поднимается выше нее. Уличная температура меняется по суточной синусоиде с минимумом в 5 утра.
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/denistv/myheat-prometheus-exporter/internal/domain"
+)
+
+// maxStep максимальный шаг интегрирования модели
+const maxStep = time.Minute
+
+// coldestHour час суток с минимальной уличной температурой
+const coldestHour = 5
+
+var roomNames = []string{"Гостиная", "Спальня", "Кухня", "Детская", "Кабинет", "Ванная", "Прихожая", "Мансарда"}
+
+func NewDefaultConfig() Config {
+	return Config{
+		Devices:          1,
+		Rooms:            3,
+		Speed:            1,
+		OutdoorMean:      -5,
+		OutdoorAmplitude: 5,
+		Target:           21,
+		Hysteresis:       0.3,
+		HeatLossRate:     0.05,
+		HeatingRate:      2.5,
+	}
+}
+
+type Config struct {
+	// Devices количество контроллеров
+	Devices int
+	// Rooms количество помещений у каждого контроллера
+	Rooms int
+	// Speed во сколько раз модельное время идет быстрее реального. Например, 60 - час за минуту
+	Speed float64
+
+	// OutdoorMean средняя за сутки уличная температура, °C
+	OutdoorMean float64
+	// OutdoorAmplitude размах суточного изменения уличной температуры, °C
+	OutdoorAmplitude float64
+
+	// Target целевая температура помещений, °C
+	Target float64
+	// Hysteresis гистерезис включения нагрева, °C
+	Hysteresis float64
+	// HeatLossRate доля разницы с уличной температурой, которую помещение теряет за час
+	HeatLossRate float64
+	// HeatingRate на сколько градусов в час нагревается помещение при работающем котле
+	HeatingRate float64
+}
+
+func (c Config) Validate() error {
+	if c.Devices <= 0 || c.Rooms <= 0 {
+		return errors.New("devices and rooms must be positive numbers")
+	}
+
+	if c.Speed <= 0 {
+		return errors.New("speed must be positive number")
+	}
+
+	if c.Hysteresis < 0 || c.HeatLossRate <= 0 || c.HeatingRate <= 0 {
+		return errors.New("hysteresis, heat loss rate and heating rate must be positive numbers")
+	}
+
+	return nil
+}
+
+// New создает симуляцию, модельное время которой начинается с текущего
+func New(cfg Config) *Simulator {
+	return newSimulator(cfg, time.Now)
+}
+
+func newSimulator(cfg Config, timeNowFunc func() time.Time) *Simulator {
+	now := timeNowFunc()
+
+	s := &Simulator{
+		cfg:         cfg,
+		timeNowFunc: timeNowFunc,
+		realTime:    now,
+		modelTime:   now,
+	}
+
+	for d := 0; d < cfg.Devices; d++ {
+		dev := &device{
+			id:   int64(d + 1),
+			name: fmt.Sprintf("Дом %d", d+1),
+		}
+
+		for r := 0; r < cfg.Rooms; r++ {
+			name := roomNames[r%len(roomNames)]
+			if r >= len(roomNames) {
+				name = fmt.Sprintf("%s %d", name, r/len(roomNames)+1)
+			}
+
+			dev.rooms = append(dev.rooms, &room{
+				id:     int64((d+1)*100 + r + 1),
+				name:   name,
+				target: cfg.Target,
+				// Помещения начинают с разной температуры, чтобы нагрев включался не одновременно
+				temp: cfg.Target - cfg.Hysteresis + float64(r)*0.4,
+				// Помещения с большим номером теряют тепло быстрее, например, угловые
+				lossRate: cfg.HeatLossRate * (1 + 0.2*float64(r)),
+			})
+		}
+
+		s.devices = append(s.devices, dev)
+	}
+
+	return s
+}
+
+// Simulator источник данных экспортера, который вместо MyHeat API отдает данные тепловой модели
+type Simulator struct {
+	cfg         Config
+	timeNowFunc func() time.Time
+
+	mu        sync.Mutex
+	realTime  time.Time
+	modelTime time.Time
+	devices   []*device
+}
+
+type device struct {
+	id    int64
+	name  string
+	rooms []*room
+}
+
+func (d *device) heating() bool {
+	for _, r := range d.rooms {
+		if r.demand {
+			return true
+		}
+	}
+
+	return false
+}
+
+type room struct {
+	id       int64
+	name     string
+	target   float64
+	temp     float64
+	lossRate float64
+	demand   bool
+}
+
+func (s *Simulator) GetDevices(_ context.Context) ([]domain.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance()
+
+	devices := make([]domain.Device, 0, len(s.devices))
+
+	for _, d := range s.devices {
+		devices = append(devices, domain.Device{
+			ID:       d.id,
+			Name:     d.name,
+			City:     "Симуляция",
+			Severity: domain.DevSeverityNormal,
+		})
+	}
+
+	return devices, nil
+}
+
+func (s *Simulator) GetDeviceInfo(_ context.Context, id int64) (domain.DeviceInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance()
+
+	d := s.device(id)
+	if d == nil {
+		return domain.DeviceInfo{}, fmt.Errorf("device %d not found", id)
+	}
+
+	weatherTemp := round(s.outdoorTemp(s.modelTime))
+
+	info := domain.DeviceInfo{
+		City:        "Симуляция",
+		DataActual:  true,
+		Severity:    domain.DevSeverityNormal,
+		WeatherTemp: &weatherTemp,
+	}
+
+	for _, r := range d.rooms {
+		value, target := round(r.temp), r.target
+
+		info.Envs = append(info.Envs, domain.Env{
+			ID:       r.id,
+			Name:     r.name,
+			Type:     domain.EnvTypeRoomTemperature,
+			Value:    &value,
+			Target:   &target,
+			Demand:   r.demand,
+			Severity: domain.DevSeverityNormal,
+		})
+	}
+
+	heating := d.heating()
+
+	// Температура подачи растет с нагрузкой, без нагрева теплоноситель остывает до комнатной
+	flowTemp, returnTemp := 30.0, 28.0
+	if heating {
+		flowTemp, returnTemp = 60, 45
+	}
+
+	pressure := 1.5
+
+	info.Heaters = []domain.Heater{{
+		ID:            d.id,
+		Name:          "Котел",
+		BurnerHeating: heating,
+		FlowTemp:      &flowTemp,
+		ReturnTemp:    &returnTemp,
+		Pressure:      &pressure,
+	}}
+
+	return info, nil
+}
+
+// SetEnvGoal изменяет целевую температуру помещения, как это делает MyHeat API
+func (s *Simulator) SetEnvGoal(_ context.Context, deviceID, envID int64, goal float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.advance()
+
+	d := s.device(deviceID)
+	if d == nil {
+		return fmt.Errorf("device %d not found", deviceID)
+	}
+
+	for _, r := range d.rooms {
+		if r.id == envID {
+			r.target = goal
+			return nil
+		}
+	}
+
+	return fmt.Errorf("env %d not found", envID)
+}
+
+func (s *Simulator) device(id int64) *device {
+	for _, d := range s.devices {
+		if d.id == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+// advance продвигает модель до текущего момента с учетом ускорения времени
+func (s *Simulator) advance() {
+	now := s.timeNowFunc()
+
+	elapsed := time.Duration(float64(now.Sub(s.realTime)) * s.cfg.Speed)
+	s.realTime = now
+
+	for elapsed > 0 {
+		step := elapsed
+		if step > maxStep {
+			step = maxStep
+		}
+
+		s.step(step)
+		elapsed -= step
+	}
+}
+
+func (s *Simulator) step(dt time.Duration) {
+	s.modelTime = s.modelTime.Add(dt)
+	outdoor := s.outdoorTemp(s.modelTime)
+	hours := dt.Hours()
+
+	for _, d := range s.devices {
+		for _, r := range d.rooms {
+			// Гистерезис: нагрев включается ниже target - hysteresis и выключается выше target
+			if r.temp < r.target-s.cfg.Hysteresis {
+				r.demand = true
+			} else if r.temp > r.target {
+				r.demand = false
+			}
+
+			delta := -r.lossRate * (r.temp - outdoor)
+			if r.demand {
+				delta += s.cfg.HeatingRate
+			}
+
+			r.temp += delta * hours
+		}
+	}
+}
+
+// outdoorTemp уличная температура в заданный момент модельного времени
+func (s *Simulator) outdoorTemp(t time.Time) float64 {
+	hour := float64(t.Hour()) + float64(t.Minute())/60
+	phase := (hour - coldestHour) / 24 * 2 * math.Pi
+
+	return s.cfg.OutdoorMean - s.cfg.OutdoorAmplitude*math.Cos(phase)
+}
+
+// round округляет до сотых, как это делают датчики MyHeat
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
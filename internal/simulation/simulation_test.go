@@ -0,0 +1,112 @@
+package simulation
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSimulator_thermalModel(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	cfg := NewDefaultConfig()
+	cfg.Rooms = 2
+	cfg.Speed = 60
+
+	s := newSimulator(cfg, func() time.Time { return now })
+	ctx := context.Background()
+
+	var (
+		demandChanges int
+		lastDemand    bool
+		minTemp       = cfg.Target
+		maxTemp       = cfg.Target
+	)
+
+	// Сутки модельного времени при ускорении в 60 раз - 24 минуты реального
+	for i := 0; i < 24*60; i++ {
+		now = now.Add(time.Second)
+
+		info, err := s.GetDeviceInfo(ctx, 1)
+		if err != nil {
+			t.Fatalf("GetDeviceInfo() error = %v", err)
+		}
+
+		env := info.Envs[0]
+
+		if env.Demand != lastDemand {
+			demandChanges++
+			lastDemand = env.Demand
+		}
+
+		if *env.Value < minTemp {
+			minTemp = *env.Value
+		}
+
+		if *env.Value > maxTemp {
+			maxTemp = *env.Value
+		}
+
+		if heating := info.Heaters[0].BurnerHeating; heating && !info.Envs[0].Demand && !info.Envs[1].Demand {
+			t.Fatalf("burner is heating without demand")
+		}
+	}
+
+	if got := s.modelTime.Sub(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)); got != 24*time.Hour {
+		t.Errorf("model time advanced by %v, want 24h", got)
+	}
+
+	// Нагрев должен многократно включаться и выключаться, удерживая температуру около целевой
+	if demandChanges < 10 {
+		t.Errorf("demand changed %d times, want at least 10", demandChanges)
+	}
+
+	if minTemp < cfg.Target-cfg.Hysteresis-0.2 || maxTemp > cfg.Target+0.2 {
+		t.Errorf("temperature range [%v, %v] is too wide for target %v", minTemp, maxTemp, cfg.Target)
+	}
+}
+
+func TestSimulator_outdoorTemp(t *testing.T) {
+	s := New(NewDefaultConfig())
+
+	coldest := s.outdoorTemp(time.Date(2024, time.January, 1, coldestHour, 0, 0, 0, time.UTC))
+	warmest := s.outdoorTemp(time.Date(2024, time.January, 1, coldestHour+12, 0, 0, 0, time.UTC))
+
+	if coldest != -10 || warmest != 0 {
+		t.Errorf("outdoor temp = %v..%v, want -10..0", coldest, warmest)
+	}
+}
+
+func TestSimulator_SetEnvGoal(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := newSimulator(NewDefaultConfig(), func() time.Time { return now })
+	ctx := context.Background()
+
+	devices, err := s.GetDevices(ctx)
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("devices = %+v, want 1 device", devices)
+	}
+
+	if err = s.SetEnvGoal(ctx, 1, 101, 25); err != nil {
+		t.Fatalf("SetEnvGoal() error = %v", err)
+	}
+
+	now = now.Add(time.Minute)
+
+	info, err := s.GetDeviceInfo(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetDeviceInfo() error = %v", err)
+	}
+
+	if env := info.Envs[0]; *env.Target != 25 || !env.Demand {
+		t.Errorf("env = %+v, want target 25 with demand", env)
+	}
+
+	if err = s.SetEnvGoal(ctx, 1, 999, 25); err == nil {
+		t.Errorf("SetEnvGoal() for unknown env must return error")
+	}
+}
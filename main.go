@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,14 +11,56 @@ import (
 	"time"
 	_ "time/tzdata"
 
+	"github.com/denistv/myheat-prometheus-exporter/internal/api"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/influx"
 	"github.com/denistv/myheat-prometheus-exporter/internal/clients/myheat"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/remotewrite"
+	"github.com/denistv/myheat-prometheus-exporter/internal/clients/telegram"
+	"github.com/denistv/myheat-prometheus-exporter/internal/history"
 	"github.com/denistv/myheat-prometheus-exporter/internal/services"
+	"github.com/denistv/myheat-prometheus-exporter/internal/simulation"
 	"github.com/denistv/wdlogger"
 	"github.com/denistv/wdlogger/wrappers/stdwrap"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// Коды завершения в режиме --once
+const (
+	exitCodePullFailed = 2
+	exitCodePushFailed = 3
+)
+
+// subcommands подкоманды. Каждая получает аргументы после своего имени и возвращает код завершения
+var subcommands = map[string]func(ctx context.Context, args []string) int{
+	"export":      runExport,
+	"alert-rules": runAlertRules,
+	"dashboard":   runDashboard,
+
+	"devices":           runDevices,
+	"device":            runDevice,
+	"check-credentials": runCheckCredentials,
+	"tariff":            runTariff,
+
+	"fake-api": runFakeAPI,
+	"replay":   runReplay,
+}
+
 func main() {
+	// Подкоманды разбирают свои флаги самостоятельно
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			code := run(ctx, os.Args[2:])
+			cancel()
+			os.Exit(code)
+		}
+	}
+
+	onceMode := flag.Bool("once", false, "pull data once, push it to Pushgateway and exit")
+	simulateMode := flag.Bool("simulate", false, "generate data from a thermal model instead of calling MyHeat API")
+	flag.Parse()
+
 	ctx, _ := signal.NotifyContext(
 		context.Background(),
 		syscall.SIGINT,
@@ -26,89 +69,413 @@ func main() {
 
 	logger := stdwrap.NewSTDWrapper()
 
-	tz := os.Getenv("TZ")
+	loadTimezone(logger)
+
+	var (
+		source        services.DataSource
+		envGoalSetter services.EnvGoalSetter
+		account       string
+	)
+
+	if *simulateMode {
+		simulator := simulation.New(loadSimulationConfig(logger))
+		source, envGoalSetter, account = simulator, simulator, "simulation"
+
+		logger.Info("simulation mode enabled, MyHeat API is not used")
+	} else {
+		clientCfg := loadMyHeatClientConfig(logger)
+		myheatSource := myheat.NewSource(myheat.NewClient(clientCfg, logger))
+		source, envGoalSetter, account = myheatSource, myheatSource, clientCfg.Login
+	}
+
+	tariffSelector := loadTariffSelector(logger)
+
+	// В режиме --once метрики отправляются в Pushgateway из отдельных реестров, поэтому общий реестр не используется
+	var metricsRegisterer prometheus.Registerer = prometheus.DefaultRegisterer
+	if *onceMode {
+		metricsRegisterer = prometheus.NewRegistry()
+	}
+
+	labelsCfg := loadLabelsConfig(logger)
+	metricsService := services.NewMetrics(logger, tariffSelector, labelsCfg, metricsRegisterer)
+
+	var (
+		exporterPullInterval time.Duration
+		err                  error
+	)
+
+	// В режиме --once интервал опроса не используется
+	if !*onceMode || os.Getenv("MYHEAT_EXPORTER_PULL_INTERVAL") != "" {
+		exporterPullInterval, err = time.ParseDuration(os.Getenv("MYHEAT_EXPORTER_PULL_INTERVAL"))
+		if err != nil {
+			logger.Fatal("validating exporter config", wdlogger.NewErrorField("error", err))
+		}
+	}
+
+	expCfg := services.NewExporterConfig(exporterPullInterval)
+	expCfg.StaleData = loadStaleDataPolicy(logger)
+	expCfg.Filter = loadFilterConfig(logger)
+
+	exp := services.NewExporter(expCfg, source, logger, metricsService)
+
+	if *onceMode {
+		os.Exit(runOnce(ctx, logger, exp, tariffSelector, account))
+	}
+
+	if err = expCfg.Validate(); err != nil {
+		logger.Fatal("validating exporter config", wdlogger.NewErrorField("error", err))
+	}
+
+	go metricsService.Run(ctx)
+
+	dutyCycleCfg := loadDutyCycleConfig(logger)
+	dutyCycleCfg.Labels = labelsCfg
+	exp.Subscribe(services.NewDutyCycle(dutyCycleCfg, metricsRegisterer))
+
+	degreeDaysCfg := loadDegreeDaysConfig(logger)
+	degreeDaysCfg.Labels = labelsCfg
+	exp.Subscribe(services.NewDegreeDays(degreeDaysCfg, metricsRegisterer))
+
+	// Configure MQTT publisher
+	if mqttBrokerURL := os.Getenv("MYHEAT_MQTT_BROKER_URL"); mqttBrokerURL != "" {
+		mqttCfg := services.NewDefaultMQTTConfig()
+		mqttCfg.BrokerURL = mqttBrokerURL
+		mqttCfg.Username = os.Getenv("MYHEAT_MQTT_USERNAME")
+		mqttCfg.Password = os.Getenv("MYHEAT_MQTT_PASSWORD")
+
+		if clientID := os.Getenv("MYHEAT_MQTT_CLIENT_ID"); clientID != "" {
+			mqttCfg.ClientID = clientID
+		}
+
+		if topicPrefix := os.Getenv("MYHEAT_MQTT_TOPIC_PREFIX"); topicPrefix != "" {
+			mqttCfg.TopicPrefix = topicPrefix
+		}
+
+		if discoveryPrefix := os.Getenv("MYHEAT_MQTT_DISCOVERY_PREFIX"); discoveryPrefix != "" {
+			mqttCfg.DiscoveryPrefix = discoveryPrefix
+		}
+
+		if commandsEnabledRaw := os.Getenv("MYHEAT_MQTT_COMMANDS_ENABLED"); commandsEnabledRaw != "" {
+			mqttCfg.CommandsEnabled, err = strconv.ParseBool(commandsEnabledRaw)
+			if err != nil {
+				logger.Fatal("parsing mqtt commands enabled", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		for env, dst := range map[string]*float64{
+			"MYHEAT_MQTT_TARGET_MIN":  &mqttCfg.TargetMin,
+			"MYHEAT_MQTT_TARGET_MAX":  &mqttCfg.TargetMax,
+			"MYHEAT_MQTT_TARGET_STEP": &mqttCfg.TargetStep,
+		} {
+			if raw := os.Getenv(env); raw != "" {
+				*dst, err = strconv.ParseFloat(raw, 64)
+				if err != nil {
+					logger.Fatal("parsing "+env, wdlogger.NewErrorField("error", err))
+				}
+			}
+		}
+
+		if commandIntervalRaw := os.Getenv("MYHEAT_MQTT_COMMAND_INTERVAL"); commandIntervalRaw != "" {
+			mqttCfg.CommandInterval, err = time.ParseDuration(commandIntervalRaw)
+			if err != nil {
+				logger.Fatal("parsing mqtt command interval", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		if err = mqttCfg.Validate(); err != nil {
+			logger.Fatal("validating mqtt config", wdlogger.NewErrorField("error", err))
+		}
+
+		mqttPublisher := services.NewMQTTPublisher(mqttCfg, envGoalSetter, logger)
+		exp.Subscribe(mqttPublisher)
+
+		go mqttPublisher.Run(ctx)
+	}
+
+	// Configure InfluxDB line protocol sink
+	influxWriteURL := os.Getenv("MYHEAT_INFLUX_WRITE_URL")
+	influxFile := os.Getenv("MYHEAT_INFLUX_FILE")
+
+	if influxWriteURL != "" || influxFile != "" {
+		var lineWriter services.LineWriter
+
+		if influxFile != "" {
+			lineWriter = influx.NewFileWriter(influxFile)
+		} else {
+			influxCfg := influx.Config{
+				WriteURL: influxWriteURL,
+				Token:    os.Getenv("MYHEAT_INFLUX_TOKEN"),
+				Username: os.Getenv("MYHEAT_INFLUX_USERNAME"),
+				Password: os.Getenv("MYHEAT_INFLUX_PASSWORD"),
+			}
+
+			if err = influxCfg.Validate(); err != nil {
+				logger.Fatal("validating influx config", wdlogger.NewErrorField("error", err))
+			}
+
+			lineWriter = influx.NewClient(influxCfg)
+		}
+
+		influxSinkCfg := services.NewDefaultInfluxSinkConfig()
+
+		if batchSizeRaw := os.Getenv("MYHEAT_INFLUX_BATCH_SIZE"); batchSizeRaw != "" {
+			influxSinkCfg.BatchSize, err = strconv.Atoi(batchSizeRaw)
+			if err != nil {
+				logger.Fatal("parsing influx batch size", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		if flushIntervalRaw := os.Getenv("MYHEAT_INFLUX_FLUSH_INTERVAL"); flushIntervalRaw != "" {
+			influxSinkCfg.FlushInterval, err = time.ParseDuration(flushIntervalRaw)
+			if err != nil {
+				logger.Fatal("parsing influx flush interval", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		if maxRetriesRaw := os.Getenv("MYHEAT_INFLUX_MAX_RETRIES"); maxRetriesRaw != "" {
+			influxSinkCfg.MaxRetries, err = strconv.Atoi(maxRetriesRaw)
+			if err != nil {
+				logger.Fatal("parsing influx max retries", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		if err = influxSinkCfg.Validate(); err != nil {
+			logger.Fatal("validating influx sink config", wdlogger.NewErrorField("error", err))
+		}
+
+		influxSink := services.NewInfluxSink(influxSinkCfg, lineWriter, logger)
+		exp.Subscribe(influxSink)
+
+		go influxSink.Run(ctx)
+	}
+
+	// Configure Prometheus remote_write sender
+	if remoteWriteURL := os.Getenv("MYHEAT_REMOTE_WRITE_URL"); remoteWriteURL != "" {
+		rwClientCfg := remotewrite.Config{
+			URL:         remoteWriteURL,
+			Username:    os.Getenv("MYHEAT_REMOTE_WRITE_USERNAME"),
+			Password:    os.Getenv("MYHEAT_REMOTE_WRITE_PASSWORD"),
+			BearerToken: os.Getenv("MYHEAT_REMOTE_WRITE_BEARER_TOKEN"),
+		}
+
+		if err = rwClientCfg.Validate(); err != nil {
+			logger.Fatal("validating remote write client config", wdlogger.NewErrorField("error", err))
+		}
+
+		rwCfg := services.NewDefaultRemoteWriterConfig()
+		rwCfg.WALDir = os.Getenv("MYHEAT_REMOTE_WRITE_WAL_DIR")
+		rwCfg.Instance = os.Getenv("MYHEAT_REMOTE_WRITE_INSTANCE")
+
+		if intervalRaw := os.Getenv("MYHEAT_REMOTE_WRITE_INTERVAL"); intervalRaw != "" {
+			rwCfg.Interval, err = time.ParseDuration(intervalRaw)
+			if err != nil {
+				logger.Fatal("parsing remote write interval", wdlogger.NewErrorField("error", err))
+			}
+		}
 
-	if tz != "" {
-		loc, err := time.LoadLocation(tz)
+		rwCfg.ExternalLabels, err = services.ParseLabels(os.Getenv("MYHEAT_REMOTE_WRITE_EXTERNAL_LABELS"))
 		if err != nil {
-			logger.Fatal(err.Error())
+			logger.Fatal("parsing remote write external labels", wdlogger.NewErrorField("error", err))
+		}
+
+		if err = rwCfg.Validate(); err != nil {
+			logger.Fatal("validating remote writer config", wdlogger.NewErrorField("error", err))
 		}
 
-		time.Local = loc
+		remoteWriter := services.NewRemoteWriter(rwCfg, prometheus.DefaultGatherer, remotewrite.NewClient(rwClientCfg), logger)
+
+		go remoteWriter.Run(ctx)
 	}
 
-	clientCfg := myheat.NewDefaultConfig()
-	clientCfg.Login = os.Getenv("MYHEAT_LOGIN")
-	clientCfg.Key = os.Getenv("MYHEAT_KEY")
+	stateStore := services.NewStateStore()
+	exp.Subscribe(stateStore)
+
+	// Configure notifications
+	notificationsCfg := services.NotificationsConfig{}
 
-	if err := clientCfg.Validate(); err != nil {
-		logger.Fatal("validating MyHeat client config", wdlogger.NewErrorField("error", err))
+	if notificationsConfigFile := os.Getenv("MYHEAT_NOTIFICATIONS_CONFIG_FILE"); notificationsConfigFile != "" {
+		notificationsCfg, err = services.LoadNotificationsConfig(notificationsConfigFile)
+		if err != nil {
+			logger.Fatal("loading notifications config", wdlogger.NewErrorField("error", err))
+		}
 	}
 
-	myheatClient := myheat.NewClient(clientCfg, logger)
+	telegramToken := os.Getenv("MYHEAT_TELEGRAM_TOKEN")
 
-	// Configure tariff selector
-	tariffs := []services.Tariff{}
+	// Если файл с правилами не задан, для Telegram используются правила по умолчанию
+	if telegramToken != "" && len(notificationsCfg.Rules) == 0 {
+		notificationsCfg.Rules = services.DefaultAlertRules()
+	}
 
-	envTariff2FromRaw := os.Getenv("MYHEAT_TARIFF2_FROM")
-	envTariff2ToRaw := os.Getenv("MYHEAT_TARIFF2_TO")
+	alerter := services.NewAlerter(notificationsCfg.Rules, logger)
+	exp.Subscribe(alerter)
 
-	if envTariff2FromRaw != "" && envTariff2ToRaw != "" {
-		tariff2From, err := strconv.ParseInt(envTariff2FromRaw, 10, 32)
+	for _, webhookCfg := range notificationsCfg.Webhooks {
+		webhookNotifier, err := services.NewWebhookNotifier(webhookCfg, logger)
 		if err != nil {
-			logger.Fatal(err.Error())
+			logger.Fatal("creating webhook notifier", wdlogger.NewErrorField("error", err))
 		}
 
-		tariff2To, err := strconv.ParseInt(envTariff2ToRaw, 10, 32)
+		alerter.AddNotifier(webhookNotifier)
+
+		go webhookNotifier.Run(ctx)
+	}
+
+	// Configure Telegram bot
+	if telegramToken != "" {
+		telegramClientCfg := telegram.NewDefaultConfig()
+		telegramClientCfg.Token = telegramToken
+
+		if apiURL := os.Getenv("MYHEAT_TELEGRAM_API_URL"); apiURL != "" {
+			telegramClientCfg.BaseURL = apiURL
+		}
+
+		if err = telegramClientCfg.Validate(); err != nil {
+			logger.Fatal("validating telegram client config", wdlogger.NewErrorField("error", err))
+		}
+
+		telegramCfg := services.NewDefaultTelegramBotConfig()
+
+		telegramCfg.ChatIDs, err = parseIDs(os.Getenv("MYHEAT_TELEGRAM_CHAT_IDS"))
 		if err != nil {
-			logger.Fatal(err.Error())
+			logger.Fatal("parsing telegram chat ids", wdlogger.NewErrorField("error", err))
 		}
 
-		nt := services.NewNightTariff(int(tariff2From), int(tariff2To))
-		tariffs = append(tariffs, nt)
+		if err = telegramCfg.Validate(); err != nil {
+			logger.Fatal("validating telegram bot config", wdlogger.NewErrorField("error", err))
+		}
 
-		logger.Info(
-			"night tariff applied",
-			wdlogger.NewInt64Field("from", tariff2From),
-			wdlogger.NewInt64Field("to", tariff2To),
-		)
+		telegramBot := services.NewTelegramBot(telegramCfg, telegram.NewClient(telegramClientCfg), stateStore, logger)
+		alerter.AddNotifier(telegramBot)
+
+		go telegramBot.Run(ctx)
 	}
 
-	tariffSelector := services.NewTariffSelector(time.Now, tariffs)
-	tariffSelector = tariffSelector
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
-	metricsService := services.NewMetrics(logger, tariffSelector)
-	go metricsService.Run(ctx)
+	// Configure current state API
 
-	exporterPullInterval, err := time.ParseDuration(os.Getenv("MYHEAT_EXPORTER_PULL_INTERVAL"))
-	if err != nil {
-		logger.Fatal("validating exporter config", wdlogger.NewErrorField("error", err))
+	// По умолчанию данные считаются устаревшими, если не обновлялись три интервала опроса
+	apiStaleAfter := exporterPullInterval * 3
+
+	if staleAfterRaw := os.Getenv("MYHEAT_API_STALE_AFTER"); staleAfterRaw != "" {
+		apiStaleAfter, err = time.ParseDuration(staleAfterRaw)
+		if err != nil {
+			logger.Fatal("parsing api stale after", wdlogger.NewErrorField("error", err))
+		}
 	}
 
-	expCfg := services.NewExporterConfig(exporterPullInterval)
-	exp := services.NewExporter(expCfg, myheatClient, logger, metricsService)
+	api.NewDevicesHandler(stateStore, apiStaleAfter, logger).Register(mux)
+
+	eventStream := services.NewEventStream(logger)
+	exp.Subscribe(eventStream)
+
+	go eventStream.Run(ctx)
+
+	api.NewEventsHandler(eventStream, logger).Register(mux)
+
+	// Configure local history
+	if historyDB := os.Getenv("MYHEAT_HISTORY_DB"); historyDB != "" {
+		historyCfg := services.NewDefaultHistoryRecorderConfig()
+
+		if retentionRaw := os.Getenv("MYHEAT_HISTORY_RETENTION"); retentionRaw != "" {
+			historyCfg.Retention, err = time.ParseDuration(retentionRaw)
+			if err != nil {
+				logger.Fatal("parsing history retention", wdlogger.NewErrorField("error", err))
+			}
+		}
+
+		if err = historyCfg.Validate(); err != nil {
+			logger.Fatal("validating history config", wdlogger.NewErrorField("error", err))
+		}
+
+		historyStore, err := history.Open(historyDB)
+		if err != nil {
+			logger.Fatal("opening history database", wdlogger.NewErrorField("error", err))
+		}
+		defer historyStore.Close()
+
+		historyRecorder := services.NewHistoryRecorder(historyCfg, historyStore, logger)
+		exp.Subscribe(historyRecorder)
+
+		go historyRecorder.Run(ctx)
+
+		api.NewHistoryHandler(historyStore, logger).Register(mux)
+	}
 
 	go exp.Run(ctx)
 
-	httpServer := http.Server{
-		Addr: ":3000",
+	// Configure HTTP server
+	serverCfg := services.NewDefaultServerConfig()
+
+	if listenAddress := os.Getenv("MYHEAT_EXPORTER_LISTEN_ADDRESS"); listenAddress != "" {
+		serverCfg.ListenAddress = listenAddress
 	}
 
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		err := httpServer.ListenAndServe()
+	serverCfg.WebConfigFile = os.Getenv("MYHEAT_EXPORTER_WEB_CONFIG_FILE")
+	serverCfg.BearerToken = os.Getenv("MYHEAT_EXPORTER_BEARER_TOKEN")
+
+	if shutdownTimeoutRaw := os.Getenv("MYHEAT_EXPORTER_SHUTDOWN_TIMEOUT"); shutdownTimeoutRaw != "" {
+		serverCfg.ShutdownTimeout, err = time.ParseDuration(shutdownTimeoutRaw)
 		if err != nil {
-			logger.Panic("unexpected error", wdlogger.NewErrorField("error", err))
+			logger.Fatal("parsing shutdown timeout", wdlogger.NewErrorField("error", err))
 		}
+	}
 
-		<-ctx.Done()
+	if err = serverCfg.Validate(); err != nil {
+		logger.Fatal("validating http server config", wdlogger.NewErrorField("error", err))
+	}
 
-		err = httpServer.Shutdown(ctx)
+	httpServer := services.NewServer(serverCfg, logger, mux)
+
+	if err = httpServer.Run(ctx); err != nil {
+		logger.Fatal("http server error", wdlogger.NewErrorField("error", err))
+	}
+}
+
+// runOnce выполняет один опрос MyHeat API, отправляет результат в Pushgateway и возвращает код завершения
+func runOnce(ctx context.Context, logger wdlogger.Logger, exp *services.Exporter, ts *services.TariffSelector, account string) int {
+	cfg := services.NewDefaultOneShotConfig()
+	cfg.PushgatewayURL = os.Getenv("MYHEAT_PUSHGATEWAY_URL")
+	cfg.Username = os.Getenv("MYHEAT_PUSHGATEWAY_USERNAME")
+	cfg.Password = os.Getenv("MYHEAT_PUSHGATEWAY_PASSWORD")
+	cfg.StateFile = os.Getenv("MYHEAT_ONCE_STATE_FILE")
+	cfg.Account = account
+	cfg.StaleData = loadStaleDataPolicy(logger)
+	cfg.Labels = loadLabelsConfig(logger)
+
+	if job := os.Getenv("MYHEAT_PUSHGATEWAY_JOB"); job != "" {
+		cfg.Job = job
+	}
+
+	if maxGapRaw := os.Getenv("MYHEAT_ONCE_MAX_GAP"); maxGapRaw != "" {
+		maxGap, err := time.ParseDuration(maxGapRaw)
 		if err != nil {
-			logger.Fatal("error while shutting down http server", wdlogger.NewErrorField("error", err))
-			return
+			logger.Fatal("parsing once max gap", wdlogger.NewErrorField("error", err))
 		}
-	}()
 
-	<-ctx.Done()
+		cfg.MaxGap = maxGap
+	}
+
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("validating once config", wdlogger.NewErrorField("error", err))
+	}
+
+	oneShot := services.NewOneShot(cfg, ts, logger)
+	exp.Subscribe(oneShot)
+
+	if err := exp.Pull(ctx); err != nil {
+		logger.Error("error while pulling data", wdlogger.NewErrorField("error", err))
+		return exitCodePullFailed
+	}
+
+	if err := oneShot.Push(ctx); err != nil {
+		logger.Error("error while pushing data", wdlogger.NewErrorField("error", err))
+		return exitCodePushFailed
+	}
+
+	logger.Info("data pushed to pushgateway")
+
+	return 0
 }